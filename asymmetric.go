@@ -0,0 +1,234 @@
+package config
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// publicKeyPrefix and privateKeyPrefix mark the text encoding of
+	// X25519 keys produced by GenerateKeyPair.
+	publicKeyPrefix  = "x25519-public:"
+	privateKeyPrefix = "x25519-private:"
+
+	x25519Algorithm = "x25519"
+	x25519HKDFInfo  = "inovacc/config ENC x25519 v1"
+	x25519KeyIDSize = 8
+)
+
+// GenerateKeyPair creates an X25519 key pair for asymmetric encryption of
+// configuration values. The public key can be committed to the repository
+// and used by developers with SetRecipientKey; the private key must only be
+// installed on hosts that need to read the configuration, via SetPrivateKey.
+//
+// Example:
+//
+//	pub, priv, err := config.GenerateKeyPair()
+//	// pub  = "x25519-public:..."
+//	// priv = "x25519-private:..."
+func GenerateKeyPair() (publicKey, privateKey string, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generating key pair: %w", err)
+	}
+
+	return publicKeyPrefix + base64.RawStdEncoding.EncodeToString(priv.PublicKey().Bytes()),
+		privateKeyPrefix + base64.RawStdEncoding.EncodeToString(priv.Bytes()),
+		nil
+}
+
+// SetRecipientKey sets the public key that EncryptValue encrypts to. Once
+// a recipient is set, EncryptValue produces asymmetric envelopes that can
+// only be decrypted with the matching private key, even if a symmetric key
+// was also set with SetEncryptionKey. An empty string clears the recipient.
+//
+// Example:
+//
+//	err := config.SetRecipientKey(os.Getenv("CONFIG_PUBLIC_KEY"))
+func SetRecipientKey(publicKey string) error {
+	var pub *ecdh.PublicKey
+
+	if publicKey != "" {
+		var err error
+		if pub, err = parsePublicKey(publicKey); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	globalConfig.recipientKey = pub
+
+	return nil
+}
+
+// SetPrivateKey sets the private key used to decrypt values that were
+// encrypted to its public key. Symmetric ENC[...] values in the same file
+// keep using the key set with SetEncryptionKey. An empty string clears the
+// private key.
+//
+// Must be called before InitServiceConfig if the config file contains
+// asymmetrically encrypted values.
+//
+// Example:
+//
+//	err := config.SetPrivateKey(os.Getenv("CONFIG_PRIVATE_KEY"))
+func SetPrivateKey(privateKey string) error {
+	var priv *ecdh.PrivateKey
+
+	if privateKey != "" {
+		var err error
+		if priv, err = parsePrivateKey(privateKey); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	globalConfig.privateKey = priv
+
+	return nil
+}
+
+func parsePublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := decodeKey(s, publicKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return pub, nil
+}
+
+func parsePrivateKey(s string) (*ecdh.PrivateKey, error) {
+	raw, err := decodeKey(s, privateKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	priv, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	return priv, nil
+}
+
+func decodeKey(s, prefix string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), prefix)
+	if !ok {
+		return nil, fmt.Errorf("missing %q prefix", prefix)
+	}
+
+	return base64.RawStdEncoding.DecodeString(encoded)
+}
+
+// keyID returns a short identifier of a public key, stored in envelopes so
+// that decrypting with the wrong private key fails with a clear error.
+func keyID(pub *ecdh.PublicKey) string {
+	h := sha256.Sum256(pub.Bytes())
+	return base64.RawStdEncoding.EncodeToString(h[:x25519KeyIDSize])
+}
+
+// isAsymmetricEnvelope reports whether the content of an ENC[...] value was
+// produced by encryptX25519.
+func isAsymmetricEnvelope(encoded string) bool {
+	return strings.HasPrefix(encoded, x25519Algorithm+kdfSeparator)
+}
+
+// encryptX25519 encrypts plaintext to pub and returns the envelope content
+// "x25519$k=<key id>$<ephemeral public key>$<nonce||ciphertext>". The AES
+// key is derived with HKDF-SHA256 from the X25519 shared secret of a fresh
+// ephemeral key. aad, if not nil, is authenticated as GCM associated data.
+func encryptX25519(pub *ecdh.PublicKey, plaintext, aad []byte) (string, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("generating ephemeral key: %w", err)
+	}
+
+	key, err := x25519SharedKey(ephemeral, pub, ephemeral.PublicKey(), pub)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := encryptAESGCM(key, plaintext, aad)
+	if err != nil {
+		return "", err
+	}
+
+	return x25519Algorithm + kdfSeparator +
+		"k=" + keyID(pub) + kdfSeparator +
+		base64.RawStdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()) + kdfSeparator +
+		base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decryptX25519 reverses encryptX25519 with the recipient's private key.
+func decryptX25519(priv *ecdh.PrivateKey, encoded string, aad []byte) ([]byte, error) {
+	parts := strings.Split(encoded, kdfSeparator)
+	if len(parts) != kdfFieldCount {
+		return nil, fmt.Errorf("malformed envelope: expected %d fields, got %d", kdfFieldCount, len(parts))
+	}
+
+	params, err := parseKDFParams(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if id := keyID(priv.PublicKey()); params["k"] != id {
+		return nil, fmt.Errorf("value was encrypted for recipient key %s, but the private key is for %s", params["k"], id)
+	}
+
+	rawEphemeral, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding ephemeral key: %w", err)
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(rawEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("decoding encrypted value: %w", err)
+	}
+
+	key, err := x25519SharedKey(priv, ephemeral, ephemeral, priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptAESGCM(key, ciphertext, aad)
+}
+
+// x25519SharedKey derives the AES-256 key from the shared secret of priv
+// and peer. The ephemeral and recipient public keys are bound into the HKDF
+// salt.
+func x25519SharedKey(priv *ecdh.PrivateKey, peer, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("computing shared secret: %w", err)
+	}
+
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+
+	key := make([]byte, kdfKeySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(x25519HKDFInfo)), key); err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+
+	return key, nil
+}
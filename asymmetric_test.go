@@ -0,0 +1,188 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsymmetricRoundTrip(t *testing.T) {
+	resetGlobalConfig(t)
+
+	pub, priv, err := GenerateKeyPair()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(pub, "x25519-public:"))
+	assert.True(t, strings.HasPrefix(priv, "x25519-private:"))
+
+	require.NoError(t, SetRecipientKey(pub))
+
+	encrypted, err := EncryptValue("asymmetric-secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "ENC[x25519$k="), encrypted)
+	assert.True(t, IsEncryptedValue(encrypted))
+
+	// The public key alone cannot decrypt.
+	_, err = DecryptValue(encrypted)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "private key not set")
+	}
+
+	require.NoError(t, SetPrivateKey(priv))
+
+	decrypted, err := DecryptValue(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "asymmetric-secret", decrypted)
+}
+
+func TestAsymmetricRecipientTakesPrecedence(t *testing.T) {
+	resetGlobalConfig(t)
+
+	pub, _, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	SetEncryptionKey([]byte("symmetric-key"))
+	require.NoError(t, SetRecipientKey(pub))
+
+	encrypted, err := EncryptValue("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "ENC[x25519$"), encrypted)
+}
+
+func TestAsymmetricWrongPrivateKey(t *testing.T) {
+	resetGlobalConfig(t)
+
+	pub, _, err := GenerateKeyPair()
+	require.NoError(t, err)
+	_, otherPriv, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	require.NoError(t, SetRecipientKey(pub))
+	encrypted, err := EncryptValue("secret")
+	require.NoError(t, err)
+
+	require.NoError(t, SetPrivateKey(otherPriv))
+	_, err = DecryptValue(encrypted)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "was encrypted for recipient key")
+	}
+}
+
+func TestAsymmetricTamperedValue(t *testing.T) {
+	resetGlobalConfig(t)
+
+	pub, priv, err := GenerateKeyPair()
+	require.NoError(t, err)
+	require.NoError(t, SetRecipientKey(pub))
+	require.NoError(t, SetPrivateKey(priv))
+
+	encrypted, err := EncryptValue("secret")
+	require.NoError(t, err)
+
+	// Swap the ephemeral key for one generated by another encryption.
+	other, err := EncryptValue("other")
+	require.NoError(t, err)
+
+	parts := strings.Split(encrypted, "$")
+	parts[2] = strings.Split(other, "$")[2]
+
+	_, err = DecryptValue(strings.Join(parts, "$"))
+	assert.Error(t, err)
+}
+
+func TestAsymmetricInvalidKeys(t *testing.T) {
+	resetGlobalConfig(t)
+
+	pub, priv, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		set     func(string) error
+		key     string
+		wantErr string
+	}{
+		{"private as public", SetRecipientKey, priv, "invalid public key"},
+		{"public as private", SetPrivateKey, pub, "invalid private key"},
+		{"bad base64", SetRecipientKey, "x25519-public:!!", "invalid public key"},
+		{"short key", SetPrivateKey, "x25519-private:AAAA", "invalid private key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.set(tt.key)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMixedSymmetricAndAsymmetricConfigFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	pub, priv, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	require.NoError(t, SetRecipientKey(pub))
+	encPassword, err := EncryptValue("x25519-password")
+	require.NoError(t, err)
+
+	// Reading a config only needs the private key; the symmetric key is
+	// still used for the legacy value.
+	resetGlobalConfig(t)
+	SetEncryptionKey([]byte("legacy-key"))
+	require.NoError(t, SetPrivateKey(priv))
+
+	configContent := `
+appID: validappid12345
+appSecret: ` + legacyEncrypted + `
+logger:
+  logLevel: DEBUG
+service:
+  username: plainuser
+  password: ` + encPassword + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&customService{}, configPath)
+	require.NoError(t, err)
+
+	cfg := GetBaseConfig()
+	assert.Equal(t, "legacy-secret", cfg.AppSecret)
+
+	svc, err := GetServiceConfig[*customService]()
+	require.NoError(t, err)
+	assert.Equal(t, "x25519-password", svc.Password)
+}
+
+func TestAsymmetricConfigFileWithoutPrivateKey(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	pub, _, err := GenerateKeyPair()
+	require.NoError(t, err)
+
+	require.NoError(t, SetRecipientKey(pub))
+	encPassword, err := EncryptValue("x25519-password")
+	require.NoError(t, err)
+
+	resetGlobalConfig(t)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  password: ` + encPassword + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&customService{}, configPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "private key not set")
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/inovacc/config"
+	"github.com/inovacc/config/internal/document"
+	"gopkg.in/yaml.v3"
+)
+
+// keySource reads an encryption key from an environment variable or a file.
+type keySource struct {
+	env  string
+	file string
+}
+
+// register adds the -<prefix>key-env and -<prefix>key-file flags to fs.
+// With no default environment variable, one of the flags is required.
+func (k *keySource) register(fs *flag.FlagSet, prefix, what, defaultEnv string) {
+	fs.StringVar(&k.env, prefix+"key-env", defaultEnv, "environment variable holding the "+what)
+	fs.StringVar(&k.file, prefix+"key-file", "", "file holding the "+what+" (overrides -"+prefix+"key-env)")
+}
+
+func (k *keySource) load() ([]byte, error) {
+	if k.file != "" {
+		data, err := os.ReadFile(k.file)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+
+		key := bytes.TrimRight(data, "\r\n")
+		if len(key) == 0 {
+			return nil, fmt.Errorf("key file %s is empty", k.file)
+		}
+		return key, nil
+	}
+
+	key := os.Getenv(k.env)
+	if key == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", k.env)
+	}
+
+	return []byte(key), nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func newFlagSet(name, synopsis string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: config %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+func runEncrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("encrypt", "encrypt [flags] <value|->\n       config encrypt [flags] -file config.yaml [-path key]...", stderr)
+
+	var (
+		key       keySource
+		paths     stringList
+		file      string
+		kdf       string
+		recipient string
+		keyPath   string
+		heuristic bool
+		bind      bool
+	)
+	key.register(fs, "", "encryption key", "CONFIG_KEY")
+	fs.StringVar(&recipient, "recipient", "", "encrypt to this X25519 public key instead of a symmetric key")
+	fs.StringVar(&file, "file", "", "encrypt values of this YAML/JSON file in place")
+	fs.Var(&paths, "path", "dotted key to encrypt, e.g. service.db.password (repeatable; default: keys tagged sensitive)")
+	fs.BoolVar(&heuristic, "heuristic", false, "also encrypt keys whose names look like secrets")
+	fs.BoolVar(&bind, "bind", false, "bind each value of -file to its key path")
+	fs.StringVar(&keyPath, "key-path", "", "bind a single value to this dotted key path, e.g. service.adminToken")
+	fs.StringVar(&kdf, "kdf", string(config.KDFSHA256), "key derivation function: sha256, hkdf, argon2id or scrypt")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := config.SetRecipientKey(recipient); err != nil {
+		return err
+	}
+
+	if recipient == "" {
+		if err := setKey(key, kdf); err != nil {
+			return err
+		}
+	}
+
+	if file == "" {
+		value, err := valueArg(fs, stdin)
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := encryptValue(keyPath, value)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(stdout, encrypted)
+		return err
+	}
+
+	doc, err := loadDocument(file)
+	if err != nil {
+		return err
+	}
+
+	targets := []string(paths)
+	if len(targets) == 0 {
+		targets = document.SensitiveKeys(reflect.TypeOf(config.Config{}), "")
+	}
+
+	count := 0
+	encrypt := func(path string, node *yaml.Node) error {
+		if node.ShortTag() != "!!str" {
+			return fmt.Errorf("%s: only string values can be encrypted, got %s", path, node.ShortTag())
+		}
+		if node.Value == "" || config.IsEncryptedValue(node.Value) {
+			return nil
+		}
+
+		bindPath := ""
+		if bind {
+			bindPath = path
+		}
+
+		encrypted, err := encryptValue(bindPath, node.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		document.SetString(node, encrypted)
+		count++
+
+		return nil
+	}
+
+	for _, target := range targets {
+		err = doc.Walk(target, encrypt)
+		if errors.Is(err, document.ErrNotFound) && len(paths) == 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if heuristic {
+		patterns, err := secretPatterns()
+		if err != nil {
+			return err
+		}
+
+		err = doc.Walk("", func(path string, node *yaml.Node) error {
+			if !patterns.MatchString(lastKey(path)) || node.ShortTag() != "!!str" {
+				return nil
+			}
+			return encrypt(path, node)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = saveDocument(file, doc); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(stderr, "encrypted %d value(s) in %s\n", count, file)
+
+	return nil
+}
+
+func runDecrypt(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("decrypt", "decrypt [flags] <value|->\n       config decrypt [flags] -file config.yaml", stderr)
+
+	var (
+		key      keySource
+		file     string
+		identity string
+		keyPath  string
+	)
+	key.register(fs, "", "encryption key", "CONFIG_KEY")
+	fs.StringVar(&file, "file", "", "print this YAML/JSON file with its values decrypted")
+	fs.StringVar(&keyPath, "key-path", "", "dotted key path a single value is bound to")
+	fs.StringVar(&identity, "identity-file", "", "file holding the X25519 private key for values encrypted with -recipient")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := setIdentity(identity); err != nil {
+		return err
+	}
+
+	// Files may mix symmetric and asymmetric values, so with an identity
+	// the symmetric key is optional.
+	if err := setKey(key, ""); err != nil && (identity == "" || key.file != "" || os.Getenv(key.env) != "") {
+		return err
+	}
+
+	if file == "" {
+		value, err := valueArg(fs, stdin)
+		if err != nil {
+			return err
+		}
+
+		plain, err := config.DecryptValueForKey(keyPath, value)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(stdout, plain)
+		return err
+	}
+
+	doc, err := loadDocument(file)
+	if err != nil {
+		return err
+	}
+
+	err = doc.Walk("", func(path string, node *yaml.Node) error {
+		if !config.IsEncryptedValue(node.Value) {
+			return nil
+		}
+
+		plain, err := config.DecryptValueForKey(path, node.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		document.SetString(node, plain)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	out, err := doc.Encode()
+	if err != nil {
+		return err
+	}
+
+	_, err = stdout.Write(out)
+	return err
+}
+
+func runRotate(args []string, stderr io.Writer) error {
+	fs := newFlagSet("rotate", "rotate [flags] -file config.yaml", stderr)
+
+	var (
+		oldKey, newKey keySource
+		file, kdf      string
+		bind           bool
+	)
+	oldKey.register(fs, "old-", "current encryption key", "CONFIG_KEY")
+	newKey.register(fs, "new-", "new encryption key", "")
+	fs.StringVar(&file, "file", "", "YAML/JSON file to re-encrypt in place (required)")
+	fs.StringVar(&kdf, "kdf", string(config.KDFSHA256), "key derivation function for the new key: sha256, hkdf, argon2id or scrypt")
+	fs.BoolVar(&bind, "bind", false, "bind every value to its key path (values already bound stay bound)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if file == "" {
+		fs.Usage()
+		return fmt.Errorf("rotate: -file is required")
+	}
+
+	if newKey.env == "" && newKey.file == "" {
+		fs.Usage()
+		return fmt.Errorf("rotate: -new-key-env or -new-key-file is required")
+	}
+
+	doc, err := loadDocument(file)
+	if err != nil {
+		return err
+	}
+
+	// Decrypt everything with the old key before switching keys.
+	if err = setKey(oldKey, ""); err != nil {
+		return fmt.Errorf("old key: %w", err)
+	}
+
+	var nodes []*yaml.Node
+	var plaintexts, bindPaths []string
+
+	err = doc.Walk("", func(path string, node *yaml.Node) error {
+		if !config.IsEncryptedValue(node.Value) {
+			return nil
+		}
+
+		plain, err := config.DecryptValueForKey(path, node.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		bindPath := ""
+		if bind || config.IsKeyBoundValue(node.Value) {
+			bindPath = path
+		}
+
+		nodes = append(nodes, node)
+		plaintexts = append(plaintexts, plain)
+		bindPaths = append(bindPaths, bindPath)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = setKey(newKey, kdf); err != nil {
+		return fmt.Errorf("new key: %w", err)
+	}
+
+	for i, node := range nodes {
+		encrypted, err := encryptValue(bindPaths[i], plaintexts[i])
+		if err != nil {
+			return err
+		}
+		document.SetString(node, encrypted)
+	}
+
+	if err = saveDocument(file, doc); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(stderr, "rotated %d value(s) in %s\n", len(nodes), file)
+
+	return nil
+}
+
+// setKey configures the package-level encryption key and, if kdf is not
+// empty, the key derivation function for new values.
+func setKey(src keySource, kdf string) error {
+	key, err := src.load()
+	if err != nil {
+		return err
+	}
+
+	config.SetEncryptionKey(key)
+
+	if kdf == "" {
+		return nil
+	}
+
+	return config.SetKeyDerivation(config.KeyDerivation{Algorithm: config.KDF(kdf)})
+}
+
+// encryptValue encrypts value, binding it to keyPath unless it is empty.
+func encryptValue(keyPath, value string) (string, error) {
+	if keyPath == "" {
+		return config.EncryptValue(value)
+	}
+
+	return config.EncryptValueForKey(keyPath, value)
+}
+
+// setIdentity configures the private key read from file, or clears it when
+// file is empty.
+func setIdentity(file string) error {
+	if file == "" {
+		return config.SetPrivateKey("")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading identity file: %w", err)
+	}
+
+	return config.SetPrivateKey(string(data))
+}
+
+func runKeygen(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("keygen", "keygen [-out private.key]", stderr)
+
+	var out string
+	fs.StringVar(&out, "out", "", "write the private key to this file (mode 0600) instead of stdout")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pub, priv, err := config.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err = fmt.Fprintf(stdout, "%s\n%s\n", pub, priv)
+		return err
+	}
+
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("creating private key file: %w", err)
+	}
+
+	_, err = fmt.Fprintln(f, priv)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing private key file: %w", err)
+	}
+
+	_, err = fmt.Fprintln(stdout, pub)
+	return err
+}
+
+// valueArg returns the single positional value, reading stdin for "-".
+func valueArg(fs *flag.FlagSet, stdin io.Reader) (string, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return "", fmt.Errorf("%s: expected exactly one value or -file", fs.Name())
+	}
+
+	if fs.Arg(0) != "-" {
+		return fs.Arg(0), nil
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", fmt.Errorf("reading stdin: %w", err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func loadDocument(file string) (*document.Document, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return document.Parse(data, strings.TrimPrefix(filepath.Ext(file), "."))
+}
+
+// saveDocument writes doc back to file atomically, keeping its permissions.
+func saveDocument(file string, doc *document.Document) error {
+	out, err := doc.Encode()
+	if err != nil {
+		return err
+	}
+
+	return document.WriteFile(file, out)
+}
+
+// secretPatterns compiles config.DefaultSecretPatterns into one
+// case-insensitive expression.
+func secretPatterns() (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)(" + strings.Join(config.DefaultSecretPatterns, ")|(") + ")")
+}
+
+// lastKey returns the final mapping key of a dotted path, without indices.
+func lastKey(path string) string {
+	for strings.HasSuffix(path, "]") {
+		i := strings.LastIndex(path, "[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[i+1:]
+	}
+
+	return path
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/inovacc/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `# Application config
+appID: my-app-id-12345
+appSecret: plain-app-secret # generated
+
+service:
+  db:
+    host: db.local
+    password: db-pass
+  stripeToken: tok_123
+  port: 8080
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func runCmd(t *testing.T, stdin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestEncryptDecryptValue(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "cli-key")
+
+	out, err := runCmd(t, "", "encrypt", "-kdf", "hkdf", "hello")
+	require.NoError(t, err)
+	encrypted := strings.TrimSpace(out)
+	assert.True(t, config.IsEncryptedValue(encrypted))
+
+	out, err = runCmd(t, encrypted+"\n", "decrypt", "-")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", out)
+}
+
+func TestEncryptValueKeyFile(t *testing.T) {
+	keyFile := writeFile(t, "key", "file-key\n")
+
+	out, err := runCmd(t, "", "encrypt", "-key-file", keyFile, "value")
+	require.NoError(t, err)
+
+	out, err = runCmd(t, "", "decrypt", "-key-file", keyFile, strings.TrimSpace(out))
+	require.NoError(t, err)
+	assert.Equal(t, "value\n", out)
+}
+
+func TestEncryptMissingKey(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "")
+
+	_, err := runCmd(t, "", "encrypt", "value")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "CONFIG_KEY is not set")
+	}
+}
+
+func TestEncryptFileSensitiveKeys(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "file-key")
+	path := writeFile(t, "config.yaml", testConfig)
+
+	_, err := runCmd(t, "", "encrypt", "-file", path)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	content := string(data)
+
+	// Only the tagged appSecret is encrypted, comments and layout survive
+	assert.Contains(t, content, "# Application config\n")
+	assert.Contains(t, content, "appSecret: ENC[")
+	assert.Contains(t, content, "# generated\n\nservice:")
+	assert.Contains(t, content, "password: db-pass")
+
+	out, err := runCmd(t, "", "decrypt", "-file", path)
+	require.NoError(t, err)
+	assert.Equal(t, testConfig, out)
+}
+
+func TestEncryptFileSelectedAndHeuristicKeys(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "file-key")
+	path := writeFile(t, "config.yaml", testConfig)
+
+	_, err := runCmd(t, "", "encrypt", "-file", path, "-path", "service.db.password", "-heuristic")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	content := string(data)
+
+	assert.Contains(t, content, "password: ENC[")
+	assert.Contains(t, content, "stripeToken: ENC[")
+	assert.Contains(t, content, "appSecret: ENC[")
+	assert.Contains(t, content, "host: db.local")
+
+	// Encrypting again leaves already encrypted values alone
+	_, err = runCmd(t, "", "encrypt", "-file", path, "-path", "service.db.password")
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+}
+
+func TestEncryptFileErrors(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "file-key")
+	path := writeFile(t, "config.yaml", testConfig)
+
+	_, err := runCmd(t, "", "encrypt", "-file", path, "-path", "service.port")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only string values can be encrypted")
+	}
+
+	_, err = runCmd(t, "", "encrypt", "-file", path, "-path", "service.missing")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "key not found")
+	}
+}
+
+func TestEncryptFileJSON(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "json-key")
+	path := writeFile(t, "config.json", `{"zeta": 1, "appSecret": "json-secret", "appID": "json-app-id"}`)
+
+	_, err := runCmd(t, "", "encrypt", "-file", path)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Regexp(t, `^\{\n  "zeta": 1,\n  "appSecret": "ENC\[.*\]",\n  "appID": "json-app-id"\n\}\n$`, string(data))
+}
+
+func TestRotate(t *testing.T) {
+	t.Setenv("OLD_KEY", "old-key")
+	t.Setenv("NEW_KEY", "new-key")
+	t.Setenv("CONFIG_KEY", "old-key")
+
+	path := writeFile(t, "config.yaml", testConfig)
+	_, err := runCmd(t, "", "encrypt", "-file", path, "-path", "appSecret", "-path", "service.db.password")
+	require.NoError(t, err)
+
+	_, err = runCmd(t, "", "rotate", "-old-key-env", "OLD_KEY", "-new-key-env", "NEW_KEY", "-kdf", "scrypt", "-file", path)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "ENC[scrypt$"))
+
+	// The old key no longer decrypts the file
+	_, err = runCmd(t, "", "decrypt", "-key-env", "OLD_KEY", "-file", path)
+	assert.Error(t, err)
+
+	out, err := runCmd(t, "", "decrypt", "-key-env", "NEW_KEY", "-file", path)
+	require.NoError(t, err)
+	assert.Equal(t, testConfig, out)
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	_, err := runCmd(t, "", "frobnicate")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown command")
+	}
+
+	_, err = runCmd(t, "", "rotate")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "-file is required")
+	}
+
+	// The new key has no default, so that the file is not rotated to the
+	// key it is already encrypted with.
+	_, err = runCmd(t, "", "rotate", "-file", "config.yaml")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "-new-key-env or -new-key-file is required")
+	}
+}
+
+func TestLastKey(t *testing.T) {
+	assert.Equal(t, "token", lastKey("service.creds[0].token"))
+	assert.Equal(t, "tokens", lastKey("service.tokens[1]"))
+	assert.Equal(t, "key1", lastKey("service.key1"))
+	assert.Equal(t, "appSecret", lastKey("appSecret"))
+}
+
+func TestKeygenRecipientIdentity(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "")
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "private.key")
+
+	pub, err := runCmd(t, "", "keygen", "-out", keyFile)
+	require.NoError(t, err)
+	pub = strings.TrimSpace(pub)
+	assert.True(t, strings.HasPrefix(pub, "x25519-public:"), pub)
+
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// keygen never overwrites an existing private key.
+	_, err = runCmd(t, "", "keygen", "-out", keyFile)
+	assert.Error(t, err)
+
+	path := writeFile(t, "config.yaml", testConfig)
+	_, err = runCmd(t, "", "encrypt", "-recipient", pub, "-file", path)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "appSecret: ENC[x25519$")
+
+	// Without the private key the file cannot be decrypted.
+	_, err = runCmd(t, "", "decrypt", "-file", path)
+	assert.Error(t, err)
+
+	out, err := runCmd(t, "", "decrypt", "-identity-file", keyFile, "-file", path)
+	require.NoError(t, err)
+	assert.Equal(t, testConfig, out)
+}
+
+func TestEncryptBindRotate(t *testing.T) {
+	t.Setenv("CONFIG_KEY", "bind-key")
+	t.Setenv("NEW_KEY", "new-bind-key")
+
+	path := writeFile(t, "config.yaml", testConfig)
+	_, err := runCmd(t, "", "encrypt", "-bind", "-file", path, "-path", "appSecret", "-path", "service.db.password")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "ENC[kp$"))
+
+	_, err = runCmd(t, "", "rotate", "-new-key-env", "NEW_KEY", "-file", path)
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "ENC[kp$"), "rotate keeps values bound")
+
+	out, err := runCmd(t, "", "decrypt", "-key-env", "NEW_KEY", "-file", path)
+	require.NoError(t, err)
+	assert.Equal(t, testConfig, out)
+
+	// Swapping bound values between keys is detected.
+	doc := string(data)
+	secret := regexp.MustCompile(`appSecret: (ENC\[\S+\])`).FindStringSubmatch(doc)[1]
+	swapped := regexp.MustCompile(`password: ENC\[\S+\]`).ReplaceAllString(doc, "password: "+secret)
+	require.NoError(t, os.WriteFile(path, []byte(swapped), 0600))
+
+	_, err = runCmd(t, "", "decrypt", "-key-env", "NEW_KEY", "-file", path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "service.db.password")
+	}
+
+	encrypted, err := runCmd(t, "", "encrypt", "-key-path", "service.adminToken", "admin")
+	require.NoError(t, err)
+
+	plain, err := runCmd(t, "", "decrypt", "-key-path", "service.adminToken", strings.TrimSpace(encrypted))
+	require.NoError(t, err)
+	assert.Equal(t, "admin\n", plain)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/inovacc/config/internal/docs"
+)
+
+func runDocs(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("docs", "docs -schema config.schema.json [-format markdown|text] [-env-prefix APP]", stderr)
+
+	var schemaFile, format, envPrefix string
+	fs.StringVar(&schemaFile, "schema", "", "JSON Schema of the configuration, as generated by config.JSONSchema")
+	fs.StringVar(&format, "format", docs.Markdown, "output format: markdown or text")
+	fs.StringVar(&envPrefix, "env-prefix", "", "prefix set with config.SetEnvPrefix, to list environment variable names")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if schemaFile == "" {
+		fs.Usage()
+		return fmt.Errorf("docs: -schema is required")
+	}
+
+	schema, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return fmt.Errorf("reading schema: %w", err)
+	}
+
+	out, err := docs.Render(schema, docs.Options{Format: format, EnvPrefix: envPrefix})
+	if err != nil {
+		return err
+	}
+
+	_, err = stdout.Write(out)
+	return err
+}
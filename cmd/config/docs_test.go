@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/inovacc/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type docsService struct {
+	Port int `yaml:"port" default:"8080" description:"Port to listen on"`
+}
+
+func TestDocs(t *testing.T) {
+	schema, err := config.JSONSchema[*docsService]()
+	require.NoError(t, err)
+	schemaFile := writeFile(t, "config.schema.json", string(schema))
+
+	out, err := runCmd(t, "", "docs", "-schema", schemaFile, "-env-prefix", "APP")
+	require.NoError(t, err)
+	assert.Contains(t, out, "| `service.port` | integer | 8080 |  |  | `APP_SERVICE_PORT` | Port to listen on |\n")
+
+	out, err = runCmd(t, "", "docs", "-schema", schemaFile, "-format", "text")
+	require.NoError(t, err)
+	assert.Regexp(t, `(?m)^service\.port +integer +8080 +Port to listen on$`, out)
+}
+
+func TestDocsErrors(t *testing.T) {
+	_, err := runCmd(t, "", "docs")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-schema is required")
+
+	schemaFile := writeFile(t, "config.schema.json", `{"type": "object"}`)
+	_, err = runCmd(t, "", "docs", "-schema", schemaFile, "-format", "html")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported docs format")
+
+	_, err = runCmd(t, "", "docs", "-schema", writeFile(t, "bad.json", "{"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parsing schema")
+}
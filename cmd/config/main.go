@@ -0,0 +1,79 @@
+// Command config manages encrypted values in configuration files used with
+// github.com/inovacc/config.
+//
+// Usage:
+//
+//	config encrypt [key flags] [-kdf name] [-key-path key] <value|->
+//	config encrypt [key flags] [-kdf name] -file config.yaml [-path key]... [-heuristic] [-bind]
+//	config decrypt [key flags] [-key-path key] <value|->
+//	config decrypt [key flags] -file config.yaml
+//	config rotate [old key flags] [new key flags] [-kdf name] [-bind] -file config.yaml
+//	config keygen [-out private.key]
+//	config docs -schema config.schema.json [-format markdown|text] [-env-prefix APP]
+//
+// Keys are read from an environment variable (-key-env, default CONFIG_KEY)
+// or from a file (-key-file). For rotate the flags are prefixed with "old-"
+// and "new-". Values can instead be encrypted to an X25519 public key
+// created by keygen (encrypt -recipient) and decrypted with its private key
+// (decrypt -identity-file). With -bind and -key-path, values are bound to
+// their key path and fail to decrypt if moved to another key.
+//
+// docs prints reference documentation for every configuration key described
+// by a JSON Schema generated with config.JSONSchema.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `Usage: config <command> [flags]
+
+Commands:
+  encrypt   Encrypt a single value, or the sensitive keys of a file in place
+  decrypt   Decrypt a single value, or print a file with its values decrypted
+  rotate    Re-encrypt every ENC[...] value of a file with a new key
+  keygen    Generate an X25519 key pair for asymmetric encryption
+  docs      Print reference documentation for the keys of a JSON Schema
+
+Run "config <command> -h" for the flags of a command.
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			_, _ = fmt.Fprintln(os.Stderr, "config:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run executes the command line args and is the testable entry point.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	}
+
+	switch args[0] {
+	case "encrypt":
+		return runEncrypt(args[1:], stdin, stdout, stderr)
+	case "decrypt":
+		return runDecrypt(args[1:], stdin, stdout, stderr)
+	case "rotate":
+		return runRotate(args[1:], stderr)
+	case "keygen":
+		return runKeygen(args[1:], stdout, stderr)
+	case "docs":
+		return runDocs(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		_, _ = fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		_, _ = fmt.Fprint(stderr, usage)
+		return fmt.Errorf("unknown command: %q", args[0])
+	}
+}
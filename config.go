@@ -2,18 +2,25 @@ package config
 
 import (
 	"bytes"
+	"crypto/ecdh"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"slices"
 	"strings"
 	"sync"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/google/uuid"
+	"github.com/inovacc/config/internal/document"
 	"github.com/inovacc/config/internal/viper"
 	"github.com/spf13/afero"
 	"gopkg.in/yaml.v3"
@@ -38,7 +45,7 @@ func init() {
 
 // Logger defines the configuration for structured logging.
 type Logger struct {
-	LogLevel string `yaml:"logLevel" json:"logLevel" mapstructure:"logLevel"`
+	LogLevel string `yaml:"logLevel" json:"logLevel" mapstructure:"logLevel" default:"DEBUG" description:"Minimum level of log messages: DEBUG, INFO, WARN, WARNING or ERROR, in any case"`
 }
 
 // ValidatorFunc is a function that validates the configuration.
@@ -56,20 +63,95 @@ type ValidatorFunc func(Config) error
 //   - Logger: Structured logging configuration.
 //   - Service: Service-specific configuration.
 type Config struct {
-	viper         *viper.Viper
-	envPrefix     string
-	encryptionKey []byte
-	targetVersion int
-	migrations    []migration
-	validators    []ValidatorFunc
-	Version     int    `yaml:"version" json:"version" mapstructure:"version"`
-	Environment string `yaml:"environment" json:"environment" mapstructure:"environment"`
+	viper             *viper.Viper
+	envPrefix         string
+	encryptionKey     []byte
+	derivedKeys       *kdfCache
+	keyDerivation     KeyDerivation
+	kdfSalt           []byte
+	recipientKey      *ecdh.PublicKey
+	privateKey        *ecdh.PrivateKey
+	requireKeyBinding bool
+	secretPatterns    []*regexp.Regexp
+	resolvers         map[string]SecretResolver
+	resolvedKeys      map[string]bool
+	envFileKeys       map[string]bool
+	targetVersion     int
+	baseVersion       int
+	allowDowngrade    bool
+	persistMigrations bool
+	noAutoCreate      bool
+	strictEnv         bool
+	flags             []viper.FlagValueSet
+	encryptDefaults   bool
+	schema            []byte
+	appliedMigrations []string
+	migratedFiles     []migratedFile
+	migrations        []migration
+	migrationErrs     []error
+	validators        []ValidatorFunc
+	subscribers       []*subscriber
+	ownWrite          [sha256.Size]byte
+	Version     int    `yaml:"version" json:"version" mapstructure:"version" validate:"min=0" description:"Version of the config file layout, used by migrations"`
+	Environment string `yaml:"environment" json:"environment" mapstructure:"environment" default:"dev" description:"Environment name, selecting the profile overlay file"`
 	AppVersion  string `yaml:"-" json:"-" mapstructure:"-"`
 	ConfigFile  string `yaml:"-" json:"-" mapstructure:"-"`
-	AppID       string `yaml:"appID" json:"appID" mapstructure:"appID"`
-	AppSecret   string `yaml:"appSecret" json:"appSecret" mapstructure:"appSecret" sensitive:"true"`
-	Logger      Logger `yaml:"logger" json:"logger" mapstructure:"logger"`
-	Service     any    `yaml:"service" json:"service" mapstructure:"service"`
+	AppID       string `yaml:"appID" json:"appID" mapstructure:"appID" validate:"min=8" description:"Unique application identifier, generated if empty"`
+	AppSecret   string `yaml:"appSecret" json:"appSecret" mapstructure:"appSecret" sensitive:"true" validate:"min=12" description:"Application secret, generated if empty"`
+	Logger      Logger `yaml:"logger" json:"logger" mapstructure:"logger" description:"Structured logging configuration"`
+	Service     any    `yaml:"service" json:"service" mapstructure:"service" description:"Service-specific configuration"`
+}
+
+// Option configures optional InitServiceConfig behaviour.
+type Option func(*Config)
+
+// ConfigFileNotFoundError is returned by InitServiceConfig when the config
+// file does not exist and WithoutAutoCreate was given. It matches
+// fs.ErrNotExist with errors.Is.
+type ConfigFileNotFoundError struct {
+	Path string
+}
+
+func (e ConfigFileNotFoundError) Error() string {
+	return "config file not found: " + e.Path
+}
+
+// Unwrap returns fs.ErrNotExist.
+func (e ConfigFileNotFoundError) Unwrap() error {
+	return fs.ErrNotExist
+}
+
+// WithoutAutoCreate makes InitServiceConfig fail with a
+// ConfigFileNotFoundError when the config file does not exist, instead of
+// creating a default one. Use it in production, where a missing file
+// usually means a volume failed to mount.
+//
+// Example:
+//
+//	err := config.InitServiceConfig(svc, "/etc/app/config.yaml", config.WithoutAutoCreate())
+//	if errors.As(err, new(config.ConfigFileNotFoundError)) {
+//	    log.Fatal("config volume not mounted")
+//	}
+func WithoutAutoCreate() Option {
+	return func(c *Config) {
+		c.noAutoCreate = true
+	}
+}
+
+// WithEncryptedDefaults encrypts the sensitive values of a generated
+// default config file, such as the generated AppSecret, with the key set
+// by SetEncryptionKey or SetRecipientKey. Values are bound to their key
+// path like EncryptValueForKey. Sensitive values are those masked by
+// GetSecureCopy.
+//
+// Example:
+//
+//	config.SetEncryptionKey(key)
+//	err := config.InitServiceConfig(svc, "config.yaml", config.WithEncryptedDefaults())
+func WithEncryptedDefaults() Option {
+	return func(c *Config) {
+		c.encryptDefaults = true
+	}
 }
 
 // InitServiceConfig loads a configuration file and binds a service-specific
@@ -100,10 +182,14 @@ type Config struct {
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
-func InitServiceConfig(v any, configPath string) error {
+func InitServiceConfig(v any, configPath string, opts ...Option) error {
 	mu.Lock()
 	defer mu.Unlock()
 
+	for _, opt := range opts {
+		opt(globalConfig)
+	}
+
 	afs := afero.NewOsFs()
 
 	configFile, err := filepath.Abs(configPath)
@@ -116,10 +202,18 @@ func InitServiceConfig(v any, configPath string) error {
 
 	// Check if a config file exists, create default if not
 	if !exists(afs, configFile) {
+		if globalConfig.noAutoCreate {
+			return ConfigFileNotFoundError{Path: configFile}
+		}
+
 		slog.Warn("Configuration file not found, creating default, please verify", "path", configFile)
 
-		if err := defaultConfig(configPath); err != nil {
-			return fmt.Errorf("creating default config: %w", err)
+		if err := defaultConfig(configFile); err != nil {
+			if !errors.Is(err, fs.ErrExist) {
+				return fmt.Errorf("creating default config: %w", err)
+			}
+			// Another process created it first: use theirs.
+			slog.Info("Configuration file was created concurrently, using it", "path", configFile)
 		}
 	}
 
@@ -128,11 +222,24 @@ func InitServiceConfig(v any, configPath string) error {
 		return fmt.Errorf("reading config: %w", err)
 	}
 
+	// Report prefixed environment variables that match no key
+	if err = globalConfig.checkEnv(); err != nil {
+		return err
+	}
+
 	// Run migrations if target version is set
-	if _, err = globalConfig.runMigrations(); err != nil {
+	if _, err = globalConfig.runMigrations(afs); err != nil {
 		return fmt.Errorf("running migrations: %w", err)
 	}
 
+	// Warn about values that look like secrets but are not protected
+	globalConfig.warnUntaggedSecrets()
+
+	// Resolve secret references such as secret://env/NAME or vault://kv/db
+	if err = globalConfig.resolveSecrets(); err != nil {
+		return fmt.Errorf("resolving secrets: %w", err)
+	}
+
 	// Decrypt any encrypted values
 	if err = decryptConfigFields(globalConfig); err != nil {
 		return fmt.Errorf("decrypting config: %w", err)
@@ -143,16 +250,33 @@ func InitServiceConfig(v any, configPath string) error {
 		return fmt.Errorf("setting default values: %w", err)
 	}
 
-	// Load profile-specific overrides
-	if err = globalConfig.loadProfile(afs); err != nil {
+	// Merge profile-specific overrides
+	merged, err := globalConfig.mergeProfile(afs)
+	if err != nil {
 		return fmt.Errorf("loading profile config: %w", err)
 	}
 
+	// Validate the merged settings against the schema given with WithSchema
+	if err = globalConfig.validateSchema(); err != nil {
+		return err
+	}
+
+	if merged {
+		if err = globalConfig.applyProfile(); err != nil {
+			return fmt.Errorf("loading profile config: %w", err)
+		}
+	}
+
 	// Run custom validators
 	if err = globalConfig.runValidators(); err != nil {
 		return fmt.Errorf("custom validation: %w", err)
 	}
 
+	// Write migrated files back only now that they are known to be valid
+	if err = globalConfig.writeMigratedFiles(afs); err != nil {
+		return fmt.Errorf("persisting migrations: %w", err)
+	}
+
 	// Log the configuration (safely masking sensitive values)
 	logConfigLocked()
 
@@ -281,7 +405,10 @@ func LogConfig() {
 // DefaultConfig generates a base configuration file with random credentials and
 // zeroed service configuration for a given type.
 //
-// It should be used to bootstrap a config.yaml with sensible defaults.
+// It should be used to bootstrap a config.yaml with sensible defaults. The
+// file is created with mode 0600 and never overwritten: if it already
+// exists, an error matching fs.ErrExist is returned. Pass
+// WithEncryptedDefaults to encrypt the generated credentials.
 //
 // Example:
 //
@@ -289,10 +416,14 @@ func LogConfig() {
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
-func DefaultConfig[T any](configPath string) error {
+func DefaultConfig[T any](configPath string, opts ...Option) error {
 	mu.Lock()
 	defer mu.Unlock()
 
+	for _, opt := range opts {
+		opt(globalConfig)
+	}
+
 	var zero T
 
 	globalConfig.Service = zero
@@ -303,7 +434,9 @@ func DefaultConfig[T any](configPath string) error {
 // WatchConfig starts watching the configuration file for changes.
 // When the file is modified, it is automatically re-read and the global
 // configuration is updated. The optional onChange callback is invoked
-// after each successful reload.
+// after each successful reload, and functions registered with Subscribe
+// receive the changed keys. Writes made by Set or Update with WithPersist
+// do not trigger a reload.
 //
 // WatchConfig must be called after InitServiceConfig. It launches a
 // background goroutine and returns immediately.
@@ -320,14 +453,43 @@ func WatchConfig(onChange ...func()) {
 	mu.RUnlock()
 
 	v.OnConfigChange(func(_ fsnotify.Event) {
+		var (
+			event       ChangeEvent
+			subscribers []func(ChangeEvent)
+		)
+		// Registered first so that it runs after the lock is released.
+		defer func() { notify(subscribers, event) }()
+
 		mu.Lock()
 		defer mu.Unlock()
 
-		if err := v.Unmarshal(globalConfig); err != nil {
+		if globalConfig.isOwnWrite() {
+			slog.Debug("Ignoring config change written by Set or Update", "file", globalConfig.ConfigFile)
+			return
+		}
+
+		before := flattenConfig(globalConfig)
+		globalConfig.migratedFiles = nil
+
+		if err := globalConfig.unmarshal(); err != nil {
 			slog.Error("failed to unmarshal config after reload", "error", err)
 			return
 		}
 
+		// The edited file may be at an older version: migrate it like on
+		// start, so that it is not merged unmigrated with a migrated
+		// profile, but leave writing it back to the next start.
+		globalConfig.Version = globalConfig.viper.GetInt("version")
+		if _, err := globalConfig.migrateBase(afs, false); err != nil {
+			slog.Error("failed to migrate config after reload", "error", err)
+			return
+		}
+
+		if err := globalConfig.resolveSecrets(); err != nil {
+			slog.Error("failed to resolve secrets after reload", "error", err)
+			return
+		}
+
 		if err := decryptConfigFields(globalConfig); err != nil {
 			slog.Error("failed to decrypt config after reload", "error", err)
 			return
@@ -343,36 +505,34 @@ func WatchConfig(onChange ...func()) {
 			return
 		}
 
+		if err := globalConfig.writeMigratedFiles(afs); err != nil {
+			slog.Error("failed to persist migrations after reload", "error", err)
+		}
+
 		slog.Info("Configuration reloaded")
 		logConfigLocked()
 
 		for _, fn := range onChange {
 			fn()
 		}
+
+		event = ChangeEvent{Source: ChangeSourceFile}
+		for _, change := range diffData(before, flattenConfig(globalConfig)) {
+			event.Keys = append(event.Keys, change.Key)
+		}
+		subscribers = globalConfig.subscriberFuncs()
 	})
 
 	v.WatchConfig()
 }
 
 func secureCopyLocked() Config {
-	configClone := *globalConfig
-
-	if configClone.AppSecret != "" {
-		configClone.AppSecret = maskedValue
-	}
-
-	configClone.Service = maskSensitiveFields(configClone.Service)
-
-	return configClone
+	return maskConfig(*globalConfig)
 }
 
 func logConfigLocked() {
-	secureCfg := secureCopyLocked()
-	slog.Debug("Current configuration",
-		"appID", secureCfg.AppID,
-		"appSecret", secureCfg.AppSecret,
-		"logLevel", secureCfg.Logger.LogLevel,
-	)
+	// Config implements slog.LogValuer, so it is masked by the handler.
+	slog.Debug("Current configuration", "config", *globalConfig)
 }
 
 func (c *Config) defaultValues() error {
@@ -426,39 +586,115 @@ func (c *Config) runValidators() error {
 // loadProfile checks for a profile-specific config file and merges its values
 // on top of the base config. For example, if Environment is "prod" and the base
 // config file is "config.yaml", it looks for "config.prod.yaml" in the same directory.
+// The merged settings are validated against the schema given with WithSchema
+// before they are decoded.
 func (c *Config) loadProfile(afs afero.Fs) error {
-	if c.Environment == "" {
-		return nil
+	merged, err := c.mergeProfile(afs)
+	if err != nil {
+		return err
 	}
 
-	dir := filepath.Dir(c.ConfigFile)
-	ext := filepath.Ext(c.ConfigFile)
-	base := strings.TrimSuffix(filepath.Base(c.ConfigFile), ext)
-
-	profileFile := filepath.Join(dir, base+"."+c.Environment+ext)
+	if err = c.validateSchema(); err != nil {
+		return err
+	}
 
-	if !exists(afs, profileFile) {
+	if !merged {
 		return nil
 	}
 
+	return c.applyProfile()
+}
+
+// mergeProfile merges the settings of the profile-specific config file,
+// if any, into the viper settings and reports whether it did.
+func (c *Config) mergeProfile(afs afero.Fs) (bool, error) {
+	profileFile := c.profileFile()
+	if profileFile == "" || !exists(afs, profileFile) {
+		return false, nil
+	}
+
+	ext := filepath.Ext(profileFile)
+
 	slog.Info("Loading profile config", "profile", c.Environment, "file", profileFile)
 
 	data, err := afero.ReadFile(afs, profileFile)
 	if err != nil {
-		return fmt.Errorf("reading profile config %s: %w", profileFile, err)
+		return false, fmt.Errorf("reading profile config %s: %w", profileFile, err)
 	}
 
 	profileExt := strings.TrimPrefix(ext, ".")
+
+	if data, profileExt, err = c.migrateProfile(profileFile, data, profileExt); err != nil {
+		return false, fmt.Errorf("migrating profile config %s: %w", profileFile, err)
+	}
+
 	c.viper.SetConfigType(profileExt)
 
 	if err = c.viper.MergeConfig(bytes.NewReader(data)); err != nil {
-		return fmt.Errorf("merging profile config %s: %w", profileFile, err)
+		return false, fmt.Errorf("merging profile config %s: %w", profileFile, err)
 	}
 
-	if err = c.viper.Unmarshal(globalConfig); err != nil {
+	return true, nil
+}
+
+// applyProfile decodes the settings merged by mergeProfile into c.
+func (c *Config) applyProfile() error {
+	if err := c.unmarshal(); err != nil {
 		return fmt.Errorf("unmarshalling profile config: %w", err)
 	}
 
+	// Unmarshalling restores the raw base values too, so references and
+	// encrypted values from both files must be processed again.
+	if err := c.resolveSecrets(); err != nil {
+		return fmt.Errorf("resolving profile secrets: %w", err)
+	}
+
+	if err := decryptConfigFields(c); err != nil {
+		return fmt.Errorf("decrypting profile config: %w", err)
+	}
+
+	return nil
+}
+
+// profileFile returns the path of the profile-specific config file for the
+// current environment, or "" if no environment is set.
+func (c *Config) profileFile() string {
+	if c.Environment == "" {
+		return ""
+	}
+
+	ext := filepath.Ext(c.ConfigFile)
+	base := strings.TrimSuffix(c.ConfigFile, ext)
+
+	return base + "." + c.Environment + ext
+}
+
+// unmarshal decodes the settings read by viper, including indexed
+// environment variables, into c, populating Secret fields, and wipes the
+// secrets replaced by the decoded values.
+func (c *Config) unmarshal() error {
+	old := collectSecrets(c)
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       decodeHook(),
+		WeaklyTypedInput: true,
+		Result:           c,
+	})
+	if err != nil {
+		return err
+	}
+
+	settings, err := c.settings()
+	if err != nil {
+		return err
+	}
+
+	if err = decoder.Decode(settings); err != nil {
+		return err
+	}
+
+	wipeReplacedSecrets(old, c)
+
 	return nil
 }
 
@@ -494,116 +730,320 @@ func (c *Config) readInConfig(afs afero.Fs) error {
 		c.viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	}
 	c.viper.AutomaticEnv()
+	if err = c.bindEnv(); err != nil {
+		return fmt.Errorf("binding environment variables: %w", err)
+	}
+	if err = c.bindFlags(); err != nil {
+		return fmt.Errorf("binding flags: %w", err)
+	}
 
 	if err = c.viper.ReadConfig(bytes.NewReader(file)); err != nil {
 		return fmt.Errorf("reading config content: %w", err)
 	}
 
-	if err = c.viper.Unmarshal(globalConfig); err != nil {
+	if err = c.unmarshal(); err != nil {
+		// Values of the wrong type are better reported by the schema.
+		if serr := c.validateSchema(); serr != nil {
+			return serr
+		}
 		return fmt.Errorf("unmarshalling config: %w", err)
 	}
 
 	return nil
 }
 
-// writeToFile writes the global config to the given file path atomically.
-// It writes to a temporary file first, then renames to the target path
-// to prevent data loss if encoding fails. The encoding format is determined
-// by the file extension (JSON for .json, YAML otherwise).
-func writeToFile(cfgFile string) error {
-	dir := filepath.Dir(cfgFile)
-
-	tmp, err := os.CreateTemp(dir, ".config-*")
+// patchContent returns original, the content of cfgFile, patched to hold
+// data. Only the keys that changed are rewritten: comments, key order and
+// formatting of original are kept for everything else.
+func patchContent(cfgFile string, original []byte, data map[string]any) ([]byte, error) {
+	doc, err := document.Parse(original, strings.TrimPrefix(filepath.Ext(cfgFile), "."))
 	if err != nil {
-		return fmt.Errorf("creating temp file: %w", err)
+		return nil, err
 	}
-	tmpName := tmp.Name()
 
-	ext := strings.TrimPrefix(filepath.Ext(cfgFile), ".")
-	if ext == "json" {
-		encoder := json.NewEncoder(tmp)
-		encoder.SetIndent("", "  ")
-		err = encoder.Encode(globalConfig)
-	} else {
-		encoder := yaml.NewEncoder(tmp)
-		encoder.SetIndent(2)
-		err = encoder.Encode(globalConfig)
+	if err = doc.Patch(data); err != nil {
+		return nil, fmt.Errorf("updating config: %w", err)
 	}
 
-	if err != nil {
-		_ = tmp.Close()
-		_ = os.Remove(tmpName)
-		return fmt.Errorf("encoding config: %w", err)
-	}
+	return doc.Encode()
+}
+
+func exists(fs afero.Fs, path string) bool {
+	stat, err := fs.Stat(path)
+	return err == nil && !stat.IsDir()
+}
+
+// rewriteFunc returns the replacement for the string val found at the
+// dotted key path.
+type rewriteFunc func(path, val string) (string, error)
+
+// rewriteStrings recursively replaces every settable string reachable from
+// rv with the result of fn. Nested structs, pointers, slices, arrays, maps
+// and any-typed sub-trees are traversed. Values held in interfaces and maps
+// are not addressable, so they are copied, rewritten and stored back. seen
+// guards against pointer cycles; it is keyed by type too, as a pointer to a
+// struct and a pointer to its first field share an address. Errors are
+// prefixed with the key path.
+func rewriteStrings(rv reflect.Value, path string, seen map[pointerKey]bool, fn rewriteFunc) error {
+	if rv.Type() == secretType {
+		secret := rv.Interface().(Secret)
+		if !rv.CanSet() || secret.IsZero() {
+			return nil
+		}
 
-	if err = tmp.Close(); err != nil {
-		_ = os.Remove(tmpName)
-		return fmt.Errorf("closing temp file: %w", err)
+		val, err := fn(path, secret.Reveal())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if val != secret.Reveal() {
+			rv.Set(reflect.ValueOf(NewSecret(val)))
+		}
+
+		return nil
 	}
 
-	if err = os.Rename(tmpName, cfgFile); err != nil {
-		_ = os.Remove(tmpName)
-		return fmt.Errorf("renaming temp file: %w", err)
+	switch rv.Kind() {
+	case reflect.String:
+		if !rv.CanSet() || rv.Len() == 0 {
+			return nil
+		}
+
+		val, err := fn(path, rv.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		rv.SetString(val)
+
+	case reflect.Ptr:
+		key := pointerKey{rv.Pointer(), rv.Type()}
+		if rv.IsNil() || seen[key] {
+			return nil
+		}
+		seen[key] = true
+
+		return rewriteStrings(rv.Elem(), path, seen, fn)
+
+	case reflect.Interface:
+		if rv.IsNil() || !rv.CanSet() {
+			return nil
+		}
+
+		cp := reflect.New(rv.Elem().Type()).Elem()
+		cp.Set(rv.Elem())
+		if err := rewriteStrings(cp, path, seen, fn); err != nil {
+			return err
+		}
+		rv.Set(cp)
+
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := range rt.NumField() {
+			field := rt.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name := document.FieldKey(field)
+			if name == "-" {
+				continue
+			}
+
+			if err := rewriteStrings(rv.Field(i), joinKeyPath(path, name), seen, fn); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := range rv.Len() {
+			if err := rewriteStrings(rv.Index(i), indexKeyPath(path, i), seen, fn); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+
+		iter := rv.MapRange()
+		for iter.Next() {
+			cp := reflect.New(iter.Value().Type()).Elem()
+			cp.Set(iter.Value())
+
+			if err := rewriteStrings(cp, joinKeyPath(path, fmt.Sprint(iter.Key().Interface())), seen, fn); err != nil {
+				return err
+			}
+			rv.SetMapIndex(iter.Key(), cp)
+		}
 	}
 
 	return nil
 }
 
-func exists(fs afero.Fs, path string) bool {
-	stat, err := fs.Stat(path)
-	return err == nil && !stat.IsDir()
+// joinKeyPath appends name to the dotted key path prefix.
+func joinKeyPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
+
+// indexKeyPath appends a slice or array index to the key path prefix.
+func indexKeyPath(prefix string, i int) string {
+	return fmt.Sprintf("%s[%d]", prefix, i)
 }
 
 func defaultConfig(configPath string) error {
-	if err := globalConfig.defaultValues(); err != nil {
+	// The values are generated on a copy, kept only if the file is ours:
+	// if another process creates it first, its AppID and AppSecret are
+	// read instead.
+	c := *globalConfig
+
+	if err := c.defaultValues(); err != nil {
+		return err
+	}
+
+	// A new file is written in the current layout, so that no migration
+	// runs on it.
+	if c.Version == 0 {
+		c.Version = c.targetVersion
+	}
+
+	content, err := c.encodeDefaultConfig(configPath)
+	if err != nil {
 		return err
 	}
-	return writeToFile(configPath)
+
+	if err = createFileExclusive(configPath, content); err != nil {
+		return err
+	}
+
+	*globalConfig = c
+
+	return nil
 }
 
-// maskSensitiveFields returns a copy of v with all fields tagged
-// `sensitive:"true"` replaced with "********". If v is not a struct
-// pointer, it is returned unchanged.
-func maskSensitiveFields(v any) any {
-	if v == nil {
-		return v
+// encodeDefaultConfig encodes c for a new config file, in JSON or YAML
+// according to the extension of cfgFile and in struct field order.
+// Secret values are written revealed, and sensitive values are encrypted
+// if WithEncryptedDefaults was given.
+func (c *Config) encodeDefaultConfig(cfgFile string) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		err error
+	)
+
+	format := strings.TrimPrefix(filepath.Ext(cfgFile), ".")
+	if format == "json" {
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(c)
+	} else {
+		format = "yaml"
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		err = encoder.Encode(c)
 	}
 
-	rv := reflect.ValueOf(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding config: %w", err)
+	}
 
-	// Dereference pointer
-	if rv.Kind() == reflect.Ptr {
-		if rv.IsNil() {
-			return v
+	// The encoders mask Secrets and know nothing of durations or key
+	// paths: patch in the values as InitServiceConfig will read them.
+	doc, err := document.Parse(buf.Bytes(), format)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := plainData(reflect.ValueOf(c).Elem(), make(map[uintptr]bool)).(map[string]any)
+
+	if c.encryptDefaults {
+		if err = c.encryptSensitiveData(data); err != nil {
+			return nil, fmt.Errorf("encrypting generated secrets: %w", err)
 		}
-		rv = rv.Elem()
 	}
 
-	if rv.Kind() != reflect.Struct {
-		return v
+	if err = doc.Patch(data); err != nil {
+		return nil, fmt.Errorf("encoding config: %w", err)
 	}
 
-	// Create a new instance to avoid mutating the original
-	cp := reflect.New(rv.Type()).Elem()
-	cp.Set(rv)
+	return doc.Encode()
+}
 
-	rt := rv.Type()
-	for i := range rt.NumField() {
-		field := rt.Field(i)
-		if field.Tag.Get("sensitive") == "true" && field.Type.Kind() == reflect.String {
-			cpField := cp.Field(i)
-			if cpField.CanSet() && cpField.String() != "" {
-				cpField.SetString(maskedValue)
-			}
+// encryptSensitiveData replaces the sensitive string values in data, the
+// plain form of c, with values encrypted and bound to their key path.
+// Sensitive values are found by comparing with the masked config.
+func (c *Config) encryptSensitiveData(data map[string]any) error {
+	masked := maskConfig(*c)
+
+	plain, hidden := make(map[string]any), make(map[string]any)
+	flattenData("", data, plain)
+	flattenData("", plainData(reflect.ValueOf(&masked).Elem(), make(map[uintptr]bool)), hidden)
+
+	e := c.encrypter()
+
+	for key, value := range plain {
+		s, ok := value.(string)
+		if !ok || s == "" || s == hidden[key] || IsEncryptedValue(s) || c.isSecretReference(s) {
+			continue
 		}
+
+		content, err := e.seal(s, keyPathAAD(key))
+		if err != nil {
+			return err
+		}
+
+		setData(data, key, encPrefix+keyBindingPrefix+content+encSuffix)
 	}
 
-	// Return as pointer if original was pointer
-	if reflect.ValueOf(v).Kind() == reflect.Ptr {
-		ptr := reflect.New(rv.Type())
-		ptr.Elem().Set(cp)
-		return ptr.Interface()
+	return nil
+}
+
+// createFileExclusive creates cfgFile with content and mode 0600. It never
+// replaces an existing file: if cfgFile exists, the returned error matches
+// fs.ErrExist, so that processes racing to create it do not overwrite
+// each other. The content is written to a temporary file first and then
+// linked into place, so the file never appears half-written.
+func createFileExclusive(cfgFile string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(cfgFile), ".config-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
 	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
 
-	return cp.Interface()
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+
+	err = os.Link(tmpName, cfgFile)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("creating config file: %w", err)
+	}
+
+	// Hard links are not supported everywhere: fall back to O_EXCL.
+	f, err := os.OpenFile(cfgFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("creating config file: %w", err)
+	}
+
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(cfgFile)
+		return fmt.Errorf("writing config file: %w", err)
+	}
+
+	return nil
 }
@@ -2,14 +2,17 @@ package config
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/inovacc/config/internal/viper"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -132,6 +135,160 @@ func TestDefaultConfig(t *testing.T) {
 	assert.Equal(t, "DEBUG", cfg.Logger.LogLevel)
 }
 
+// TestInitServiceConfigWithoutAutoCreate tests that a missing file is an
+// error instead of being created
+func TestInitServiceConfigWithoutAutoCreate(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	err := InitServiceConfig(&anotherService{}, configPath, WithoutAutoCreate())
+	require.Error(t, err)
+
+	var notFound ConfigFileNotFoundError
+	require.ErrorAs(t, err, &notFound)
+	assert.Equal(t, configPath, notFound.Path)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = os.Stat(configPath)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "no file must be created")
+}
+
+// TestDefaultConfigNeverOverwrites tests the mode of generated files and
+// that existing files are kept
+func TestDefaultConfigNeverOverwrites(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	require.NoError(t, DefaultConfig[*anotherService](configPath))
+
+	info, err := os.Stat(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	original, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+
+	err = DefaultConfig[*anotherService](configPath)
+	require.ErrorIs(t, err, fs.ErrExist)
+
+	current, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(original), string(current))
+}
+
+// TestCreateFileExclusiveRace tests that only one of several processes
+// racing to create the default file succeeds, and that it is written whole
+func TestCreateFileExclusiveRace(t *testing.T) {
+	tempDir := setupTestDir(t)
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	const writers = 10
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = createFileExclusive(configPath, []byte(strings.Repeat(strconv.Itoa(i), 4096)))
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		assert.ErrorIs(t, err, fs.ErrExist)
+	}
+	assert.Equal(t, 1, created)
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Len(t, content, 4096)
+	assert.Equal(t, strings.Repeat(string(content[0]), 4096), string(content))
+
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temp files must be removed")
+}
+
+// TestDefaultConfigEncryptedDefaults tests that generated secrets are
+// encrypted in the default file
+func TestDefaultConfigEncryptedDefaults(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("generated-secrets-key"))
+
+	configPath := filepath.Join(tempDir, "config.yaml")
+	svc := &secretService{Username: "alice", Password: NewSecret("default-pass")}
+
+	err := InitServiceConfig(svc, configPath, WithEncryptedDefaults())
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+
+	data, err := parseConfigData(content)
+	require.NoError(t, err)
+
+	appSecret := data["appSecret"].(string)
+	assert.True(t, IsKeyBoundValue(appSecret), "generated AppSecret must be encrypted")
+	service := data["service"].(map[string]any)
+	assert.True(t, IsKeyBoundValue(service["password"].(string)))
+	assert.Equal(t, "alice", service["username"])
+	assert.NotContains(t, string(content), "default-pass")
+
+	base := GetBaseConfig()
+	plain, err := DecryptValueForKey("appSecret", appSecret)
+	require.NoError(t, err)
+	assert.Equal(t, base.AppSecret, plain)
+	assert.Equal(t, "default-pass", svc.Password.Reveal())
+}
+
+// TestDefaultConfigEncryptedDefaultsWithoutKey tests that the file is not
+// written in plain text when no key is set
+func TestDefaultConfigEncryptedDefaultsWithoutKey(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	err := InitServiceConfig(&anotherService{}, configPath, WithEncryptedDefaults())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "encrypting generated secrets")
+
+	_, err = os.Stat(configPath)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// TestDefaultConfigWritesSecrets tests that Secret defaults are written,
+// not masked
+func TestDefaultConfigWritesSecrets(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := filepath.Join(tempDir, "config.yaml")
+	svc := &secretService{Password: NewSecret("default-pass")}
+
+	require.NoError(t, InitServiceConfig(svc, configPath))
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+
+	data, err := parseConfigData(content)
+	require.NoError(t, err)
+	assert.Equal(t, "default-pass", data["service"].(map[string]any)["password"])
+	assert.Equal(t, "default-pass", svc.Password.Reveal())
+}
+
 // TestGetServiceConfigTypeMismatch tests GetServiceConfig with a type mismatch
 func TestGetServiceConfigTypeMismatch(t *testing.T) {
 	resetGlobalConfig(t)
@@ -728,7 +885,7 @@ func TestConcurrentGetBaseConfig(t *testing.T) {
 	wg.Wait()
 }
 
-// TestAtomicWriteToFile tests that writeToFile creates the file atomically
+// TestAtomicWriteToFile tests that defaultConfig creates the file atomically
 func TestAtomicWriteToFile(t *testing.T) {
 	resetGlobalConfig(t)
 	tempDir := setupTestDir(t)
@@ -754,6 +911,37 @@ func TestAtomicWriteToFile(t *testing.T) {
 	}
 }
 
+// TestDefaultConfigLosingRaceKeepsWinner tests that when another process
+// created the default file first, its credentials are read rather than
+// the ones generated locally
+func TestDefaultConfigLosingRaceKeepsWinner(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `
+appID: winnerappid12345
+appSecret: winnerappsecret12345
+logger:
+  logLevel: INFO
+`)
+
+	mu.Lock()
+	globalConfig.ConfigFile = configPath
+	globalConfig.Service = &customService{}
+	err := defaultConfig(configPath)
+	require.ErrorIs(t, err, fs.ErrExist)
+	assert.Empty(t, globalConfig.AppID)
+	assert.Empty(t, globalConfig.AppSecret)
+
+	err = globalConfig.readInConfig(afero.NewOsFs())
+	mu.Unlock()
+	require.NoError(t, err)
+
+	cfg := GetBaseConfig()
+	assert.Equal(t, "winnerappid12345", cfg.AppID)
+	assert.Equal(t, "winnerappsecret12345", cfg.AppSecret)
+}
+
 // --- JSON config file tests ---
 
 const testJSONFile = "./testdata/config.json"
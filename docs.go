@@ -0,0 +1,57 @@
+package config
+
+import (
+	"github.com/inovacc/config/internal/docs"
+)
+
+// DocsFormat is an output format of Docs.
+type DocsFormat string
+
+const (
+	// DocsMarkdown renders a Markdown table.
+	DocsMarkdown DocsFormat = docs.Markdown
+	// DocsText renders a plain text table with aligned columns.
+	DocsText DocsFormat = docs.Text
+)
+
+// Docs returns reference documentation for every configuration key of the
+// service configuration type T, such as *MyServiceConfig, including the
+// base Config keys. Each key is listed with its type, default, allowed
+// values, whether it is sensitive, the environment variable overriding it
+// and its description, taken from the tags described in JSONSchema.
+//
+// Environment variable names follow the prefix set with SetEnvPrefix, so
+// call it first; they are left out when no prefix is set. With
+// EnableSecretHeuristics, keys matching its patterns are listed as
+// sensitive too.
+//
+// The same documentation can be generated from a schema file with the
+// command-line tool: config docs -schema config.schema.json.
+//
+// Example:
+//
+//	config.SetEnvPrefix("APP")
+//	doc, err := config.Docs[*MyServiceConfig](config.DocsMarkdown)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	_ = os.WriteFile("CONFIG.md", doc, 0644)
+func Docs[T any](format DocsFormat) ([]byte, error) {
+	schema, err := JSONSchema[T]()
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	envPrefix := globalConfig.envPrefix
+	patterns := globalConfig.secretPatterns
+	mu.RUnlock()
+
+	return docs.Render(schema, docs.Options{
+		Format:    string(format),
+		EnvPrefix: envPrefix,
+		Sensitive: func(_, name string) bool {
+			return matchesSecretPattern(patterns, name)
+		},
+	})
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type docsService struct {
+	Port     int      `yaml:"port" default:"8080" validate:"min=1,max=65535" description:"Port to listen on"`
+	Mode     string   `yaml:"mode" validate:"oneof=dev prod" description:"Run mode | dev or prod"`
+	DB       docsDB   `yaml:"db"`
+	Password Secret   `yaml:"password"`
+	Hosts    []string `yaml:"hosts" default:"a.local,b.local"`
+}
+
+type docsDB struct {
+	Host  string `yaml:"host" validate:"hostname"`
+	Token string `yaml:"token"`
+}
+
+func TestDocsMarkdown(t *testing.T) {
+	resetGlobalConfig(t)
+	SetEnvPrefix("APP")
+	require.NoError(t, EnableSecretHeuristics())
+
+	doc, err := Docs[*docsService](DocsMarkdown)
+	require.NoError(t, err)
+
+	assert.Equal(t, "| Key | Type | Default | Allowed values | Sensitive | Environment variable | Description |\n"+
+		"| --- | ---- | ------- | -------------- | --------- | -------------------- | ----------- |\n"+
+		"| `version` | integer |  | >= 0 |  | `APP_VERSION` | Version of the config file layout, used by migrations |\n"+
+		"| `environment` | string | dev |  |  | `APP_ENVIRONMENT` | Environment name, selecting the profile overlay file |\n"+
+		"| `appID` | string |  | length >= 8 |  | `APP_APPID` | Unique application identifier, generated if empty |\n"+
+		"| `appSecret` | string |  | length >= 12 | yes | `APP_APPSECRET` | Application secret, generated if empty |\n"+
+		"| `logger.logLevel` | string | DEBUG |  |  | `APP_LOGGER_LOGLEVEL` | Minimum level of log messages: DEBUG, INFO, WARN, WARNING or ERROR, in any case |\n"+
+		"| `service.port` | integer | 8080 | 1..65535 |  | `APP_SERVICE_PORT` | Port to listen on |\n"+
+		"| `service.mode` | string |  | dev, prod |  | `APP_SERVICE_MODE` | Run mode \\| dev or prod |\n"+
+		"| `service.db.host` | string |  | format hostname |  | `APP_SERVICE_DB_HOST` |  |\n"+
+		"| `service.db.token` | string |  |  | yes | `APP_SERVICE_DB_TOKEN` |  |\n"+
+		"| `service.password` | string |  |  | yes | `APP_SERVICE_PASSWORD` |  |\n"+
+		"| `service.hosts` | list of string | a.local, b.local |  |  | `APP_SERVICE_HOSTS` |  |\n",
+		string(doc))
+}
+
+func TestDocsText(t *testing.T) {
+	resetGlobalConfig(t)
+
+	doc, err := Docs[*docsDB](DocsText)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"KEY              TYPE     DEFAULT  ALLOWED VALUES   SENSITIVE  ENVIRONMENT VARIABLE  DESCRIPTION",
+		"version          integer           >= 0                                              Version of the config file layout, used by migrations",
+		"environment      string   dev                                                        Environment name, selecting the profile overlay file",
+		"appID            string            length >= 8                                       Unique application identifier, generated if empty",
+		"appSecret        string            length >= 12     yes                              Application secret, generated if empty",
+		"logger.logLevel  string   DEBUG                                                      Minimum level of log messages: DEBUG, INFO, WARN, WARNING or ERROR, in any case",
+		"service.host     string            format hostname",
+		"service.token    string",
+		"",
+	}, strings.Split(string(doc), "\n"), "no environment variables without a prefix")
+}
+
+func TestDocsUnsupportedFormat(t *testing.T) {
+	_, err := Docs[*docsService]("html")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported docs format "html"`)
+}
@@ -3,20 +3,27 @@ package config
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 )
 
 const encPrefix = "ENC["
 const encSuffix = "]"
 
+// keyBindingPrefix marks envelopes whose ciphertext authenticates the key
+// path they are stored under.
+const keyBindingPrefix = "kp" + kdfSeparator
+
 // SetEncryptionKey sets the key used for encrypting and decrypting
-// configuration values. The key can be any length; it is hashed with
-// SHA-256 to produce a 32-byte AES-256 key.
+// configuration values. The key can be any length; it is turned into a
+// 32-byte AES-256 key by the KDF selected with SetKeyDerivation, which
+// defaults to a single SHA-256 hash.
 //
 // Must be called before InitServiceConfig if the config file contains
 // encrypted values.
@@ -28,13 +35,14 @@ func SetEncryptionKey(key []byte) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	h := sha256.Sum256(key)
-	globalConfig.encryptionKey = h[:]
+	globalConfig.encryptionKey = slices.Clone(key)
+	globalConfig.derivedKeys = newKDFCache()
 }
 
 // EncryptValue encrypts a plaintext string and returns it in the
 // format ENC[base64data]. The encryption key must be set first via
-// SetEncryptionKey.
+// SetEncryptionKey, or a public key via SetRecipientKey, in which case
+// the value can only be decrypted with the matching private key.
 //
 // Use this to prepare values before storing them in a config file.
 //
@@ -43,35 +51,133 @@ func SetEncryptionKey(key []byte) {
 //	encrypted, err := config.EncryptValue("my-secret-password")
 //	// encrypted = "ENC[base64...]"
 func EncryptValue(plaintext string) (string, error) {
+	content, err := encryptValue(plaintext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return encPrefix + content + encSuffix, nil
+}
+
+// EncryptValueForKey encrypts plaintext like EncryptValue, but binds the
+// result to the dotted key path it will be stored under. The path is
+// authenticated as AES-GCM associated data, so the value fails to decrypt
+// if it is copied or moved to another key. Paths are compared
+// case-insensitively, like configuration keys.
+//
+// Example:
+//
+//	encrypted, err := config.EncryptValueForKey("service.adminToken", "s3cr3t")
+//	// encrypted = "ENC[kp$...]"
+func EncryptValueForKey(keyPath, plaintext string) (string, error) {
+	if keyPath == "" {
+		return "", fmt.Errorf("key path must not be empty")
+	}
+
+	content, err := encryptValue(plaintext, keyPathAAD(keyPath))
+	if err != nil {
+		return "", err
+	}
+
+	return encPrefix + keyBindingPrefix + content + encSuffix, nil
+}
+
+// encryptValue encrypts plaintext with the recipient key if set, or the
+// symmetric key otherwise, and returns the envelope content.
+func encryptValue(plaintext string, aad []byte) (string, error) {
 	mu.RLock()
-	key := globalConfig.encryptionKey
+	e := globalConfig.encrypter()
 	mu.RUnlock()
 
-	if len(key) == 0 {
+	return e.seal(plaintext, aad)
+}
+
+// encrypter holds the keys used to encrypt values.
+type encrypter struct {
+	key       []byte
+	keys      *kdfCache
+	kd        KeyDerivation
+	salt      []byte
+	recipient *ecdh.PublicKey
+}
+
+// encrypter returns an encrypter with c's keys. The caller must hold mu.
+func (c *Config) encrypter() encrypter {
+	return encrypter{key: c.encryptionKey, keys: c.derivedKeys, kd: c.keyDerivation, salt: c.kdfSalt, recipient: c.recipientKey}
+}
+
+// seal encrypts plaintext with the recipient key if set, or the symmetric
+// key otherwise, and returns the envelope content.
+func (e encrypter) seal(plaintext string, aad []byte) (string, error) {
+	if e.recipient != nil {
+		return encryptX25519(e.recipient, []byte(plaintext), aad)
+	}
+
+	if len(e.key) == 0 {
 		return "", fmt.Errorf("encryption key not set: call SetEncryptionKey first")
 	}
 
-	ciphertext, err := encryptAESGCM(key, []byte(plaintext))
+	kd := e.kd
+	if kd.Algorithm == "" {
+		kd.Algorithm = KDFSHA256
+	}
+
+	aesKey, err := e.keys.derive(kd, e.key, e.salt)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := encryptAESGCM(aesKey, []byte(plaintext), aad)
 	if err != nil {
 		return "", err
 	}
 
-	return encPrefix + base64.StdEncoding.EncodeToString(ciphertext) + encSuffix, nil
+	return kd.header(e.salt) + base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
 // DecryptValue decrypts a value in the format ENC[base64data] and
 // returns the plaintext string. If the value is not encrypted (no
-// ENC[...] wrapper), it is returned unchanged.
+// ENC[...] wrapper), it is returned unchanged. Asymmetrically encrypted
+// values require the private key set with SetPrivateKey, and values bound
+// to a key path must be decrypted with DecryptValueForKey.
 //
 // Example:
 //
 //	plain, err := config.DecryptValue("ENC[base64...]")
 func DecryptValue(value string) (string, error) {
+	return DecryptValueForKey("", value)
+}
+
+// DecryptValueForKey decrypts a value stored under the dotted key path.
+// Values produced by EncryptValueForKey only decrypt under the path they
+// were encrypted for; unbound values decrypt under any path unless
+// RequireKeyBinding is enabled.
+//
+// Example:
+//
+//	plain, err := config.DecryptValueForKey("service.adminToken", "ENC[kp$...]")
+func DecryptValueForKey(keyPath, value string) (string, error) {
 	mu.RLock()
-	key := globalConfig.encryptionKey
+	d := globalConfig.decrypter()
 	mu.RUnlock()
 
-	return decryptIfEncrypted(key, value)
+	return d.decrypt(keyPath, value)
+}
+
+// RequireKeyBinding makes InitServiceConfig, reloads and
+// DecryptValueForKey reject ENC[...] values that are not bound to their
+// key path with EncryptValueForKey. Enable it once all values of a file
+// are bound, so that an attacker cannot swap in an unbound value taken
+// from elsewhere.
+//
+// Example:
+//
+//	config.RequireKeyBinding(true)
+func RequireKeyBinding(require bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	globalConfig.requireKeyBinding = require
 }
 
 // IsEncryptedValue reports whether s is in the ENC[...] format.
@@ -79,98 +185,126 @@ func IsEncryptedValue(s string) bool {
 	return strings.HasPrefix(s, encPrefix) && strings.HasSuffix(s, encSuffix)
 }
 
+// IsKeyBoundValue reports whether s is an ENC[...] value bound to a key
+// path by EncryptValueForKey.
+func IsKeyBoundValue(s string) bool {
+	return IsEncryptedValue(s) && strings.HasPrefix(s[len(encPrefix):], keyBindingPrefix)
+}
+
+// keyPathAAD returns the associated data binding a value to keyPath.
+func keyPathAAD(keyPath string) []byte {
+	return []byte(strings.ToLower(keyPath))
+}
+
+// decrypter holds the keys and policy used to decrypt ENC[...] values.
+type decrypter struct {
+	key            []byte
+	keys           *kdfCache
+	priv           *ecdh.PrivateKey
+	requireBinding bool
+}
+
+// decrypter returns the decryption settings of c.
+func (c *Config) decrypter() decrypter {
+	return decrypter{key: c.encryptionKey, keys: c.derivedKeys, priv: c.privateKey, requireBinding: c.requireKeyBinding}
+}
+
 // decryptIfEncrypted decrypts a value if it is in ENC[...] format,
-// otherwise returns it unchanged.
+// otherwise returns it unchanged. The AES key is derived from key as
+// described by the envelope, so values produced with any KDF are readable.
 func decryptIfEncrypted(key []byte, value string) (string, error) {
+	return decrypter{key: key}.decrypt("", value)
+}
+
+// decrypt decrypts a symmetric ENC[...] value with d.key, or an
+// asymmetric one with d.priv, checking its binding to path. Other values
+// are returned unchanged. It has the signature of a rewriteFunc.
+func (d decrypter) decrypt(path, value string) (string, error) {
 	if !IsEncryptedValue(value) {
 		return value, nil
 	}
 
-	if len(key) == 0 {
-		return "", fmt.Errorf("encryption key not set but encrypted value found")
-	}
+	content := value[len(encPrefix) : len(value)-len(encSuffix)]
 
-	encoded := value[len(encPrefix) : len(value)-len(encSuffix)]
-	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
-	if err != nil {
-		return "", fmt.Errorf("decoding encrypted value: %w", err)
+	var aad []byte
+	if rest, bound := strings.CutPrefix(content, keyBindingPrefix); bound {
+		if path == "" {
+			return "", fmt.Errorf("value is bound to a key path: use DecryptValueForKey")
+		}
+		content, aad = rest, keyPathAAD(path)
+	} else if d.requireBinding {
+		return "", fmt.Errorf("encrypted value is not bound to its key path")
 	}
 
-	plaintext, err := decryptAESGCM(key, ciphertext)
-	if err != nil {
-		return "", fmt.Errorf("decrypting value: %w", err)
+	plaintext, err := d.open(content, aad)
+	if err != nil && aad != nil && errors.Is(err, errOpen) {
+		return "", fmt.Errorf("%w (wrong key, or value copied from another key path)", err)
 	}
 
-	return string(plaintext), nil
+	return plaintext, err
 }
 
-// decryptConfigFields walks the config struct and its Service field,
-// decrypting any string fields that contain ENC[...] values.
-func decryptConfigFields(c *Config) error {
-	// Decrypt base config string fields
-	fields := []struct {
-		ptr  *string
-		name string
-	}{
-		{&c.AppID, "appID"},
-		{&c.AppSecret, "appSecret"},
-		{&c.Environment, "environment"},
-		{&c.Logger.LogLevel, "logger.logLevel"},
-	}
-
-	for _, f := range fields {
-		decrypted, err := decryptIfEncrypted(c.encryptionKey, *f.ptr)
+// errOpen is returned when AES-GCM authentication fails.
+var errOpen = errors.New("decrypting value: message authentication failed")
+
+// open decrypts envelope content, authenticating aad.
+func (d decrypter) open(content string, aad []byte) (string, error) {
+	if isAsymmetricEnvelope(content) {
+		if d.priv == nil {
+			return "", fmt.Errorf("private key not set but asymmetrically encrypted value found")
+		}
+
+		plaintext, err := decryptX25519(d.priv, content, aad)
+		if errors.Is(err, errOpen) {
+			return "", err
+		}
 		if err != nil {
-			return fmt.Errorf("decrypting %s: %w", f.name, err)
+			return "", fmt.Errorf("decrypting value: %w", err)
 		}
-		*f.ptr = decrypted
-	}
 
-	// Decrypt service config fields using reflection
-	if err := decryptStructFields(c.encryptionKey, c.Service); err != nil {
-		return fmt.Errorf("decrypting service config: %w", err)
+		return string(plaintext), nil
 	}
 
-	return nil
-}
+	if len(d.key) == 0 {
+		return "", fmt.Errorf("encryption key not set but encrypted value found")
+	}
 
-// decryptStructFields uses reflection to find and decrypt any string
-// fields in a struct that contain ENC[...] values.
-func decryptStructFields(key []byte, v any) error {
-	if v == nil {
-		return nil
+	kd, salt, encoded, err := parseEnvelope(content)
+	if err != nil {
+		return "", fmt.Errorf("parsing encrypted value: %w", err)
 	}
 
-	rv := reflect.ValueOf(v)
-	if rv.Kind() == reflect.Ptr {
-		if rv.IsNil() {
-			return nil
-		}
-		rv = rv.Elem()
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decoding encrypted value: %w", err)
 	}
 
-	if rv.Kind() != reflect.Struct {
-		return nil
+	aesKey, err := d.keys.derive(kd, d.key, salt)
+	if err != nil {
+		return "", fmt.Errorf("deriving key: %w", err)
 	}
 
-	for i := range rv.NumField() {
-		field := rv.Field(i)
-		if field.Kind() == reflect.String && field.CanSet() {
-			val := field.String()
-			if IsEncryptedValue(val) {
-				decrypted, err := decryptIfEncrypted(key, val)
-				if err != nil {
-					return fmt.Errorf("field %s: %w", rv.Type().Field(i).Name, err)
-				}
-				field.SetString(decrypted)
-			}
-		}
+	plaintext, err := decryptAESGCM(aesKey, ciphertext, aad)
+	if errors.Is(err, errOpen) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("decrypting value: %w", err)
 	}
 
-	return nil
+	return string(plaintext), nil
 }
 
-func encryptAESGCM(key, plaintext []byte) ([]byte, error) {
+// decryptConfigFields walks the config struct and its Service field,
+// decrypting any string values that contain ENC[...] values. Nested
+// structs, pointers, slices, arrays, maps and any-typed sub-trees are
+// traversed; errors name the full dotted key path of the failing value,
+// which is also the path key-bound values are checked against.
+func decryptConfigFields(c *Config) error {
+	return rewriteStrings(reflect.ValueOf(c).Elem(), "", make(map[pointerKey]bool), c.decrypter().decrypt)
+}
+
+func encryptAESGCM(key, plaintext, aad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("creating cipher: %w", err)
@@ -186,10 +320,10 @@ func encryptAESGCM(key, plaintext []byte) ([]byte, error) {
 		return nil, fmt.Errorf("generating nonce: %w", err)
 	}
 
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
 }
 
-func decryptAESGCM(key, ciphertext []byte) ([]byte, error) {
+func decryptAESGCM(key, ciphertext, aad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("creating cipher: %w", err)
@@ -206,5 +340,11 @@ func decryptAESGCM(key, ciphertext []byte) ([]byte, error) {
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return gcm.Open(nil, nonce, ciphertext, nil)
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, errOpen
+	}
+
+	return plaintext, nil
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -162,7 +163,17 @@ service:
 	}
 }
 
-func TestDecryptStructFields(t *testing.T) {
+// decryptService decrypts svc as the service config of globalConfig.
+func decryptService(svc any) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	globalConfig.Service = svc
+
+	return decryptConfigFields(globalConfig)
+}
+
+func TestDecryptServiceFields(t *testing.T) {
 	resetGlobalConfig(t)
 
 	SetEncryptionKey([]byte("struct-key"))
@@ -175,21 +186,17 @@ func TestDecryptStructFields(t *testing.T) {
 		Password: encPass,
 	}
 
-	mu.RLock()
-	key := globalConfig.encryptionKey
-	mu.RUnlock()
-
-	err = decryptStructFields(key, svc)
+	err = decryptService(svc)
 	require.NoError(t, err)
 	assert.Equal(t, "admin", svc.Username)
 	assert.Equal(t, "secret-pass", svc.Password)
 }
 
-func TestDecryptStructFieldsNil(t *testing.T) {
-	err := decryptStructFields([]byte("key"), nil)
+func TestDecryptServiceFieldsNil(t *testing.T) {
+	err := decryptService(nil)
 	assert.NoError(t, err)
 
-	err = decryptStructFields([]byte("key"), "not-a-struct")
+	err = decryptService("not-a-struct")
 	assert.NoError(t, err)
 }
 
@@ -324,3 +331,367 @@ service:
 	require.NoError(t, err)
 	assert.Equal(t, "prod-user", svc.Username)
 }
+
+type nestedDB struct {
+	Host     string `yaml:"host"`
+	Password string `yaml:"password"`
+}
+
+type nestedCredential struct {
+	User  string `yaml:"user"`
+	Token string `yaml:"token"`
+}
+
+type nestedService struct {
+	DB          nestedDB           `yaml:"db"`
+	Replica     *nestedDB          `yaml:"replica"`
+	Credentials []nestedCredential `yaml:"credentials"`
+	Keys        [2]string          `yaml:"keys"`
+	Headers     map[string]string  `yaml:"headers"`
+	Extra       any                `yaml:"extra"`
+}
+
+func TestDecryptNestedStructFields(t *testing.T) {
+	resetGlobalConfig(t)
+
+	SetEncryptionKey([]byte("nested-key"))
+
+	enc := func(s string) string {
+		v, err := EncryptValue(s)
+		require.NoError(t, err)
+		return v
+	}
+
+	svc := &nestedService{
+		DB:      nestedDB{Host: "db.local", Password: enc("db-pass")},
+		Replica: &nestedDB{Host: "replica.local", Password: enc("replica-pass")},
+		Credentials: []nestedCredential{
+			{User: "alice", Token: enc("alice-token")},
+			{User: "bob", Token: "plain-token"},
+		},
+		Keys:    [2]string{enc("key-0"), "key-1"},
+		Headers: map[string]string{"Authorization": enc("Bearer abc"), "Accept": "application/json"},
+		Extra: map[string]any{
+			"list": []any{enc("any-0"), 42},
+			"nested": map[string]any{
+				"secret": enc("any-secret"),
+			},
+		},
+	}
+
+	err := decryptService(svc)
+	require.NoError(t, err)
+
+	assert.Equal(t, "db-pass", svc.DB.Password)
+	assert.Equal(t, "replica-pass", svc.Replica.Password)
+	assert.Equal(t, "alice-token", svc.Credentials[0].Token)
+	assert.Equal(t, "plain-token", svc.Credentials[1].Token)
+	assert.Equal(t, [2]string{"key-0", "key-1"}, svc.Keys)
+	assert.Equal(t, "Bearer abc", svc.Headers["Authorization"])
+	assert.Equal(t, "application/json", svc.Headers["Accept"])
+
+	extra := svc.Extra.(map[string]any)
+	assert.Equal(t, []any{"any-0", 42}, extra["list"])
+	assert.Equal(t, "any-secret", extra["nested"].(map[string]any)["secret"])
+}
+
+func TestDecryptPointerToFirstField(t *testing.T) {
+	resetGlobalConfig(t)
+
+	SetEncryptionKey([]byte("alias-key"))
+
+	enc := func(s string) string {
+		v, err := EncryptValue(s)
+		require.NoError(t, err)
+		return v
+	}
+
+	type inner struct {
+		Password string
+	}
+	type outer struct {
+		Inner inner
+		Host  string
+	}
+	type holder struct {
+		Inner *inner
+		Outer *outer
+	}
+
+	// Inner is reached first and aliases the first field of Outer.
+	o := &outer{Inner: inner{Password: enc("pass")}, Host: enc("db.local")}
+
+	mu.Lock()
+	globalConfig.Service = &holder{Inner: &o.Inner, Outer: o}
+	err := decryptConfigFields(globalConfig)
+	mu.Unlock()
+	require.NoError(t, err)
+
+	assert.Equal(t, "pass", o.Inner.Password)
+	assert.Equal(t, "db.local", o.Host)
+}
+
+func TestDecryptNestedErrorPath(t *testing.T) {
+	resetGlobalConfig(t)
+
+	svc := &nestedService{
+		Credentials: []nestedCredential{
+			{User: "alice", Token: "plain"},
+			{User: "bob", Token: "ENC[dGVzdA==]"},
+		},
+	}
+
+	err := decryptService(svc)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "credentials[1].token")
+	}
+
+	svc = &nestedService{Extra: map[string]any{"inner": map[string]any{"secret": "ENC[dGVzdA==]"}}}
+
+	err = decryptService(svc)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "extra.inner.secret")
+	}
+}
+
+func TestEncryptedNestedConfigFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("nested-file-key"))
+
+	encDBPass, err := EncryptValue("nested-db-pass")
+	require.NoError(t, err)
+
+	encToken, err := EncryptValue("nested-token")
+	require.NoError(t, err)
+
+	encHeader, err := EncryptValue("nested-header")
+	require.NoError(t, err)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  db:
+    host: db.local
+    password: ` + encDBPass + `
+  credentials:
+    - user: alice
+      token: ` + encToken + `
+  headers:
+    x-api-key: ` + encHeader + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&nestedService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*nestedService]()
+	require.NoError(t, err)
+	assert.Equal(t, "nested-db-pass", svc.DB.Password)
+	assert.Equal(t, "nested-token", svc.Credentials[0].Token)
+	assert.Equal(t, "nested-header", svc.Headers["x-api-key"])
+}
+
+func TestEncryptedNestedConfigFileErrorPath(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  db:
+    password: ENC[dGVzdA==]
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err := InitServiceConfig(&nestedService{}, configPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "decrypting config: service.db.password")
+	}
+}
+
+type tokenService struct {
+	ReadOnlyToken string             `yaml:"readOnlyToken"`
+	AdminToken    string             `yaml:"adminToken"`
+	Credentials   []nestedCredential `yaml:"credentials"`
+}
+
+func TestEncryptValueForKey(t *testing.T) {
+	resetGlobalConfig(t)
+
+	SetEncryptionKey([]byte("binding-key"))
+
+	encrypted, err := EncryptValueForKey("service.adminToken", "admin-token")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "ENC[kp$"), encrypted)
+	assert.True(t, IsKeyBoundValue(encrypted))
+	assert.True(t, IsEncryptedValue(encrypted))
+
+	plain, err := DecryptValueForKey("service.adminToken", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "admin-token", plain)
+
+	// Keys are case-insensitive, like viper keys.
+	plain, err = DecryptValueForKey("SERVICE.ADMINTOKEN", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "admin-token", plain)
+
+	_, err = DecryptValueForKey("service.readOnlyToken", encrypted)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "copied from another key path")
+	}
+
+	_, err = DecryptValue(encrypted)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "use DecryptValueForKey")
+	}
+
+	_, err = EncryptValueForKey("", "value")
+	assert.Error(t, err)
+
+	// Unbound values decrypt under any key path.
+	unbound, err := EncryptValue("plain-token")
+	require.NoError(t, err)
+	assert.False(t, IsKeyBoundValue(unbound))
+
+	plain, err = DecryptValueForKey("service.readOnlyToken", unbound)
+	require.NoError(t, err)
+	assert.Equal(t, "plain-token", plain)
+}
+
+func TestEncryptValueForKeyWithKDFAndRecipient(t *testing.T) {
+	resetGlobalConfig(t)
+
+	SetEncryptionKey([]byte("binding-key"))
+	require.NoError(t, SetKeyDerivation(KeyDerivation{Algorithm: KDFHKDF}))
+
+	encrypted, err := EncryptValueForKey("appSecret", "hkdf-secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "ENC[kp$hkdf$"), encrypted)
+
+	plain, err := DecryptValueForKey("appSecret", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "hkdf-secret", plain)
+
+	pub, priv, err := GenerateKeyPair()
+	require.NoError(t, err)
+	require.NoError(t, SetRecipientKey(pub))
+	require.NoError(t, SetPrivateKey(priv))
+
+	encrypted, err = EncryptValueForKey("appSecret", "x25519-secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "ENC[kp$x25519$"), encrypted)
+
+	plain, err = DecryptValueForKey("appSecret", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "x25519-secret", plain)
+
+	_, err = DecryptValueForKey("appID", encrypted)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "copied from another key path")
+	}
+}
+
+func TestKeyBoundConfigFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("binding-file-key"))
+
+	enc := func(path, s string) string {
+		v, err := EncryptValueForKey(path, s)
+		require.NoError(t, err)
+		return v
+	}
+
+	configContent := `
+appID: validappid12345
+appSecret: ` + enc("appSecret", "bound-app-secret") + `
+logger:
+  logLevel: DEBUG
+service:
+  readOnlyToken: ` + enc("service.readOnlyToken", "read-only") + `
+  adminToken: ` + enc("service.adminToken", "admin") + `
+  credentials:
+    - user: alice
+      token: ` + enc("service.credentials[0].token", "alice-token") + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err := InitServiceConfig(&tokenService{}, configPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, "bound-app-secret", GetBaseConfig().AppSecret)
+
+	svc, err := GetServiceConfig[*tokenService]()
+	require.NoError(t, err)
+	assert.Equal(t, "read-only", svc.ReadOnlyToken)
+	assert.Equal(t, "admin", svc.AdminToken)
+	assert.Equal(t, "alice-token", svc.Credentials[0].Token)
+}
+
+func TestKeyBoundSwappedValuesFail(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("binding-file-key"))
+
+	readOnly, err := EncryptValueForKey("service.readOnlyToken", "read-only")
+	require.NoError(t, err)
+
+	// An attacker with write access copies the read-only token into adminToken.
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  readOnlyToken: ` + readOnly + `
+  adminToken: ` + readOnly + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&tokenService{}, configPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "decrypting config: service.adminToken")
+		assert.Contains(t, err.Error(), "copied from another key path")
+	}
+}
+
+func TestRequireKeyBinding(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("binding-file-key"))
+	RequireKeyBinding(true)
+
+	unbound, err := EncryptValue("admin")
+	require.NoError(t, err)
+
+	_, err = DecryptValueForKey("service.adminToken", unbound)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not bound to its key path")
+	}
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  adminToken: ` + unbound + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&tokenService{}, configPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "service.adminToken: encrypted value is not bound to its key path")
+	}
+}
@@ -0,0 +1,831 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/inovacc/config/internal/document"
+	"github.com/inovacc/config/internal/envvalue"
+)
+
+// envFileSuffix is appended to the name of an environment variable to name
+// a variable holding the path of a file with the value, as Docker and
+// Kubernetes provide secrets.
+const envFileSuffix = "_FILE"
+
+// maxEnvFileSize is the largest file a <VAR>_FILE variable may name.
+const maxEnvFileSize = 1 << 20
+
+// maxEnvIndex bounds the index of indexed environment variables, so that
+// a typo such as APP_SERVICE_HOSTS_99999999 cannot allocate a huge list.
+const maxEnvIndex = 1024
+
+// configKey is a leaf configuration key and the struct field holding it.
+// A key is sensitive if its field, or a struct containing it, is tagged
+// sensitive:"true".
+type configKey struct {
+	key       string
+	field     reflect.StructField
+	sensitive bool
+}
+
+// configKeys returns the leaf keys of Config with serviceType as the type
+// of the service key, in field order. Structs are descended into; every
+// other field, including lists, maps, Secret, time.Duration and
+// encoding.TextUnmarshaler fields, is a leaf.
+func configKeys(serviceType reflect.Type) []configKey {
+	var keys []configKey
+
+	rt := reflect.TypeOf(Config{})
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		name := document.FieldKey(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		if field.Name == "Service" {
+			if serviceType != nil && isStructType(serviceType) {
+				keys = appendStructKeys(keys, indirectType(serviceType), name, false, make(map[reflect.Type]bool))
+			}
+			continue
+		}
+
+		keys = appendFieldKeys(keys, field, name, false, make(map[reflect.Type]bool))
+	}
+
+	return keys
+}
+
+func appendStructKeys(keys []configKey, t reflect.Type, path string, sensitive bool, seen map[reflect.Type]bool) []configKey {
+	if seen[t] {
+		return keys
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := document.FieldKey(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		if _, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ","); field.Anonymous && opts == "squash" {
+			keys = appendStructKeys(keys, indirectType(field.Type), path, sensitive, seen)
+			continue
+		}
+
+		keys = appendFieldKeys(keys, field, joinKeyPath(path, name), sensitive, seen)
+	}
+
+	return keys
+}
+
+func appendFieldKeys(keys []configKey, field reflect.StructField, key string, sensitive bool, seen map[reflect.Type]bool) []configKey {
+	sensitive = sensitive || field.Tag.Get("sensitive") == "true"
+
+	if isStructType(field.Type) {
+		return appendStructKeys(keys, indirectType(field.Type), key, sensitive, seen)
+	}
+
+	return append(keys, configKey{key: key, field: field, sensitive: sensitive})
+}
+
+// isStructType reports whether values of type t are decoded as a group of
+// keys rather than from a single value.
+func isStructType(t reflect.Type) bool {
+	t = indirectType(t)
+
+	return t.Kind() == reflect.Struct && t != secretType &&
+		!reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// envTagNames returns the environment variable names given by the env tag
+// of field, e.g. `env:"DATABASE_URL"`. Several names are separated by
+// commas and tried in order.
+func envTagNames(field reflect.StructField) []string {
+	var names []string
+	for _, name := range strings.Split(field.Tag.Get("env"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// bindEnv binds every leaf key of the configuration to its environment
+// variables, so that variables override keys missing from the config file
+// too. Keys are bound to the variable named by SetEnvPrefix, if set, and to
+// the names given by env tags, which are used as is.
+func (c *Config) bindEnv() error {
+	for _, k := range configKeys(reflect.TypeOf(c.Service)) {
+		if c.envPrefix != "" {
+			if err := c.viper.BindEnv(k.key); err != nil {
+				return err
+			}
+		}
+
+		if names := envTagNames(k.field); len(names) > 0 {
+			if err := c.viper.BindEnv(append([]string{k.key}, names...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// envName returns the environment variable viper binds to key with the
+// given prefix.
+func envName(prefix, key string) string {
+	return envKey(prefix + "_" + key)
+}
+
+// envKey converts key to the form of an environment variable name.
+func envKey(key string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(strings.ToUpper(key))
+}
+
+// stringToSliceHook returns a decode hook splitting strings decoded into
+// slices on commas, as config files have always allowed. Strings decode
+// into []byte as they are.
+func stringToSliceHook() mapstructure.DecodeHookFunc {
+	return func(from, to reflect.Type, data any) (any, error) {
+		if from.Kind() != reflect.String || to.Kind() != reflect.Slice || to.Elem().Kind() == reflect.Uint8 {
+			return data, nil
+		}
+
+		if data == "" {
+			return []string{}, nil
+		}
+
+		return strings.Split(data.(string), ","), nil
+	}
+}
+
+// decodeEnvValue converts v, the value of an environment variable or a
+// flag for a key of type t, to lists, maps and structs using the
+// encodings of internal/envvalue: JSON, comma or whitespace separated
+// lists and key=value maps. The elements of lists and maps are converted
+// in turn. Values read from config files are not converted: YAML and JSON
+// have their own lists and maps.
+func decodeEnvValue(t reflect.Type, v any) (any, error) {
+	var err error
+
+	switch t = indirectType(t); t.Kind() {
+	case reflect.Slice, reflect.Array:
+		// Strings decode into []byte as they are.
+		if t.Elem().Kind() == reflect.Uint8 {
+			return v, nil
+		}
+
+		if s, ok := v.(string); ok {
+			if v, err = envvalue.List(s); err != nil {
+				return nil, err
+			}
+		}
+
+		if list, ok := v.([]any); ok {
+			for i := range list {
+				if list[i], err = decodeEnvValue(t.Elem(), list[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case reflect.Map:
+		if s, ok := v.(string); ok {
+			if v, err = envvalue.Map(s); err != nil {
+				return nil, err
+			}
+		}
+
+		if m, ok := v.(map[string]any); ok {
+			for key, val := range m {
+				if m[key], err = decodeEnvValue(t.Elem(), val); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case reflect.Struct:
+		if s, ok := v.(string); ok && isStructType(t) && envvalue.IsJSON(s) {
+			return envvalue.Object(s)
+		}
+	}
+
+	return v, nil
+}
+
+// decodeEnvValues returns settings with the values set by environment
+// variables converted by decodeEnvValue.
+func (c *Config) decodeEnvValues(settings map[string]any, keys []configKey) (map[string]any, error) {
+	environ := os.Environ()
+
+	for _, k := range keys {
+		if !c.fromEnv(k, environ) {
+			continue
+		}
+
+		path := strings.Split(k.key, ".")
+		value := lookupPath(settings, path)
+		if value == nil {
+			continue
+		}
+
+		decoded, err := decodeEnvValue(k.field.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envName(c.envPrefix, k.key), err)
+		}
+
+		settings = setPath(settings, path, decoded)
+	}
+
+	return settings, nil
+}
+
+// fromEnv reports whether the value of k may come from an environment
+// variable: one of its own, its _FILE variant, an indexed element, or a
+// JSON object holding a struct k belongs to.
+func (c *Config) fromEnv(k configKey, environ []string) bool {
+	for _, name := range c.envNames(k) {
+		if isEnvSet(name) {
+			return true
+		}
+	}
+
+	if c.envPrefix == "" {
+		return false
+	}
+
+	if len(indexedEnv(environ, envName(c.envPrefix, k.key)+"_")) > 0 {
+		return true
+	}
+
+	for i, r := range k.key {
+		if r == '.' && isEnvSet(envName(c.envPrefix, k.key[:i])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// settings returns the merged settings of c with the environment
+// variables viper does not read applied: JSON objects holding a whole
+// struct, such as APP_SERVICE_DB, <VAR>_FILE variables naming a file that
+// holds the value, such as APP_APPSECRET_FILE, and indexed list elements,
+// such as APP_SERVICE_UPSTREAMS_0_URL. More specific variables take
+// precedence: APP_SERVICE_DB_HOST over the host in APP_SERVICE_DB, and a
+// variable holding a whole list, such as APP_SERVICE_UPSTREAMS, over
+// indexed ones. Flags bound with BindFlags are applied last, taking
+// precedence over every variable.
+func (c *Config) settings() (map[string]any, error) {
+	settings := c.viper.AllSettings()
+	keys := configKeys(reflect.TypeOf(c.Service))
+
+	if c.envPrefix != "" {
+		for _, path := range structPaths(keys) {
+			value, ok := os.LookupEnv(envName(c.envPrefix, path))
+			if !ok || !envvalue.IsJSON(value) {
+				continue
+			}
+
+			obj, err := envvalue.Object(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", envName(c.envPrefix, path), err)
+			}
+
+			settings = c.mergeEnvObject(settings, path, obj)
+		}
+	}
+
+	settings, err := c.applyEnvFiles(settings, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.envPrefix != "" {
+		settings = c.applyIndexedEnvVars(settings, keys, os.Environ())
+	}
+
+	if settings, err = c.decodeEnvValues(settings, keys); err != nil {
+		return nil, err
+	}
+
+	return c.applyFlags(settings, keys)
+}
+
+// applyIndexedEnvVars returns settings with the indexed variables of
+// environ applied to the list keys they index.
+func (c *Config) applyIndexedEnvVars(settings map[string]any, keys []configKey, environ []string) map[string]any {
+	for _, k := range keys {
+		t := indirectType(k.field.Type)
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			continue
+		}
+
+		name := envName(c.envPrefix, k.key)
+		if isEnvSet(name) {
+			continue
+		}
+
+		values := indexedEnv(environ, name+"_")
+		if len(values) == 0 {
+			continue
+		}
+
+		path := strings.Split(k.key, ".")
+		current, _ := lookupPath(settings, path).([]any)
+		settings = setPath(settings, path, applyIndexedEnv(current, t.Elem(), values))
+	}
+
+	return settings
+}
+
+// envNames returns the environment variables of k in the order viper
+// checks them: the prefixed name, if a prefix is set, then the names given
+// by its env tag.
+func (c *Config) envNames(k configKey) []string {
+	var names []string
+	if c.envPrefix != "" {
+		names = append(names, envName(c.envPrefix, k.key))
+	}
+
+	return append(names, envTagNames(k.field)...)
+}
+
+// isEnvSet reports whether the environment variable name, or its
+// <VAR>_FILE variant, is set.
+func isEnvSet(name string) bool {
+	if _, ok := os.LookupEnv(name); ok {
+		return true
+	}
+
+	_, ok := os.LookupEnv(name + envFileSuffix)
+
+	return ok
+}
+
+// applyEnvFiles returns settings with the values of the <VAR>_FILE
+// variables of keys applied, and records their keys so that masking treats
+// them as sensitive. For each key, the variables of envNames are checked
+// in order, and the first one set wins: a plain variable over its _FILE
+// variant. A _FILE variable that is itself the variable of a key, such as
+// APP_SERVICE_LOG_FILE for service.log_file, is not a file reference.
+func (c *Config) applyEnvFiles(settings map[string]any, keys []configKey) (map[string]any, error) {
+	known := make(map[string]bool)
+	for _, k := range keys {
+		for _, name := range c.envNames(k) {
+			known[name] = true
+		}
+	}
+
+	fileKeys := make(map[string]bool)
+
+	for _, k := range keys {
+		for _, name := range c.envNames(k) {
+			fileName := name + envFileSuffix
+			_, fileSet := os.LookupEnv(fileName)
+			fileSet = fileSet && !known[fileName]
+
+			if _, ok := os.LookupEnv(name); ok {
+				if fileSet {
+					slog.Warn("Both an environment variable and its _FILE variant are set, ignoring the file", "name", name)
+				}
+				break
+			}
+
+			if !fileSet {
+				continue
+			}
+
+			value, err := readEnvFile(os.Getenv(fileName))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fileName, err)
+			}
+
+			settings = setPath(settings, strings.Split(k.key, "."), value)
+			fileKeys[k.key] = true
+
+			break
+		}
+	}
+
+	c.envFileKeys = fileKeys
+
+	return settings, nil
+}
+
+// readEnvFile returns the content of the file named by a <VAR>_FILE
+// variable, without its trailing newline, like secret files.
+func readEnvFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("reading value file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxEnvFileSize+1))
+	if err != nil {
+		return "", fmt.Errorf("reading value file: %w", err)
+	}
+
+	if len(data) > maxEnvFileSize {
+		return "", fmt.Errorf("value file %s is larger than %d bytes", path, maxEnvFileSize)
+	}
+
+	return trimNewline(data), nil
+}
+
+// structPaths returns the keys of the structs holding keys, e.g. "service"
+// and "service.db" for service.db.host, shallowest first.
+func structPaths(keys []configKey) []string {
+	var paths []string
+	for _, k := range keys {
+		for i, r := range k.key {
+			if r == '.' && !slices.Contains(paths, k.key[:i]) {
+				paths = append(paths, k.key[:i])
+			}
+		}
+	}
+
+	slices.SortStableFunc(paths, func(a, b string) int {
+		return strings.Count(a, ".") - strings.Count(b, ".")
+	})
+
+	return paths
+}
+
+// mergeEnvObject returns settings with the values of obj, decoded from the
+// environment variable of the struct at path, set below path. Values
+// whose own environment variable is set are left alone.
+func (c *Config) mergeEnvObject(settings map[string]any, path string, obj map[string]any) map[string]any {
+	for name, value := range obj {
+		key := joinKeyPath(path, name)
+		if isEnvSet(envName(c.envPrefix, key)) {
+			continue
+		}
+
+		if sub, ok := value.(map[string]any); ok {
+			settings = c.mergeEnvObject(settings, key, sub)
+			continue
+		}
+
+		settings = setPath(settings, strings.Split(key, "."), value)
+	}
+
+	return settings
+}
+
+// indexedValue is the value of an indexed environment variable: the
+// element at index or, with a suffix, the field of that element.
+type indexedValue struct {
+	name   string
+	index  int
+	suffix string
+	value  string
+}
+
+// indexedEnv returns the variables of environ named prefix followed by an
+// index and an optional field suffix, e.g. APP_SERVICE_UPSTREAMS_0_URL for
+// the prefix APP_SERVICE_UPSTREAMS_, sorted by index.
+func indexedEnv(environ []string, prefix string) []indexedValue {
+	var values []indexedValue
+
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+
+		digits, suffix, _ := strings.Cut(rest, "_")
+		index, err := strconv.Atoi(digits)
+		if err != nil || index < 0 || strconv.Itoa(index) != digits {
+			continue
+		}
+
+		values = append(values, indexedValue{name: name, index: index, suffix: suffix, value: value})
+	}
+
+	slices.SortFunc(values, func(a, b indexedValue) int {
+		if a.index != b.index {
+			return a.index - b.index
+		}
+		return strings.Compare(a.suffix, b.suffix)
+	})
+
+	return values
+}
+
+// applyIndexedEnv returns a copy of list, whose elements are of type
+// elemType, with values applied. Elements past the end of list are
+// appended; fields of struct elements are set individually.
+func applyIndexedEnv(list []any, elemType reflect.Type, values []indexedValue) []any {
+	list = slices.Clone(list)
+
+	for _, v := range values {
+		if v.index >= maxEnvIndex {
+			slog.Warn("Ignoring environment variable with too large an index", "name", v.name, "max", maxEnvIndex-1)
+			continue
+		}
+
+		var path []string
+		if v.suffix != "" {
+			if path = elemKeyPath(elemType, v.suffix); path == nil {
+				continue
+			}
+		}
+
+		for len(list) <= v.index {
+			list = append(list, nil)
+		}
+
+		if path == nil {
+			list[v.index] = v.value
+			continue
+		}
+
+		elem, _ := list[v.index].(map[string]any)
+		list[v.index] = setPath(elem, path, v.value)
+	}
+
+	return list
+}
+
+// elemKeyPath returns the path of the key of the struct type elemType
+// whose environment variable suffix is suffix, e.g. ["tls", "cert"] for
+// TLS_CERT, or nil if there is none.
+func elemKeyPath(elemType reflect.Type, suffix string) []string {
+	if !isStructType(elemType) {
+		return nil
+	}
+
+	for _, k := range appendStructKeys(nil, indirectType(elemType), "", false, make(map[reflect.Type]bool)) {
+		if envKey(k.key) == suffix {
+			return strings.Split(k.key, ".")
+		}
+	}
+
+	return nil
+}
+
+// lookupPath returns the value at path in m, matching keys
+// case-insensitively as viper does.
+func lookupPath(m map[string]any, path []string) any {
+	var value any = m
+	for _, name := range path {
+		sub, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if value, ok = sub[foldKey(sub, name)]; !ok {
+			return nil
+		}
+	}
+
+	return value
+}
+
+// setPath returns a copy of m with value set at path. Maps along path are
+// copied rather than modified, as they may be shared with viper.
+func setPath(m map[string]any, path []string, value any) map[string]any {
+	cp := make(map[string]any, len(m)+1)
+	for k, v := range m {
+		cp[k] = v
+	}
+
+	key := foldKey(cp, path[0])
+	if len(path) == 1 {
+		cp[key] = value
+		return cp
+	}
+
+	sub, _ := cp[key].(map[string]any)
+	cp[key] = setPath(sub, path[1:], value)
+
+	return cp
+}
+
+// foldKey returns the key of m equal to name under case folding, or name
+// if there is none.
+func foldKey(m map[string]any, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+
+	return name
+}
+
+// UnknownEnvVar is a prefixed environment variable matching no
+// configuration key.
+type UnknownEnvVar struct {
+	// Name is the name of the variable.
+	Name string
+	// Suggestion is the variable of a key with the closest name, or empty
+	// if no name is close.
+	Suggestion string
+}
+
+func (v UnknownEnvVar) String() string {
+	if v.Suggestion == "" {
+		return v.Name
+	}
+
+	return v.Name + " (did you mean " + v.Suggestion + "?)"
+}
+
+// UnknownEnvError is returned by InitServiceConfig with WithStrictEnv when
+// environment variables with the prefix set by SetEnvPrefix match no
+// configuration key.
+type UnknownEnvError struct {
+	Vars []UnknownEnvVar
+}
+
+func (e *UnknownEnvError) Error() string {
+	vars := make([]string, len(e.Vars))
+	for i, v := range e.Vars {
+		vars[i] = v.String()
+	}
+
+	return "unknown environment variables: " + strings.Join(vars, ", ")
+}
+
+// WithStrictEnv makes InitServiceConfig fail with an *UnknownEnvError when
+// environment variables with the prefix set by SetEnvPrefix match no
+// configuration key, such as a misspelled APP_LOGER_LOGLEVEL. Without it,
+// such variables are only logged as warnings.
+//
+// Example:
+//
+//	config.SetEnvPrefix("APP")
+//	err := config.InitServiceConfig(svc, "config.yaml", config.WithStrictEnv())
+//	// unknown environment variables: APP_LOGER_LOGLEVEL (did you mean APP_LOGGER_LOGLEVEL?)
+func WithStrictEnv() Option {
+	return func(c *Config) {
+		c.strictEnv = true
+	}
+}
+
+// maxSuggestionDistance is the largest edit distance between an unknown
+// variable and the variable suggested in its place.
+const maxSuggestionDistance = 3
+
+// checkEnv reports the environment variables with the prefix set by
+// SetEnvPrefix that match no key: as an error with WithStrictEnv, as
+// warnings otherwise.
+func (c *Config) checkEnv() error {
+	if c.envPrefix == "" {
+		return nil
+	}
+
+	unknown := c.unknownEnv(os.Environ())
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	if c.strictEnv {
+		return &UnknownEnvError{Vars: unknown}
+	}
+
+	for _, v := range unknown {
+		slog.Warn("Environment variable matches no configuration key", "name", v.Name, "suggestion", v.Suggestion)
+	}
+
+	return nil
+}
+
+// unknownEnv returns the variables of environ with the prefix set by
+// SetEnvPrefix that are not read for any key, sorted by name.
+func (c *Config) unknownEnv(environ []string) []UnknownEnvVar {
+	keys := configKeys(reflect.TypeOf(c.Service))
+
+	// Suggestions are scored against the plain names, or against the _FILE
+	// names for a _FILE variable, so that a misspelt map entry such as
+	// APP_SERVICE_HEADERS_FOO is not taken for APP_SERVICE_HEADERS_FILE.
+	var plain, files []string
+	for _, k := range keys {
+		for _, name := range c.envNames(k) {
+			plain = append(plain, name)
+			files = append(files, name+envFileSuffix)
+		}
+	}
+	for _, path := range structPaths(keys) {
+		plain = append(plain, envName(c.envPrefix, path))
+	}
+
+	prefix := strings.ToUpper(c.envPrefix) + "_"
+
+	var unknown []UnknownEnvVar
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, prefix) || slices.Contains(plain, name) || slices.Contains(files, name) {
+			continue
+		}
+
+		candidates := plain
+		if strings.HasSuffix(name, envFileSuffix) {
+			candidates = files
+		}
+		if indexed, ok := c.indexedCandidates(keys, name); ok {
+			if indexed == nil {
+				continue
+			}
+			candidates = append(slices.Clip(candidates), indexed...)
+		}
+
+		unknown = append(unknown, UnknownEnvVar{Name: name, Suggestion: closestName(name, candidates)})
+	}
+
+	slices.SortFunc(unknown, func(a, b UnknownEnvVar) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return unknown
+}
+
+// indexedCandidates reports whether name is an indexed variable of a list
+// key, such as APP_SERVICE_UPSTREAMS_0_URL. If the variable is read, the
+// returned candidates are nil; otherwise they are the variables of the
+// fields of the indexed element, to suggest one in its place.
+func (c *Config) indexedCandidates(keys []configKey, name string) ([]string, bool) {
+	for _, k := range keys {
+		t := indirectType(k.field.Type)
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			continue
+		}
+
+		prefix := envName(c.envPrefix, k.key) + "_"
+		values := indexedEnv([]string{name + "="}, prefix)
+		if len(values) == 0 {
+			continue
+		}
+
+		v := values[0]
+		if v.index < maxEnvIndex && (v.suffix == "" || elemKeyPath(t.Elem(), v.suffix) != nil) {
+			return nil, true
+		}
+
+		var candidates []string
+		if isStructType(t.Elem()) {
+			elemPrefix := prefix + strconv.Itoa(v.index) + "_"
+			for _, ek := range appendStructKeys(nil, indirectType(t.Elem()), "", false, make(map[reflect.Type]bool)) {
+				candidates = append(candidates, elemPrefix+envKey(ek.key))
+			}
+		}
+
+		return candidates, true
+	}
+
+	return nil, false
+}
+
+// closestName returns the name of candidates closest to name, if its edit
+// distance is at most maxSuggestionDistance.
+func closestName(name string, candidates []string) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
@@ -0,0 +1,534 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type envDatabase struct {
+	Host    string        `yaml:"host"`
+	Port    int           `yaml:"port"`
+	URL     string        `yaml:"url" env:"DATABASE_URL"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type EnvBase struct {
+	Region string `yaml:"region"`
+}
+
+type envService struct {
+	EnvBase  `mapstructure:",squash"`
+	Name     string            `yaml:"name"`
+	DB       envDatabase       `yaml:"db"`
+	Password Secret            `yaml:"password" env:"SERVICE_PASSWORD,DB_PASSWORD"`
+	Labels   map[string]string `yaml:"labels"`
+	Ignored  string            `yaml:"-"`
+}
+
+func TestConfigKeys(t *testing.T) {
+	var keys []string
+	for _, k := range configKeys(reflect.TypeOf(&envService{})) {
+		keys = append(keys, k.key)
+	}
+
+	assert.Equal(t, []string{
+		"version",
+		"environment",
+		"appID",
+		"appSecret",
+		"logger.logLevel",
+		"service.region",
+		"service.name",
+		"service.db.host",
+		"service.db.port",
+		"service.db.url",
+		"service.db.timeout",
+		"service.password",
+		"service.labels",
+	}, keys)
+}
+
+func TestBindEnvNestedKeys(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	// Nothing under service.db is in the file.
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  name: api
+`)
+
+	t.Setenv("APP_SERVICE_DB_HOST", "db.local")
+	t.Setenv("APP_SERVICE_DB_PORT", "5432")
+	t.Setenv("APP_SERVICE_DB_TIMEOUT", "3s")
+	t.Setenv("APP_SERVICE_REGION", "eu-west-1")
+	SetEnvPrefix("APP")
+
+	svc := &envService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "api", svc.Name)
+	assert.Equal(t, "db.local", svc.DB.Host)
+	assert.Equal(t, 5432, svc.DB.Port)
+	assert.Equal(t, 3*time.Second, svc.DB.Timeout)
+	assert.Equal(t, "eu-west-1", svc.Region)
+}
+
+func TestBindEnvTag(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  db:
+    url: postgres://file
+`)
+
+	// Env tag names bypass the prefix and need no prefix at all.
+	t.Setenv("DATABASE_URL", "postgres://env")
+	t.Setenv("DB_PASSWORD", "second-choice")
+
+	svc := &envService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "postgres://env", svc.DB.URL)
+	assert.Equal(t, "second-choice", svc.Password.Reveal())
+
+	// Names are tried in order.
+	t.Setenv("SERVICE_PASSWORD", "first-choice")
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "first-choice", svc.Password.Reveal())
+}
+
+func TestBindEnvTagWithPrefix(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+`)
+
+	t.Setenv("DATABASE_URL", "postgres://custom")
+	SetEnvPrefix("APP")
+
+	svc := &envService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "postgres://custom", svc.DB.URL)
+
+	// The prefixed name is checked first.
+	t.Setenv("APP_SERVICE_DB_URL", "postgres://prefixed")
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "postgres://prefixed", svc.DB.URL)
+}
+
+type envUpstream struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+	TLS    struct {
+		Cert string `yaml:"cert"`
+	} `yaml:"tls"`
+}
+
+type envEncodedService struct {
+	AllowedHosts []string          `yaml:"allowedHosts"`
+	Ports        []int             `yaml:"ports"`
+	Labels       map[string]string `yaml:"labels"`
+	Limits       map[string]int    `yaml:"limits"`
+	Upstreams    []envUpstream     `yaml:"upstreams"`
+	Primary      envUpstream       `yaml:"primary"`
+	Raw          []byte            `yaml:"raw"`
+}
+
+const envEncodedConfig = `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  upstreams:
+    - url: http://a.local
+      weight: 1
+    - url: http://b.local
+      weight: 2
+`
+
+func loadEnvEncoded(t *testing.T) *envEncodedService {
+	t.Helper()
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", envEncodedConfig)
+
+	SetEnvPrefix("APP")
+
+	svc := &envEncodedService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+
+	return svc
+}
+
+func TestEnvLists(t *testing.T) {
+	t.Run("comma", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS", "a.local, b.local")
+		t.Setenv("APP_SERVICE_PORTS", "80,443")
+		svc := loadEnvEncoded(t)
+		assert.Equal(t, []string{"a.local", "b.local"}, svc.AllowedHosts)
+		assert.Equal(t, []int{80, 443}, svc.Ports)
+	})
+
+	t.Run("whitespace", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS", "a.local b.local\tc.local")
+		svc := loadEnvEncoded(t)
+		assert.Equal(t, []string{"a.local", "b.local", "c.local"}, svc.AllowedHosts)
+	})
+
+	t.Run("single", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS", "a.local")
+		svc := loadEnvEncoded(t)
+		assert.Equal(t, []string{"a.local"}, svc.AllowedHosts)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS", `["a.local", "b,c.local"]`)
+		t.Setenv("APP_SERVICE_PORTS", `[80, 443]`)
+		svc := loadEnvEncoded(t)
+		assert.Equal(t, []string{"a.local", "b,c.local"}, svc.AllowedHosts)
+		assert.Equal(t, []int{80, 443}, svc.Ports)
+	})
+
+	t.Run("bytes are not split", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_RAW", "a b,c")
+		svc := loadEnvEncoded(t)
+		assert.Equal(t, []byte("a b,c"), svc.Raw)
+	})
+}
+
+func TestEnvEncodingsOnlyApplyToEnv(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configContent := `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  allowedHosts: "a.local b.local"
+  ports: "80,443"
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	SetEnvPrefix("APP")
+
+	// Strings in the file keep their meaning: no whitespace splitting,
+	// and comma separated lists as before.
+	svc := &envEncodedService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, []string{"a.local b.local"}, svc.AllowedHosts)
+	assert.Equal(t, []int{80, 443}, svc.Ports)
+}
+
+func TestEnvMaps(t *testing.T) {
+	t.Run("pairs", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_LABELS", "team=core, tier=gold")
+		t.Setenv("APP_SERVICE_LIMITS", "read=10,write=5")
+		svc := loadEnvEncoded(t)
+		assert.Equal(t, map[string]string{"team": "core", "tier": "gold"}, svc.Labels)
+		assert.Equal(t, map[string]int{"read": 10, "write": 5}, svc.Limits)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_LIMITS", `{"read": 10}`)
+		svc := loadEnvEncoded(t)
+		assert.Equal(t, map[string]int{"read": 10}, svc.Limits)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		resetGlobalConfig(t)
+		tempDir := setupTestDir(t)
+		configPath := createTestConfig(t, tempDir, "config.yaml", envEncodedConfig)
+
+		t.Setenv("APP_SERVICE_LABELS", "team")
+		SetEnvPrefix("APP")
+
+		err := InitServiceConfig(&envEncodedService{}, configPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid map entry "team": expected key=value`)
+	})
+}
+
+func TestEnvJSONStruct(t *testing.T) {
+	t.Setenv("APP_SERVICE_PRIMARY", `{"url": "http://p.local", "weight": 5, "tls": {"cert": "p.pem"}}`)
+	t.Setenv("APP_SERVICE_UPSTREAMS", `[{"url": "http://c.local", "weight": 3}]`)
+	svc := loadEnvEncoded(t)
+
+	assert.Equal(t, "http://p.local", svc.Primary.URL)
+	assert.Equal(t, 5, svc.Primary.Weight)
+	assert.Equal(t, "p.pem", svc.Primary.TLS.Cert)
+	require.Len(t, svc.Upstreams, 1)
+	assert.Equal(t, "http://c.local", svc.Upstreams[0].URL)
+	assert.Equal(t, 3, svc.Upstreams[0].Weight)
+}
+
+func TestEnvJSONStructPrecedence(t *testing.T) {
+	// The variable of a field wins over the field in the struct's JSON.
+	t.Setenv("APP_SERVICE_PRIMARY", `{"url": "http://p.local", "weight": 5}`)
+	t.Setenv("APP_SERVICE_PRIMARY_WEIGHT", "9")
+	svc := loadEnvEncoded(t)
+
+	assert.Equal(t, "http://p.local", svc.Primary.URL)
+	assert.Equal(t, 9, svc.Primary.Weight)
+
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", envEncodedConfig)
+
+	t.Setenv("APP_SERVICE_PRIMARY", `{"url": `)
+	SetEnvPrefix("APP")
+
+	err := InitServiceConfig(&envEncodedService{}, configPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "APP_SERVICE_PRIMARY: invalid JSON object")
+}
+
+func TestEnvIndexedKeys(t *testing.T) {
+	t.Run("fields", func(t *testing.T) {
+		// Index 0 is merged with the file, index 2 is appended.
+		t.Setenv("APP_SERVICE_UPSTREAMS_0_URL", "http://override.local")
+		t.Setenv("APP_SERVICE_UPSTREAMS_2_URL", "http://c.local")
+		t.Setenv("APP_SERVICE_UPSTREAMS_2_WEIGHT", "3")
+		t.Setenv("APP_SERVICE_UPSTREAMS_2_TLS_CERT", "c.pem")
+		svc := loadEnvEncoded(t)
+
+		require.Len(t, svc.Upstreams, 3)
+		assert.Equal(t, envUpstream{URL: "http://override.local", Weight: 1}, svc.Upstreams[0])
+		assert.Equal(t, "http://b.local", svc.Upstreams[1].URL)
+		assert.Equal(t, "http://c.local", svc.Upstreams[2].URL)
+		assert.Equal(t, 3, svc.Upstreams[2].Weight)
+		assert.Equal(t, "c.pem", svc.Upstreams[2].TLS.Cert)
+
+		// The settings read by viper are not modified.
+		cfg := globalConfig.viper.Get("service.upstreams").([]any)
+		assert.Len(t, cfg, 2)
+		assert.Equal(t, "http://a.local", cfg[0].(map[string]any)["url"])
+	})
+
+	t.Run("elements", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS_0", "a.local")
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS_1", "b.local")
+		t.Setenv("APP_SERVICE_UPSTREAMS_1", `{"url": "http://json.local"}`)
+		svc := loadEnvEncoded(t)
+
+		assert.Equal(t, []string{"a.local", "b.local"}, svc.AllowedHosts)
+		assert.Equal(t, "http://json.local", svc.Upstreams[1].URL)
+		assert.Zero(t, svc.Upstreams[1].Weight)
+	})
+
+	t.Run("whole list wins", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS", "x.local")
+		t.Setenv("APP_SERVICE_ALLOWEDHOSTS_1", "b.local")
+		svc := loadEnvEncoded(t)
+
+		assert.Equal(t, []string{"x.local"}, svc.AllowedHosts)
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		t.Setenv("APP_SERVICE_UPSTREAMS_0_UNKNOWN", "x")
+		t.Setenv("APP_SERVICE_UPSTREAMS_01_URL", "http://leading-zero.local")
+		t.Setenv("APP_SERVICE_UPSTREAMS_5000_URL", "http://too-far.local")
+		svc := loadEnvEncoded(t)
+
+		require.Len(t, svc.Upstreams, 2)
+		assert.Equal(t, "http://a.local", svc.Upstreams[0].URL)
+	})
+}
+
+func TestEnvEncodingsWithSchema(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", envEncodedConfig)
+
+	schema, err := JSONSchema[*envEncodedService]()
+	require.NoError(t, err)
+
+	t.Setenv("APP_SERVICE_ALLOWEDHOSTS", "a.local b.local")
+	t.Setenv("APP_SERVICE_LABELS", "team=core")
+	t.Setenv("APP_SERVICE_UPSTREAMS_2_WEIGHT", "3")
+	SetEnvPrefix("APP")
+
+	svc := &envEncodedService{}
+	require.NoError(t, InitServiceConfig(svc, configPath, WithSchema(schema)))
+	assert.Equal(t, map[string]string{"team": "core"}, svc.Labels)
+	assert.Equal(t, 3, svc.Upstreams[2].Weight)
+
+	t.Setenv("APP_SERVICE_UPSTREAMS_2_WEIGHT", "heavy")
+	err = InitServiceConfig(svc, configPath, WithSchema(schema))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/service/upstreams/2/weight: must be integer, got string")
+}
+
+type envFileService struct {
+	Token   string `yaml:"token"`
+	Log     string `yaml:"log"`
+	LogFile string `yaml:"log_file" mapstructure:"log_file"`
+	DB      struct {
+		URL string `yaml:"url" env:"DATABASE_URL"`
+	} `yaml:"db"`
+}
+
+func writeSecretFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestEnvFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  token: from-file
+`)
+
+	t.Setenv("APP_APPSECRET_FILE", writeSecretFile(t, tempDir, "app_secret", "docker-secret-value\n"))
+	t.Setenv("APP_SERVICE_TOKEN_FILE", writeSecretFile(t, tempDir, "token", "  tok-123\r\n"))
+	t.Setenv("DATABASE_URL_FILE", writeSecretFile(t, tempDir, "db_url", "postgres://secret"))
+	SetEnvPrefix("APP")
+
+	svc := &envFileService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+
+	cfg := GetBaseConfig()
+	assert.Equal(t, "docker-secret-value", cfg.AppSecret)
+	// Only the trailing newline is trimmed, as for secret:// files.
+	assert.Equal(t, "  tok-123", svc.Token)
+	assert.Equal(t, "postgres://secret", svc.DB.URL)
+
+	// Values read from files are masked like secrets.
+	secure := GetSecureCopy()
+	assert.Equal(t, maskedValue, secure.AppSecret)
+	secureSvc := secure.Service.(*envFileService)
+	assert.Equal(t, maskedValue, secureSvc.Token)
+	assert.Equal(t, maskedValue, secureSvc.DB.URL)
+	assert.Empty(t, secureSvc.Log)
+}
+
+func TestEnvFilePrecedence(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+`)
+
+	// The plain variable wins over its _FILE variant.
+	t.Setenv("APP_SERVICE_TOKEN", "plain")
+	t.Setenv("APP_SERVICE_TOKEN_FILE", writeSecretFile(t, tempDir, "token", "from-file"))
+	// The prefixed name wins over env tag names, whichever form is used.
+	t.Setenv("APP_SERVICE_DB_URL_FILE", writeSecretFile(t, tempDir, "db_url", "postgres://prefixed-file"))
+	t.Setenv("DATABASE_URL", "postgres://tag")
+	// APP_SERVICE_LOG_FILE is the variable of service.log_file.
+	t.Setenv("APP_SERVICE_LOG_FILE", "/var/log/app.log")
+	SetEnvPrefix("APP")
+
+	svc := &envFileService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "plain", svc.Token)
+	assert.Equal(t, "postgres://prefixed-file", svc.DB.URL)
+	assert.Equal(t, "/var/log/app.log", svc.LogFile)
+	assert.Empty(t, svc.Log)
+
+	secureSvc := GetSecureCopy().Service.(*envFileService)
+	assert.Equal(t, "plain", secureSvc.Token)
+	assert.Equal(t, "/var/log/app.log", secureSvc.LogFile)
+}
+
+func TestEnvFileErrors(t *testing.T) {
+	tempDir := setupTestDir(t)
+	configContent := `appID: validappid12345
+appSecret: validappsecret12345
+`
+
+	t.Run("missing file", func(t *testing.T) {
+		resetGlobalConfig(t)
+		configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+		t.Setenv("APP_SERVICE_TOKEN_FILE", filepath.Join(tempDir, "missing"))
+		SetEnvPrefix("APP")
+
+		err := InitServiceConfig(&envFileService{}, configPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "APP_SERVICE_TOKEN_FILE: reading value file")
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		resetGlobalConfig(t)
+		configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+		big := writeSecretFile(t, tempDir, "big", strings.Repeat("x", maxEnvFileSize+1))
+		t.Setenv("APP_SERVICE_TOKEN_FILE", big)
+		SetEnvPrefix("APP")
+
+		err := InitServiceConfig(&envFileService{}, configPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is larger than 1048576 bytes")
+	})
+}
+
+func TestUnknownEnv(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", envEncodedConfig)
+
+	// Known variables in every form are not reported.
+	t.Setenv("APP_LOGGER_LOGLEVEL", "INFO")
+	t.Setenv("APP_APPSECRET_FILE", writeSecretFile(t, tempDir, "secret", "validappsecret12345"))
+	t.Setenv("APP_SERVICE_PRIMARY", `{"url": "http://p.local"}`)
+	t.Setenv("APP_SERVICE_UPSTREAMS_0_TLS_CERT", "a.pem")
+	t.Setenv("APP_SERVICE_ALLOWEDHOSTS_1", "b.local")
+	t.Setenv("OTHER_SETTING", "x")
+
+	t.Setenv("APP_LOGER_LOGLEVEL", "INFO")
+	t.Setenv("APP_SERVICE_UPSTREAMS_1_WIEGHT", "2")
+	t.Setenv("APP_SERVICE_LABELS_TEAM", "core")
+	t.Setenv("APP_SERVICE_LABELS_FOO", "bar")
+	t.Setenv("APP_SERVICE_PORT_FILE", "/run/secrets/ports")
+	t.Setenv("APP_COMPLETELY_UNRELATED", "x")
+	SetEnvPrefix("APP")
+
+	// Unknown variables are only warned about by default.
+	require.NoError(t, InitServiceConfig(&envEncodedService{}, configPath))
+
+	err := InitServiceConfig(&envEncodedService{}, configPath, WithStrictEnv())
+	var envErr *UnknownEnvError
+	require.ErrorAs(t, err, &envErr)
+	assert.Equal(t, []UnknownEnvVar{
+		{Name: "APP_COMPLETELY_UNRELATED"},
+		{Name: "APP_LOGER_LOGLEVEL", Suggestion: "APP_LOGGER_LOGLEVEL"},
+		{Name: "APP_SERVICE_LABELS_FOO"},
+		{Name: "APP_SERVICE_LABELS_TEAM"},
+		{Name: "APP_SERVICE_PORT_FILE", Suggestion: "APP_SERVICE_PORTS_FILE"},
+		{Name: "APP_SERVICE_UPSTREAMS_1_WIEGHT", Suggestion: "APP_SERVICE_UPSTREAMS_1_WEIGHT"},
+	}, envErr.Vars)
+	assert.Contains(t, err.Error(), "unknown environment variables: APP_COMPLETELY_UNRELATED, APP_LOGER_LOGLEVEL (did you mean APP_LOGGER_LOGLEVEL?)")
+}
+
+func TestUnknownEnvWithoutPrefix(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", envEncodedConfig)
+
+	t.Setenv("APP_LOGER_LOGLEVEL", "INFO")
+
+	require.NoError(t, InitServiceConfig(&envEncodedService{}, configPath, WithStrictEnv()))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("APP_X", "APP_X"))
+	assert.Equal(t, 1, editDistance("APP_LOGER_LOGLEVEL", "APP_LOGGER_LOGLEVEL"))
+	assert.Equal(t, 2, editDistance("WIEGHT", "WEIGHT"))
+	assert.Equal(t, 3, editDistance("", "abc"))
+	assert.Equal(t, "", closestName("APP_COMPLETELY_UNRELATED", []string{"APP_LOGGER_LOGLEVEL"}))
+}
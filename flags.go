@@ -0,0 +1,384 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/inovacc/config/internal/viper"
+	"github.com/spf13/pflag"
+)
+
+// pflagValues is the viper.FlagValueSet of the flags of a pflag.FlagSet
+// set on the command line.
+type pflagValues struct {
+	fs *pflag.FlagSet
+}
+
+func (s pflagValues) VisitAll(fn func(viper.FlagValue)) {
+	s.fs.Visit(func(f *pflag.Flag) {
+		fn(pflagValue{f})
+	})
+}
+
+// pflagValue is a pflag.Flag as a viper.FlagValue. Lists, such as
+// stringSlice or intSlice flags, are passed as JSON arrays of their
+// elements, so that elements containing commas are kept whole.
+type pflagValue struct {
+	flag *pflag.Flag
+}
+
+func (v pflagValue) HasChanged() bool { return v.flag.Changed }
+
+func (v pflagValue) Name() string { return v.flag.Name }
+
+func (v pflagValue) ValueString() string {
+	if list, ok := v.flag.Value.(pflag.SliceValue); ok {
+		if b, err := json.Marshal(list.GetSlice()); err == nil {
+			return string(b)
+		}
+	}
+
+	return v.flag.Value.String()
+}
+
+func (v pflagValue) ValueType() string {
+	if _, ok := v.flag.Value.(pflag.SliceValue); ok {
+		return "list"
+	}
+
+	return v.flag.Value.Type()
+}
+
+// goFlagValues is the viper.FlagValueSet of the flags of a flag.FlagSet
+// set on the command line.
+type goFlagValues struct {
+	fs *flag.FlagSet
+}
+
+func (s goFlagValues) VisitAll(fn func(viper.FlagValue)) {
+	s.fs.Visit(func(f *flag.Flag) {
+		fn(goFlagValue{f})
+	})
+}
+
+// goFlagValue is a flag.Flag set on the command line as a
+// viper.FlagValue.
+type goFlagValue struct {
+	flag *flag.Flag
+}
+
+func (v goFlagValue) HasChanged() bool { return true }
+
+func (v goFlagValue) Name() string { return v.flag.Name }
+
+func (v goFlagValue) ValueString() string { return v.flag.Value.String() }
+
+func (v goFlagValue) ValueType() string {
+	if typed, ok := v.flag.Value.(interface{ Type() string }); ok {
+		return typed.Type()
+	}
+
+	return "string"
+}
+
+// keyFlagValues is the viper.FlagValueSet of the flags of set named
+// after keys.
+type keyFlagValues struct {
+	set  viper.FlagValueSet
+	keys []configKey
+}
+
+func (s keyFlagValues) VisitAll(fn func(viper.FlagValue)) {
+	s.set.VisitAll(func(f viper.FlagValue) {
+		if _, ok := flagKey(s.keys, f.Name()); ok {
+			fn(f)
+		}
+	})
+}
+
+// flagKey returns the key the flag name is named after.
+func flagKey(keys []configKey, name string) (configKey, bool) {
+	for _, k := range keys {
+		if strings.EqualFold(k.key, name) {
+			return k, true
+		}
+	}
+
+	return configKey{}, false
+}
+
+// BindFlags binds the flags of fs named after configuration keys, such as
+// --service.port or --logger.logLevel, so that flags set on the command
+// line override those keys. Flags take precedence over environment
+// variables and config files; flags left unset, and flags matching no key,
+// are ignored. Names match keys case-insensitively.
+//
+// Values are decoded like environment variables: lists from comma
+// separated values or JSON arrays, maps from key=value pairs or JSON
+// objects. pflag's list and map flag types are supported as well.
+//
+// Must be called before InitServiceConfig; the flags are read when the
+// configuration is loaded, so parse them first.
+//
+// Example:
+//
+//	cmd.Flags().Int("service.port", 8080, "Port to listen on")
+//	config.BindFlags(cmd.Flags())
+//	// after cobra parsed the flags:
+//	err := config.InitServiceConfig(svc, "config.yaml")
+func BindFlags(fs *pflag.FlagSet) {
+	bindFlagValues(pflagValues{fs})
+}
+
+// BindGoFlags is BindFlags for a flag.FlagSet of the standard library,
+// such as flag.CommandLine.
+//
+// Example:
+//
+//	flag.String("service.host", "localhost", "Host to connect to")
+//	config.BindGoFlags(flag.CommandLine)
+//	flag.Parse()
+//	err := config.InitServiceConfig(svc, "config.yaml")
+func BindGoFlags(fs *flag.FlagSet) {
+	bindFlagValues(goFlagValues{fs})
+}
+
+func bindFlagValues(set viper.FlagValueSet) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !slices.Contains(globalConfig.flags, set) {
+		globalConfig.flags = append(globalConfig.flags, set)
+	}
+}
+
+// bindFlags binds the flags set on the command line that are named after
+// a key to viper, where they take precedence over environment variables
+// and the config file. Flags are bound when the configuration is loaded,
+// after they were parsed, so that flags left unset, and their defaults,
+// are not seen by viper.
+func (c *Config) bindFlags() error {
+	keys := configKeys(reflect.TypeOf(c.Service))
+
+	for _, set := range c.flags {
+		if err := c.viper.BindFlagValues(keyFlagValues{set, keys}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterFlags adds a flag to fs for every configuration key of the
+// service configuration type T, such as *MyServiceConfig, including the
+// base Config keys, and binds fs with BindFlags. Flags are named after
+// the dotted key, such as --service.port. Their usage is the description
+// tag and their default, shown in the help, the default tag; see
+// JSONSchema. Boolean flags may be given without a value, and list flags
+// may be repeated.
+//
+// Example:
+//
+//	if err := config.RegisterFlags[*MyServiceConfig](cmd.Flags()); err != nil {
+//	    log.Fatal(err)
+//	}
+//	// myapp --service.port 9090 --logger.logLevel INFO
+func RegisterFlags[T any](fs *pflag.FlagSet) error {
+	flags, err := keyFlags[T]()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range flags {
+		pf := fs.VarPF(f, f.key, "", f.usage)
+		if f.boolean {
+			pf.NoOptDefVal = "true"
+		}
+	}
+
+	BindFlags(fs)
+
+	return nil
+}
+
+// RegisterGoFlags is RegisterFlags for a flag.FlagSet of the standard
+// library.
+//
+// Example:
+//
+//	if err := config.RegisterGoFlags[*MyServiceConfig](flag.CommandLine); err != nil {
+//	    log.Fatal(err)
+//	}
+//	flag.Parse()
+func RegisterGoFlags[T any](fs *flag.FlagSet) error {
+	flags, err := keyFlags[T]()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range flags {
+		fs.Var(f, f.key, f.usage)
+	}
+
+	BindGoFlags(fs)
+
+	return nil
+}
+
+// keyFlags returns a flag value for every configuration key of the service
+// configuration type T.
+func keyFlags[T any]() ([]*keyFlag, error) {
+	serviceType := reflect.TypeOf((*T)(nil)).Elem()
+	if !isStructType(serviceType) {
+		return nil, fmt.Errorf("service config type %s is not a struct", serviceType)
+	}
+
+	var flags []*keyFlag
+	for _, k := range configKeys(serviceType) {
+		// Values on the command line are visible to other users.
+		if k.sensitive {
+			continue
+		}
+
+		f := &keyFlag{
+			key:     k.key,
+			usage:   k.field.Tag.Get("description"),
+			typ:     flagTypeName(k.field.Type),
+			field:   k.field.Type,
+			boolean: indirectType(k.field.Type).Kind() == reflect.Bool,
+		}
+
+		if def, ok := k.field.Tag.Lookup("default"); ok {
+			f.values = []string{def}
+		}
+
+		flags = append(flags, f)
+	}
+
+	return flags, nil
+}
+
+// keyFlag is the value of a flag added by RegisterFlags. It implements
+// pflag.Value and flag.Value.
+type keyFlag struct {
+	key     string
+	usage   string
+	typ     string
+	field   reflect.Type
+	boolean bool
+	values  []string
+	set     bool
+}
+
+// String returns the value of the flag: the value given, or the values of
+// a repeated list flag as a JSON array.
+func (f *keyFlag) String() string {
+	if len(f.values) <= 1 {
+		return strings.Join(f.values, "")
+	}
+
+	b, err := json.Marshal(f.values)
+	if err != nil {
+		return strings.Join(f.values, ",")
+	}
+
+	return string(b)
+}
+
+// Set sets the value of the flag, checking that it decodes into the type
+// of the key. Setting a list flag again appends to it.
+func (f *keyFlag) Set(s string) error {
+	value, err := decodeEnvValue(f.field, s)
+	if err != nil {
+		return fmt.Errorf("setting %s: %w", f.key, err)
+	}
+
+	if err = decodeInto(reflect.New(f.field).Elem(), f.key, value); err != nil {
+		return err
+	}
+
+	if !f.set || !f.isList() {
+		f.values = nil
+	}
+	f.values = append(f.values, s)
+	f.set = true
+
+	return nil
+}
+
+// Type returns the name of the type of the flag, shown in the help.
+func (f *keyFlag) Type() string {
+	return f.typ
+}
+
+// IsBoolFlag makes the flag package accept boolean flags without a value.
+func (f *keyFlag) IsBoolFlag() bool {
+	return f.boolean
+}
+
+func (f *keyFlag) isList() bool {
+	kind := indirectType(f.field).Kind()
+
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+// flagTypeName names the type t of a key for the help of its flag.
+func flagTypeName(t reflect.Type) string {
+	t = indirectType(t)
+
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	case t == secretType:
+		return "string"
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map:
+		return "map"
+	case reflect.Interface:
+		return "value"
+	}
+
+	return t.Kind().String()
+}
+
+// applyFlags returns settings with the values of the flags set on the
+// command line, as read by viper, applied again to the keys they are
+// named after, over the values settings took from environment variables
+// viper does not read, and decoded like environment variables.
+func (c *Config) applyFlags(settings map[string]any, keys []configKey) (map[string]any, error) {
+	var err error
+
+	for _, set := range c.flags {
+		set.VisitAll(func(f viper.FlagValue) {
+			k, ok := flagKey(keys, f.Name())
+			if !ok || err != nil {
+				return
+			}
+
+			value, decodeErr := decodeEnvValue(k.field.Type, c.viper.Get(k.key))
+			if decodeErr != nil {
+				err = fmt.Errorf("flag --%s: %w", f.Name(), decodeErr)
+				return
+			}
+
+			settings = setPath(settings, strings.Split(k.key, "."), value)
+		})
+	}
+
+	return settings, err
+}
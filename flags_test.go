@@ -0,0 +1,220 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type flagService struct {
+	Host        string            `yaml:"host" default:"localhost" description:"Host to connect to"`
+	Port        int               `yaml:"port" default:"8080" description:"Port to listen on"`
+	Debug       bool              `yaml:"debug"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	Hosts       []string          `yaml:"hosts"`
+	Labels      map[string]string `yaml:"labels"`
+	Token       Secret            `yaml:"token"`
+	Password    string            `yaml:"password" sensitive:"true"`
+	Credentials struct {
+		Key string `yaml:"key"`
+	} `yaml:"credentials" sensitive:"true"`
+}
+
+const flagConfig = `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  host: file.local
+  port: 5432
+`
+
+func TestRegisterFlags(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", flagConfig)
+
+	fs := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	require.NoError(t, RegisterFlags[*flagService](fs))
+
+	require.NoError(t, fs.Parse([]string{
+		"--service.port", "9090",
+		"--service.debug",
+		"--service.timeout=3s",
+		"--service.hosts", "a.local",
+		"--service.hosts", "b.local",
+		"--service.labels", "team=core,tier=gold",
+		"--service.token", "tok",
+		"--logger.logLevel", "INFO",
+	}))
+
+	svc := &flagService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "file.local", svc.Host)
+	assert.Equal(t, 9090, svc.Port)
+	assert.True(t, svc.Debug)
+	assert.Equal(t, 3*time.Second, svc.Timeout)
+	assert.Equal(t, []string{"a.local", "b.local"}, svc.Hosts)
+	assert.Equal(t, map[string]string{"team": "core", "tier": "gold"}, svc.Labels)
+	assert.Equal(t, "tok", svc.Token.Reveal())
+	assert.Equal(t, "INFO", GetBaseConfig().Logger.LogLevel)
+}
+
+func TestRegisterFlagsUsage(t *testing.T) {
+	resetGlobalConfig(t)
+
+	fs := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	require.NoError(t, RegisterFlags[*flagService](fs))
+
+	port := fs.Lookup("service.port")
+	require.NotNil(t, port)
+	assert.Equal(t, "Port to listen on", port.Usage)
+	assert.Equal(t, "8080", port.DefValue)
+	assert.Equal(t, "int", port.Value.Type())
+	assert.Equal(t, "true", fs.Lookup("service.debug").NoOptDefVal)
+	assert.Equal(t, "duration", fs.Lookup("service.timeout").Value.Type())
+	assert.Equal(t, "list", fs.Lookup("service.hosts").Value.Type())
+	assert.NotNil(t, fs.Lookup("appID"))
+	assert.NotNil(t, fs.Lookup("logger.logLevel"))
+
+	// Sensitive keys would be visible in the process list.
+	assert.Nil(t, fs.Lookup("appSecret"))
+	assert.Nil(t, fs.Lookup("service.password"))
+	assert.Nil(t, fs.Lookup("service.credentials.key"))
+
+	usage := fs.FlagUsages()
+	assert.Contains(t, usage, "--service.port int")
+	assert.Contains(t, usage, "Port to listen on (default 8080)")
+
+	// Values are checked against the type of the key when parsed.
+	err := fs.Parse([]string{"--service.port", "eighty"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service.port")
+
+	_, err = keyFlags[string]()
+	assert.EqualError(t, err, "service config type string is not a struct")
+}
+
+func TestFlagPrecedence(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", flagConfig)
+
+	t.Setenv("APP_SERVICE_HOST", "env.local")
+	t.Setenv("APP_SERVICE_PORT", "7000")
+	SetEnvPrefix("APP")
+
+	fs := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	require.NoError(t, RegisterFlags[*flagService](fs))
+	require.NoError(t, fs.Parse([]string{"--service.port", "9090"}))
+
+	// Flags win over env vars, which win over the file. Flags left unset,
+	// including their defaults, do not override anything.
+	svc := &flagService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "env.local", svc.Host)
+	assert.Equal(t, 9090, svc.Port)
+	assert.False(t, svc.Debug)
+}
+
+func TestBindFlags(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", flagConfig)
+
+	// Flags defined by the application, as with cobra.
+	fs := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	fs.Int("service.port", 1, "")
+	fs.String("service.host", "unset.local", "")
+	fs.StringArray("service.hosts", nil, "")
+	fs.StringToString("service.labels", nil, "")
+	fs.Bool("verbose", false, "")
+	require.NoError(t, fs.Parse([]string{
+		"--service.port=9090",
+		"--service.hosts=a.local",
+		"--service.hosts=b,c.local",
+		"--service.labels=team=core",
+		"--verbose",
+	}))
+
+	BindFlags(fs)
+	BindFlags(fs)
+	assert.Len(t, globalConfig.flags, 1)
+
+	svc := &flagService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, 9090, svc.Port)
+	assert.Equal(t, "file.local", svc.Host)
+	assert.Equal(t, []string{"a.local", "b,c.local"}, svc.Hosts)
+	assert.Equal(t, map[string]string{"team": "core"}, svc.Labels)
+}
+
+func TestBindFlagsSlices(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", flagConfig)
+
+	fs := pflag.NewFlagSet("app", pflag.ContinueOnError)
+	fs.StringSlice("service.hosts", nil, "")
+	fs.IntSlice("service.ports", nil, "")
+	require.NoError(t, fs.Parse([]string{"--service.hosts=a.local,b.local", "--service.ports=80,443"}))
+	BindFlags(fs)
+
+	svc := &struct {
+		Hosts []string `yaml:"hosts"`
+		Ports []int    `yaml:"ports"`
+	}{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, []string{"a.local", "b.local"}, svc.Hosts)
+	assert.Equal(t, []int{80, 443}, svc.Ports)
+}
+
+func TestGoFlags(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", flagConfig)
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	require.NoError(t, RegisterGoFlags[*flagService](fs))
+	require.NoError(t, fs.Parse([]string{
+		"-service.port", "9090",
+		"-service.debug",
+		"-service.hosts", "a.local",
+		"-service.hosts", "b.local",
+	}))
+
+	svc := &flagService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, 9090, svc.Port)
+	assert.True(t, svc.Debug)
+	assert.Equal(t, []string{"a.local", "b.local"}, svc.Hosts)
+
+	var usage bytes.Buffer
+	fs.SetOutput(&usage)
+	fs.PrintDefaults()
+	assert.Contains(t, usage.String(), "Port to listen on (default 8080)")
+
+	assert.Error(t, fs.Parse([]string{"-service.port", "eighty"}))
+}
+
+func TestBindGoFlags(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", flagConfig)
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.String("service.host", "unset.local", "")
+	fs.Int("service.port", 1, "")
+	require.NoError(t, fs.Parse([]string{"-service.host", "flag.local"}))
+	BindGoFlags(fs)
+
+	svc := &flagService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+	assert.Equal(t, "flag.local", svc.Host)
+	assert.Equal(t, 5432, svc.Port)
+}
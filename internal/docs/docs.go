@@ -0,0 +1,455 @@
+// Package docs renders reference documentation for configuration keys
+// from a JSON Schema, such as one produced by config.JSONSchema.
+package docs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Formats supported by Render.
+const (
+	Markdown = "markdown"
+	Text     = "text"
+)
+
+// durationPattern is the pattern config.JSONSchema gives time.Duration
+// values, documented as type "duration".
+const durationPattern = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$`
+
+// Options configures Render.
+type Options struct {
+	// Format is Markdown or Text.
+	Format string
+	// EnvPrefix is the prefix set with config.SetEnvPrefix. Prefixed
+	// environment variable names are only listed when it is set; names
+	// from the x-env annotation are listed regardless.
+	EnvPrefix string
+	// Sensitive reports whether a key is sensitive besides the keys the
+	// schema marks writeOnly. It may be nil.
+	Sensitive func(key, name string) bool
+}
+
+// keyDoc documents a configuration key.
+type keyDoc struct {
+	Key         string
+	Type        string
+	Default     string
+	Allowed     string
+	Sensitive   bool
+	Env         string
+	Description string
+}
+
+var headers = []string{"Key", "Type", "Default", "Allowed values", "Sensitive", "Environment variable", "Description"}
+
+// Render renders the documentation of the keys described by schema.
+func Render(schema []byte, opts Options) ([]byte, error) {
+	if opts.Format != Markdown && opts.Format != Text {
+		return nil, fmt.Errorf("unsupported docs format %q (valid formats: %s, %s)", opts.Format, Markdown, Text)
+	}
+
+	keys, err := collectKeys(schema, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Format == Markdown {
+		return renderMarkdown(keys), nil
+	}
+
+	return renderText(keys)
+}
+
+// collectKeys returns the configuration keys described by schema, in the
+// order of its properties.
+func collectKeys(schema []byte, opts Options) ([]keyDoc, error) {
+	root, err := parseSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []keyDoc
+	collect(root, "", false, opts, &keys)
+
+	return keys, nil
+}
+
+// envVar returns the environment variable overriding key, as named by
+// viper with the given prefix and the "." and "-" to "_" replacer.
+func envVar(prefix, key string) string {
+	if prefix == "" {
+		return ""
+	}
+
+	return strings.NewReplacer(".", "_", "-", "_").Replace(strings.ToUpper(prefix + "_" + key))
+}
+
+// envVars lists the environment variables overriding key: the prefixed
+// name followed by the custom names of the x-env annotation.
+func envVars(prefix, key string, fields map[string]any) string {
+	var names []string
+	if name := envVar(prefix, key); name != "" {
+		names = append(names, name)
+	}
+
+	custom, _ := fields["x-env"].([]any)
+	for _, name := range custom {
+		if name, ok := name.(string); ok {
+			names = append(names, name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// schemaNode is a parsed schema keeping the order of its properties.
+type schemaNode struct {
+	fields     map[string]any
+	properties []namedNode
+	items      *schemaNode
+	additional *schemaNode
+}
+
+type namedNode struct {
+	name string
+	node *schemaNode
+}
+
+func parseSchema(data []byte) (*schemaNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	node, err := decodeNode(dec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+
+	return node, nil
+}
+
+// decodeNode decodes the schema object at the current position of dec.
+// Unlike decoding into a map, it keeps the order of properties.
+func decodeNode(dec *json.Decoder) (*schemaNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &schemaNode{fields: make(map[string]any)}
+	if b, ok := tok.(bool); ok {
+		node.fields["boolean"] = b
+		return node, nil
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected a schema object, got %v", tok)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keyword, _ := tok.(string)
+
+		switch keyword {
+		case "properties":
+			if node.properties, err = decodeProperties(dec); err != nil {
+				return nil, err
+			}
+		case "items", "additionalProperties":
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+				continue
+			}
+			sub, err := decodeNode(json.NewDecoder(bytes.NewReader(raw)))
+			if err != nil {
+				return nil, err
+			}
+			if keyword == "items" {
+				node.items = sub
+			} else {
+				node.additional = sub
+			}
+		default:
+			var value any
+			if err := dec.Decode(&value); err != nil {
+				return nil, err
+			}
+			node.fields[keyword] = value
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func decodeProperties(dec *json.Decoder) ([]namedNode, error) {
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected properties object, got %v", tok)
+	}
+
+	var properties []namedNode
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := decodeNode(dec)
+		if err != nil {
+			return nil, err
+		}
+
+		name, _ := tok.(string)
+		properties = append(properties, namedNode{name: name, node: node})
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return properties, nil
+}
+
+// collect appends the keys of node at path to keys. Objects with
+// properties are descended into, everything else is a key.
+func collect(node *schemaNode, path string, sensitive bool, opts Options, keys *[]keyDoc) {
+	sensitive = sensitive || node.fields["writeOnly"] == true
+
+	if len(node.properties) > 0 {
+		for _, p := range node.properties {
+			key := p.name
+			if path != "" {
+				key = path + "." + p.name
+			}
+
+			childSensitive := sensitive || (opts.Sensitive != nil && opts.Sensitive(key, p.name))
+			collect(p.node, key, childSensitive, opts, keys)
+		}
+		return
+	}
+
+	if path == "" {
+		return
+	}
+
+	description, _ := node.fields["description"].(string)
+	*keys = append(*keys, keyDoc{
+		Key:         path,
+		Type:        typeName(node),
+		Default:     formatDefault(node.fields),
+		Allowed:     allowedValues(node.fields),
+		Sensitive:   sensitive,
+		Env:         envVars(opts.EnvPrefix, path, node.fields),
+		Description: description,
+	})
+}
+
+// typeName describes the type of the values of node.
+func typeName(node *schemaNode) string {
+	t, _ := node.fields["type"].(string)
+
+	switch {
+	case t == "string" && node.fields["pattern"] == durationPattern:
+		return "duration"
+	case t == "array" && node.items != nil:
+		return "list of " + typeName(node.items)
+	case t == "object" && node.additional != nil:
+		return "map of " + typeName(node.additional)
+	case t == "":
+		if types, ok := node.fields["type"].([]any); ok {
+			names := make([]string, len(types))
+			for i, name := range types {
+				names[i] = fmt.Sprint(name)
+			}
+			return strings.Join(names, " or ")
+		}
+		return "any"
+	}
+
+	return t
+}
+
+func formatDefault(fields map[string]any) string {
+	def, ok := fields["default"]
+	if !ok {
+		return ""
+	}
+
+	return formatValue(def)
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item)
+		}
+		return strings.Join(items, ", ")
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
+
+// allowedValues describes the enum and range constraints of a key.
+func allowedValues(fields map[string]any) string {
+	var parts []string
+
+	if enum, ok := fields["enum"].([]any); ok {
+		parts = append(parts, formatValue(enum))
+	}
+
+	if r := rangeOf(fields, "minimum", "exclusiveMinimum", "maximum", "exclusiveMaximum"); r != "" {
+		parts = append(parts, r)
+	}
+	if r := rangeOf(fields, "minLength", "", "maxLength", ""); r != "" {
+		parts = append(parts, "length "+r)
+	}
+	if r := rangeOf(fields, "minItems", "", "maxItems", ""); r != "" {
+		parts = append(parts, "items "+r)
+	}
+	if format, ok := fields["format"].(string); ok {
+		parts = append(parts, "format "+format)
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// rangeOf describes the bounds given by the keywords of fields, e.g.
+// "1..65535", ">= 8" or "< 1".
+func rangeOf(fields map[string]any, minKeyword, exclusiveMinKeyword, maxKeyword, exclusiveMaxKeyword string) string {
+	bound := func(keyword string) (string, bool) {
+		if keyword == "" {
+			return "", false
+		}
+		v, ok := fields[keyword]
+		if !ok {
+			return "", false
+		}
+		return formatValue(v), true
+	}
+
+	lower, hasMin := bound(minKeyword)
+	upper, hasMax := bound(maxKeyword)
+	exclusiveLower, hasExclusiveMin := bound(exclusiveMinKeyword)
+	exclusiveUpper, hasExclusiveMax := bound(exclusiveMaxKeyword)
+
+	var parts []string
+	switch {
+	case hasMin && hasMax && lower == upper:
+		return lower
+	case hasMin && hasMax:
+		return lower + ".." + upper
+	case hasMin:
+		parts = append(parts, ">= "+lower)
+	case hasExclusiveMin:
+		parts = append(parts, "> "+exclusiveLower)
+	}
+	switch {
+	case hasMax:
+		parts = append(parts, "<= "+upper)
+	case hasExclusiveMax:
+		parts = append(parts, "< "+exclusiveUpper)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (k keyDoc) cells() []string {
+	sensitive := ""
+	if k.Sensitive {
+		sensitive = "yes"
+	}
+
+	return []string{k.Key, k.Type, k.Default, k.Allowed, sensitive, k.Env, k.Description}
+}
+
+func renderMarkdown(keys []keyDoc) []byte {
+	var buf bytes.Buffer
+
+	writeRow := func(cells []string) {
+		buf.WriteString("|")
+		for _, cell := range cells {
+			buf.WriteString(" " + cell + " |")
+		}
+		buf.WriteString("\n")
+	}
+
+	writeRow(headers)
+	separators := make([]string, len(headers))
+	for i, h := range headers {
+		separators[i] = strings.Repeat("-", len(h))
+	}
+	writeRow(separators)
+
+	escape := strings.NewReplacer("|", `\|`, "\n", " ")
+	for _, k := range keys {
+		cells := k.cells()
+		for i, cell := range cells {
+			cell = escape.Replace(cell)
+			// Code spans for the key and variable names.
+			if cell != "" && slices.Contains([]int{0, 5}, i) {
+				cell = "`" + strings.ReplaceAll(cell, ", ", "`, `") + "`"
+			}
+			cells[i] = cell
+		}
+		writeRow(cells)
+	}
+
+	return buf.Bytes()
+}
+
+func renderText(keys []keyDoc) ([]byte, error) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	upper := make([]string, len(headers))
+	for i, h := range headers {
+		upper[i] = strings.ToUpper(h)
+	}
+	if _, err := fmt.Fprintln(w, strings.Join(upper, "\t")); err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		cells := k.cells()
+		for i, cell := range cells {
+			cells[i] = strings.ReplaceAll(cell, "\n", " ")
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(cells, "\t")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// Rows ending with empty cells are padded by tabwriter.
+	lines := strings.SplitAfter(buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimSuffix(line, "\n"), " ")
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,55 @@
+package docs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const schema = `{
+  "type": "object",
+  "properties": {
+    "tls": {
+      "type": "object",
+      "writeOnly": true,
+      "properties": {"cert": {"type": "string"}, "key": {"type": "string"}}
+    },
+    "port": {"type": ["integer", "null"], "exclusiveMinimum": 0},
+    "tags": {"type": "array", "items": {"type": "string"}, "maxItems": 3},
+    "pair": {"type": "array", "items": [{"type": "string"}, {"type": "integer"}]},
+    "limits": {"type": "object", "additionalProperties": {"type": "integer"}},
+    "timeout": {"type": "string", "pattern": "^[-+]?(0|([0-9]*(\\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$", "default": "5s"},
+    "name": {"type": "string", "minLength": 1, "maxLength": 10, "description": "Display name\nshown in the UI"},
+    "extra": true
+  }
+}`
+
+func TestCollectKeys(t *testing.T) {
+	keys, err := collectKeys([]byte(schema), Options{
+		EnvPrefix: "my-app",
+		Sensitive: func(key, name string) bool { return name == "limits" },
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []keyDoc{
+		{Key: "tls.cert", Type: "string", Sensitive: true, Env: "MY_APP_TLS_CERT"},
+		{Key: "tls.key", Type: "string", Sensitive: true, Env: "MY_APP_TLS_KEY"},
+		{Key: "port", Type: "integer or null", Allowed: "> 0", Env: "MY_APP_PORT"},
+		{Key: "tags", Type: "list of string", Allowed: "items <= 3", Env: "MY_APP_TAGS"},
+		{Key: "pair", Type: "array", Env: "MY_APP_PAIR"},
+		{Key: "limits", Type: "map of integer", Sensitive: true, Env: "MY_APP_LIMITS"},
+		{Key: "timeout", Type: "duration", Default: "5s", Env: "MY_APP_TIMEOUT"},
+		{Key: "name", Type: "string", Allowed: "length 1..10", Env: "MY_APP_NAME", Description: "Display name\nshown in the UI"},
+		{Key: "extra", Type: "any", Env: "MY_APP_EXTRA"},
+	}, keys)
+}
+
+func TestRenderMarkdownEscapesCells(t *testing.T) {
+	out, err := Render([]byte(`{"properties": {"sep": {"type": "string", "enum": ["|", ","], "description": "Field\nseparator"}}}`), Options{Format: Markdown})
+	require.NoError(t, err)
+
+	assert.Equal(t, "| Key | Type | Default | Allowed values | Sensitive | Environment variable | Description |\n"+
+		"| --- | ---- | ------- | -------------- | --------- | -------------------- | ----------- |\n"+
+		"| `sep` | string |  | \\|, , |  |  | Field separator |\n", string(out))
+}
@@ -0,0 +1,559 @@
+// Package document edits YAML and JSON configuration files in place while
+// preserving comments, key order and formatting as far as possible.
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotFound is returned when a dotted key path does not exist in a document.
+var ErrNotFound = errors.New("key not found")
+
+// Document is a parsed configuration file.
+type Document struct {
+	root     *yaml.Node
+	format   string
+	indent   int
+	original []byte
+}
+
+// Parse parses data in the given format ("yaml", "yml" or "json").
+// JSON is parsed with the YAML parser, which accepts it as a subset.
+func Parse(data []byte, format string) (*Document, error) {
+	switch format {
+	case "yaml", "yml", "json":
+	default:
+		return nil, fmt.Errorf("unsupported document format: %s", format)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parsing %s document: %w", format, err)
+	}
+
+	if root.Kind == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	return &Document{root: &root, format: format, indent: detectIndent(data), original: data}, nil
+}
+
+// Encode serialises the document in its original format.
+func (d *Document) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if d.format == "json" {
+		if err := encodeJSON(&buf, d.root, 0); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(d.indent)
+	if err := enc.Encode(d.root); err != nil {
+		return nil, fmt.Errorf("encoding yaml document: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("encoding yaml document: %w", err)
+	}
+
+	return restoreBlankLines(d.original, buf.Bytes()), nil
+}
+
+// Lookup returns the node at the dotted key path, or ErrNotFound.
+// Mapping keys are matched case-insensitively and sequence elements are
+// addressed as "items[0]". An empty path returns the top-level node.
+func (d *Document) Lookup(path string) (*yaml.Node, error) {
+	node := d.root.Content[0]
+	if path == "" {
+		return node, nil
+	}
+
+	for _, seg := range splitPath(path) {
+		node = resolveAlias(node)
+
+		switch {
+		case seg.index >= 0:
+			if node.Kind != yaml.SequenceNode || seg.index >= len(node.Content) {
+				return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+			}
+			node = node.Content[seg.index]
+		case node.Kind == yaml.MappingNode:
+			value := mappingValue(node, seg.key)
+			if value == nil {
+				return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+			}
+			node = value
+		default:
+			return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+		}
+	}
+
+	return node, nil
+}
+
+// WalkFunc is called for every scalar leaf with its dotted key path.
+type WalkFunc func(path string, node *yaml.Node) error
+
+// Walk calls fn for every scalar leaf under the node at path, in document
+// order. An empty path walks the whole document.
+func (d *Document) Walk(path string, fn WalkFunc) error {
+	node, err := d.Lookup(path)
+	if err != nil {
+		return err
+	}
+
+	return walk(node, path, fn)
+}
+
+func walk(node *yaml.Node, path string, fn WalkFunc) error {
+	node = resolveAlias(node)
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return fn(path, node)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			if err := walk(node.Content[i+1], key, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := walk(item, fmt.Sprintf("%s[%d]", path, i), fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetString replaces the value of a scalar node with a string, keeping its
+// style, comments and position.
+func SetString(node *yaml.Node, value string) {
+	node.Value = value
+	node.Tag = "!!str"
+}
+
+// Patch updates the document so that it holds data, the whole document
+// in the generic form decoded by yaml.Unmarshal. Only what differs is
+// touched: unchanged values keep their comments and style, changed scalars
+// keep their comments and position, removed keys disappear with their
+// comments, and new keys are appended in sorted order. A new key whose
+// value equals that of a removed sibling, as left by a rename, takes the
+// removed key's place and comments.
+func (d *Document) Patch(data map[string]any) error {
+	node, err := patchNode(d.root.Content[0], data)
+	if err != nil {
+		return err
+	}
+
+	d.root.Content[0] = node
+
+	return nil
+}
+
+// patchNode returns node updated to hold value, which may be node itself.
+func patchNode(node *yaml.Node, value any) (*yaml.Node, error) {
+	var current any
+	if err := node.Decode(&current); err == nil && reflect.DeepEqual(current, value) {
+		return node, nil
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if node.Kind == yaml.MappingNode {
+			return node, patchMapping(node, v)
+		}
+
+	case []any:
+		if node.Kind == yaml.SequenceNode && len(node.Content) == len(v) {
+			for i, item := range v {
+				patched, err := patchNode(node.Content[i], item)
+				if err != nil {
+					return nil, err
+				}
+				node.Content[i] = patched
+			}
+			return node, nil
+		}
+	}
+
+	replacement, err := newNode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Kind == yaml.ScalarNode && replacement.Kind == yaml.ScalarNode && node.Tag == replacement.Tag {
+		replacement.Style = node.Style
+	}
+	replacement.HeadComment = node.HeadComment
+	replacement.LineComment = node.LineComment
+	replacement.FootComment = node.FootComment
+
+	return replacement, nil
+}
+
+func patchMapping(node *yaml.Node, data map[string]any) error {
+	type pair struct{ key, value *yaml.Node }
+
+	var (
+		pairs   []pair
+		removed []int // indices of removed pairs, kept as empty slots
+		present = make(map[string]bool, len(data))
+	)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		v, ok := data[key.Value]
+		if !ok || present[key.Value] {
+			removed = append(removed, len(pairs))
+			pairs = append(pairs, pair{key: key, value: value})
+			continue
+		}
+		present[key.Value] = true
+
+		patched, err := patchNode(value, v)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, pair{key: key, value: patched})
+	}
+
+	var added []string
+	for k := range data {
+		if !present[k] {
+			added = append(added, k)
+		}
+	}
+	slices.Sort(added)
+
+	for _, k := range added {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}
+
+		// Put a renamed key where the old one was, with its comments.
+		idx := slices.IndexFunc(removed, func(i int) bool {
+			var old any
+			return pairs[i].value.Decode(&old) == nil && reflect.DeepEqual(old, data[k])
+		})
+		if idx >= 0 {
+			old := pairs[removed[idx]]
+			key.HeadComment, key.LineComment, key.FootComment = old.key.HeadComment, old.key.LineComment, old.key.FootComment
+			pairs[removed[idx]].key = key
+			removed = slices.Delete(removed, idx, idx+1)
+			continue
+		}
+
+		value, err := newNode(data[k])
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, pair{key: key, value: value})
+	}
+
+	content := make([]*yaml.Node, 0, 2*len(pairs))
+	for i, p := range pairs {
+		if !slices.Contains(removed, i) {
+			content = append(content, p.key, p.value)
+		}
+	}
+	node.Content = content
+
+	return nil
+}
+
+// newNode returns a node holding value.
+func newNode(value any) (*yaml.Node, error) {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return nil, fmt.Errorf("encoding value: %w", err)
+	}
+
+	return &node, nil
+}
+
+type segment struct {
+	key   string
+	index int
+}
+
+// splitPath splits "a.b[1].c" into segments; index is -1 for mapping keys.
+func splitPath(path string) []segment {
+	var segs []segment
+
+	for _, part := range strings.Split(path, ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		if key != "" {
+			segs = append(segs, segment{key: key, index: -1})
+		}
+
+		for rest != "" {
+			idx, tail, _ := strings.Cut(rest, "]")
+			if n, err := strconv.Atoi(idx); err == nil && n >= 0 {
+				segs = append(segs, segment{index: n})
+			} else {
+				segs = append(segs, segment{key: "[" + idx + "]", index: -1})
+			}
+			rest = strings.TrimPrefix(tail, "[")
+		}
+	}
+
+	return segs
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// detectIndent returns the smallest positive indentation used in data,
+// defaulting to two spaces.
+func detectIndent(data []byte) int {
+	indent := 0
+
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if n := len(line) - len(trimmed); n > 0 && (indent == 0 || n < indent) {
+			indent = n
+		}
+	}
+
+	if indent < 2 || indent > 8 {
+		return 2
+	}
+
+	return indent
+}
+
+// restoreBlankLines re-inserts the blank lines of original, which the YAML
+// encoder drops, into encoded. When the edit kept the number of lines,
+// lines are matched by position; otherwise a blank line is restored before
+// each encoded line that is unchanged from a line that followed one.
+func restoreBlankLines(original, encoded []byte) []byte {
+	origLines := strings.Split(strings.TrimRight(string(original), "\n"), "\n")
+	encLines := strings.Split(strings.TrimRight(string(encoded), "\n"), "\n")
+
+	// blankBefore[i] reports whether the i-th non-blank original line
+	// follows a blank line.
+	var nonBlank []string
+	var blankBefore []bool
+	blank := false
+	for _, line := range origLines {
+		if strings.TrimSpace(line) == "" {
+			blank = len(nonBlank) > 0
+			continue
+		}
+		nonBlank = append(nonBlank, line)
+		blankBefore = append(blankBefore, blank)
+		blank = false
+	}
+
+	var match []int
+	if len(nonBlank) == len(encLines) {
+		match = make([]int, len(encLines))
+		for i := range match {
+			match[i] = i
+		}
+	} else if len(nonBlank)*len(encLines) <= maxDiffCells {
+		match = matchLines(nonBlank, encLines)
+	} else {
+		return encoded
+	}
+
+	var buf bytes.Buffer
+	for j, line := range encLines {
+		if i := match[j]; i > 0 && blankBefore[i] {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes()
+}
+
+// maxDiffCells bounds the size of the table used by matchLines.
+const maxDiffCells = 4 << 20
+
+// matchLines returns, for each line of b, the index of the line of a it
+// is matched with by a longest common subsequence, or -1.
+func matchLines(a, b []string) []int {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	match := make([]int, len(b))
+	for j := range match {
+		match[j] = -1
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			match[j] = i
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	// Pair up lines left between two matches that hold the same key with
+	// a changed value, such as "version: 1" and "version: 2".
+	used := make([]bool, len(a))
+	for _, i := range match {
+		if i >= 0 {
+			used[i] = true
+		}
+	}
+	for j := range match {
+		i := 0
+		if j > 0 {
+			i = match[j-1] + 1
+		}
+		if match[j] < 0 && (j == 0 || match[j-1] >= 0) && i < len(a) && !used[i] && sameKey(a[i], b[j]) {
+			match[j] = i
+			used[i] = true
+		}
+	}
+
+	return match
+}
+
+// sameKey reports whether two lines start with the same "key:".
+func sameKey(a, b string) bool {
+	ka, _, okA := strings.Cut(a, ":")
+	kb, _, okB := strings.Cut(b, ":")
+
+	return okA && okB && ka == kb
+}
+
+// encodeJSON writes node as indented JSON, keeping mapping key order.
+func encodeJSON(buf *bytes.Buffer, node *yaml.Node, depth int) error {
+	node = resolveAlias(node)
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return encodeJSON(buf, node.Content[0], depth)
+
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+
+		buf.WriteString("{")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeIndent(buf, depth+1)
+			if err := writeJSONValue(buf, node.Content[i].Value); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := encodeJSON(buf, node.Content[i+1], depth+1); err != nil {
+				return err
+			}
+		}
+		writeIndent(buf, depth)
+		buf.WriteString("}")
+
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+
+		buf.WriteString("[")
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeIndent(buf, depth+1)
+			if err := encodeJSON(buf, item, depth+1); err != nil {
+				return err
+			}
+		}
+		writeIndent(buf, depth)
+		buf.WriteString("]")
+
+	case yaml.ScalarNode:
+		if node.ShortTag() == "!!str" {
+			return writeJSONValue(buf, node.Value)
+		}
+
+		var v any
+		if err := node.Decode(&v); err != nil {
+			return fmt.Errorf("decoding scalar at line %d: %w", node.Line, err)
+		}
+		return writeJSONValue(buf, v)
+	}
+
+	return nil
+}
+
+func writeIndent(buf *bytes.Buffer, depth int) {
+	buf.WriteByte('\n')
+	buf.WriteString(strings.Repeat("  ", depth))
+}
+
+func writeJSONValue(buf *bytes.Buffer, v any) error {
+	var tmp bytes.Buffer
+
+	enc := json.NewEncoder(&tmp)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("encoding json value: %w", err)
+	}
+
+	buf.Write(bytes.TrimSuffix(tmp.Bytes(), []byte("\n")))
+
+	return nil
+}
@@ -0,0 +1,330 @@
+package document
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const yamlDoc = `# Application config
+appID: my-app-id-12345 # inline comment
+appSecret: plain-secret
+
+# Service settings
+service:
+    db:
+        host: db.local
+        password: db-pass # rotate monthly
+    credentials:
+        - user: alice
+          token: alice-token
+    port: 8080
+`
+
+func TestWalk(t *testing.T) {
+	doc, err := Parse([]byte(yamlDoc), "yaml")
+	require.NoError(t, err)
+
+	var paths []string
+	err = doc.Walk("", func(path string, _ *yaml.Node) error {
+		paths = append(paths, path)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"appID",
+		"appSecret",
+		"service.db.host",
+		"service.db.password",
+		"service.credentials[0].user",
+		"service.credentials[0].token",
+		"service.port",
+	}, paths)
+}
+
+func TestLookup(t *testing.T) {
+	doc, err := Parse([]byte(yamlDoc), "yaml")
+	require.NoError(t, err)
+
+	node, err := doc.Lookup("service.DB.Password")
+	require.NoError(t, err)
+	assert.Equal(t, "db-pass", node.Value)
+
+	node, err = doc.Lookup("service.credentials[0].token")
+	require.NoError(t, err)
+	assert.Equal(t, "alice-token", node.Value)
+
+	_, err = doc.Lookup("service.credentials[3].token")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	_, err = doc.Lookup("service.missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEncodeYAMLPreservesComments(t *testing.T) {
+	doc, err := Parse([]byte(yamlDoc), "yaml")
+	require.NoError(t, err)
+
+	node, err := doc.Lookup("service.db.password")
+	require.NoError(t, err)
+	SetString(node, "ENC[abc+/=]")
+
+	out, err := doc.Encode()
+	require.NoError(t, err)
+
+	assert.Equal(t, `# Application config
+appID: my-app-id-12345 # inline comment
+appSecret: plain-secret
+
+# Service settings
+service:
+    db:
+        host: db.local
+        password: ENC[abc+/=] # rotate monthly
+    credentials:
+        - user: alice
+          token: alice-token
+    port: 8080
+`, string(out))
+}
+
+func TestEncodeJSONPreservesOrder(t *testing.T) {
+	jsonDoc := `{
+  "zeta": "last-first",
+  "appSecret": "plain",
+  "service": {"port": 8080, "enabled": true, "ratio": 0.5, "tags": ["a", "<b>"], "empty": {}, "none": null}
+}`
+
+	doc, err := Parse([]byte(jsonDoc), "json")
+	require.NoError(t, err)
+
+	node, err := doc.Lookup("appSecret")
+	require.NoError(t, err)
+	SetString(node, "ENC[xyz]")
+
+	out, err := doc.Encode()
+	require.NoError(t, err)
+
+	assert.Equal(t, `{
+  "zeta": "last-first",
+  "appSecret": "ENC[xyz]",
+  "service": {
+    "port": 8080,
+    "enabled": true,
+    "ratio": 0.5,
+    "tags": [
+      "a",
+      "<b>"
+    ],
+    "empty": {},
+    "none": null
+  }
+}
+`, string(out))
+}
+
+func TestParseEmptyAndUnsupported(t *testing.T) {
+	doc, err := Parse(nil, "yaml")
+	require.NoError(t, err)
+
+	out, err := doc.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", string(out))
+
+	_, err = Parse([]byte("a = 1"), "toml")
+	assert.Error(t, err)
+}
+
+func TestPatchYAMLKeepsComments(t *testing.T) {
+	original := `# Application config
+version: 1
+appID: my-app-id-12345 # inline comment
+
+# Logging
+logger:
+  # One of DEBUG, INFO, WARN, ERROR
+  logLevel: DEBUG # verbose for now
+
+# Service settings
+service:
+  hosts:
+    - a.local
+    - b.local
+  legacy: true # remove in v2
+  port: "8080"
+`
+	doc, err := Parse([]byte(original), "yaml")
+	require.NoError(t, err)
+
+	var data map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(original), &data))
+
+	data["version"] = 2
+	logger := data["logger"].(map[string]any)
+	logger["level"] = logger["logLevel"]
+	delete(logger, "logLevel")
+	service := data["service"].(map[string]any)
+	delete(service, "legacy")
+	service["port"] = "9090"
+	service["timeout"] = "30s"
+	service["hosts"] = []any{"a.local", "c.local"}
+
+	require.NoError(t, doc.Patch(data))
+
+	out, err := doc.Encode()
+	require.NoError(t, err)
+
+	assert.Equal(t, `# Application config
+version: 2
+appID: my-app-id-12345 # inline comment
+
+# Logging
+logger:
+  # One of DEBUG, INFO, WARN, ERROR
+  level: DEBUG # verbose for now
+
+# Service settings
+service:
+  hosts:
+    - a.local
+    - c.local
+  port: "9090"
+  timeout: 30s
+`, string(out))
+}
+
+func TestPatchUnchangedIsIdentity(t *testing.T) {
+	doc, err := Parse([]byte(yamlDoc), "yaml")
+	require.NoError(t, err)
+
+	var data map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(yamlDoc), &data))
+	require.NoError(t, doc.Patch(data))
+
+	out, err := doc.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, yamlDoc, string(out))
+}
+
+func TestPatchJSONKeepsOrder(t *testing.T) {
+	jsonDoc := `{
+  "zeta": "last-first",
+  "version": 1,
+  "service": {"port": 8080, "host": "db.local"}
+}`
+
+	doc, err := Parse([]byte(jsonDoc), "json")
+	require.NoError(t, err)
+
+	require.NoError(t, doc.Patch(map[string]any{
+		"zeta":    "last-first",
+		"version": 2,
+		"service": map[string]any{"port": 8080, "address": "db.local:8080"},
+		"alpha":   []any{"x"},
+	}))
+
+	out, err := doc.Encode()
+	require.NoError(t, err)
+
+	assert.Equal(t, `{
+  "zeta": "last-first",
+  "version": 2,
+  "service": {
+    "port": 8080,
+    "address": "db.local:8080"
+  },
+  "alpha": [
+    "x"
+  ]
+}
+`, string(out))
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	require.NoError(t, WriteFile(path, []byte("a: 1\n")))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Existing files keep their permissions.
+	require.NoError(t, os.Chmod(path, 0640))
+	require.NoError(t, WriteFile(path, []byte("a: 2\n")))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "a: 2\n", string(content))
+
+	assert.Error(t, WriteFile(filepath.Join(t.TempDir(), "missing", "config.yaml"), nil))
+}
+
+func TestWriteFilesRestoresOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "config.yaml")
+	profile := filepath.Join(dir, "config.prod.yaml")
+	added := filepath.Join(dir, "config.new.yaml")
+	require.NoError(t, os.WriteFile(base, []byte("a: 1\n"), 0600))
+	require.NoError(t, os.WriteFile(profile, []byte("b: 1\n"), 0600))
+
+	// The profile is renamed last and fails, as on a full disk.
+	rename = func(oldPath, newPath string) error {
+		if newPath == profile {
+			return errors.New("disk full")
+		}
+		return os.Rename(oldPath, newPath)
+	}
+	t.Cleanup(func() { rename = os.Rename })
+
+	err := WriteFiles(
+		File{Path: base, Content: []byte("a: 2\n")},
+		File{Path: added, Content: []byte("c: 1\n")},
+		File{Path: profile, Content: []byte("b: 2\n")},
+	)
+	require.ErrorContains(t, err, "disk full")
+
+	content, err := os.ReadFile(base)
+	require.NoError(t, err)
+	assert.Equal(t, "a: 1\n", string(content), "the base file is restored")
+
+	content, err = os.ReadFile(profile)
+	require.NoError(t, err)
+	assert.Equal(t, "b: 1\n", string(content))
+	assert.NoFileExists(t, added)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "temp files are removed")
+}
+
+func TestSensitiveKeys(t *testing.T) {
+	type db struct {
+		Host     string `yaml:"host"`
+		Password string `yaml:"password" sensitive:"true"`
+	}
+	type creds struct {
+		Token string `json:"token"`
+	}
+	type service struct {
+		AppSecret string `mapstructure:"appSecret" yaml:"app_secret" sensitive:"true"`
+		DB        *db    `yaml:"db"`
+		Creds     creds  `yaml:"creds" sensitive:"true"`
+		Ignored   string `yaml:"-" sensitive:"true"`
+	}
+
+	assert.Equal(t, []string{"appSecret", "db.password", "creds"}, SensitiveKeys(reflect.TypeOf(service{}), ""))
+	assert.Equal(t, []string{"service.appSecret", "service.db.password", "service.creds"}, SensitiveKeys(reflect.TypeOf(&service{}), "service"))
+
+	field, _ := reflect.TypeOf(creds{}).FieldByName("Token")
+	assert.Equal(t, "token", FieldKey(field))
+}
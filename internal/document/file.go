@@ -0,0 +1,103 @@
+package document
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// rename is os.Rename, replaced in tests to make a write fail.
+var rename = os.Rename
+
+// File is the new content of a file written by WriteFiles.
+type File struct {
+	Path    string
+	Content []byte
+}
+
+// WriteFile writes content to a temporary file in the directory of path
+// and renames it over path, so that readers never see a partial file. The
+// permissions of an existing file are kept; new files are created with
+// mode 0600.
+func WriteFile(path string, content []byte) error {
+	return WriteFiles(File{Path: path, Content: content})
+}
+
+// WriteFiles writes files as a group, like WriteFile. Every file is first
+// written to a temporary file; the temporary files are renamed over their
+// targets only once all of them were written, and if a rename fails, the
+// files already replaced get their previous content back.
+func WriteFiles(files ...File) error {
+	tmps := make([]string, 0, len(files))
+	defer func() {
+		for _, tmp := range tmps {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	previous := make([][]byte, len(files))
+
+	for i, f := range files {
+		tmp, err := writeTemp(f.Path, f.Content)
+		if err != nil {
+			return err
+		}
+		tmps = append(tmps, tmp)
+
+		if previous[i], err = os.ReadFile(f.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("reading %s: %w", f.Path, err)
+		}
+	}
+
+	for i, f := range files {
+		if err := rename(tmps[i], f.Path); err != nil {
+			restore(files[:i], previous)
+			return fmt.Errorf("renaming temp file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeTemp writes content to a new temporary file in the directory of
+// path, with the permissions of path if it exists, and returns its name.
+func writeTemp(path string, content []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*")
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	_, err = tmp.Write(content)
+	if info, statErr := os.Stat(path); err == nil && statErr == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return "", fmt.Errorf("writing temp file: %w", err)
+	}
+
+	return tmpName, nil
+}
+
+// restore puts back the previous content of files already replaced by
+// WriteFiles, removing the files that did not exist before.
+func restore(files []File, previous [][]byte) {
+	for i, f := range files {
+		if previous[i] == nil {
+			_ = os.Remove(f.Path)
+			continue
+		}
+
+		if tmp, err := writeTemp(f.Path, previous[i]); err == nil {
+			if err = rename(tmp, f.Path); err != nil {
+				_ = os.Remove(tmp)
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package document
+
+import (
+	"reflect"
+	"strings"
+)
+
+// FieldKey returns the configuration key name of a struct field, taken
+// from its mapstructure, yaml or json tag, falling back to the field name.
+func FieldKey(field reflect.StructField) string {
+	for _, tag := range []string{"mapstructure", "yaml", "json"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" {
+			return name
+		}
+	}
+
+	return field.Name
+}
+
+// SensitiveKeys returns the dotted keys of the fields of t tagged
+// `sensitive:"true"`, below the key path prefix. Structs are descended
+// into unless they are tagged themselves.
+func SensitiveKeys(t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var keys []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := FieldKey(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		if field.Tag.Get("sensitive") == "true" {
+			keys = append(keys, name)
+			continue
+		}
+		keys = append(keys, SensitiveKeys(field.Type, name)...)
+	}
+
+	return keys
+}
@@ -0,0 +1,100 @@
+// Package envvalue parses the encodings of lists, maps and structured
+// values in environment variables, whose values are always strings.
+//
+// A list is a JSON array, comma separated items or, without commas,
+// whitespace separated items. A map is a JSON object or comma separated
+// key=value pairs. A struct is a JSON object.
+package envvalue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IsJSON reports whether s holds a JSON array or object, which is decoded
+// as JSON instead of split.
+func IsJSON(s string) bool {
+	s = strings.TrimSpace(s)
+
+	return strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{")
+}
+
+// List parses a list. Items of a JSON array keep their JSON types; other
+// items are strings with surrounding whitespace removed.
+//
+// Example:
+//
+//	List("a, b")       // ["a", "b"]
+//	List("a b")        // ["a", "b"]
+//	List(`["a", 1]`)   // ["a", 1]
+func List(s string) ([]any, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "[") {
+		var items []any
+		if err := json.Unmarshal([]byte(s), &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON list: %w", err)
+		}
+		return items, nil
+	}
+
+	var parts []string
+	if strings.Contains(s, ",") {
+		parts = strings.Split(s, ",")
+	} else {
+		parts = strings.Fields(s)
+	}
+
+	items := make([]any, 0, len(parts))
+	for _, part := range parts {
+		items = append(items, strings.TrimSpace(part))
+	}
+
+	return items, nil
+}
+
+// Map parses a map. Values of a JSON object keep their JSON types; other
+// values are strings with surrounding whitespace removed.
+//
+// Example:
+//
+//	Map("team=core, tier=1")   // {"team": "core", "tier": "1"}
+//	Map(`{"tier": 1}`)         // {"tier": 1}
+func Map(s string) (map[string]any, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "{") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return nil, fmt.Errorf("invalid JSON object: %w", err)
+		}
+		return m, nil
+	}
+
+	m := make(map[string]any)
+	if s == "" {
+		return m, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid map entry %q: expected key=value", strings.TrimSpace(pair))
+		}
+		m[key] = strings.TrimSpace(value)
+	}
+
+	return m, nil
+}
+
+// Object parses a JSON object holding the fields of a struct.
+func Object(s string) (map[string]any, error) {
+	var m map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(s)), &m); err != nil {
+		return nil, fmt.Errorf("invalid JSON object: %w", err)
+	}
+
+	return m, nil
+}
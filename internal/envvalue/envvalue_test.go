@@ -0,0 +1,79 @@
+package envvalue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want []any
+	}{
+		"empty":      {"", []any{}},
+		"single":     {"a", []any{"a"}},
+		"comma":      {"a, b ,c", []any{"a", "b", "c"}},
+		"whitespace": {" a  b\tc\n", []any{"a", "b", "c"}},
+		"comma wins": {"a b, c", []any{"a b", "c"}},
+		"JSON":       {` ["a", 1, true, {"url": "x"}] `, []any{"a", float64(1), true, map[string]any{"url": "x"}}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := List(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := List(`["a",`)
+	assert.ErrorContains(t, err, "invalid JSON list")
+}
+
+func TestMap(t *testing.T) {
+	tests := map[string]struct {
+		in   string
+		want map[string]any
+	}{
+		"empty":     {"", map[string]any{}},
+		"pairs":     {"team=core, tier = 1", map[string]any{"team": "core", "tier": "1"}},
+		"empty val": {"team=", map[string]any{"team": ""}},
+		"equals":    {"query=a=b", map[string]any{"query": "a=b"}},
+		"JSON":      {`{"tier": 1, "tags": ["a"]}`, map[string]any{"tier": float64(1), "tags": []any{"a"}}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Map(tt.in)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := Map("team=core,tier")
+	assert.ErrorContains(t, err, `invalid map entry "tier": expected key=value`)
+
+	_, err = Map("=core")
+	assert.ErrorContains(t, err, "expected key=value")
+
+	_, err = Map(`{"team":`)
+	assert.ErrorContains(t, err, "invalid JSON object")
+}
+
+func TestObject(t *testing.T) {
+	got, err := Object(`{"url": "http://a", "weight": 2}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"url": "http://a", "weight": float64(2)}, got)
+
+	_, err = Object(`["a"]`)
+	assert.ErrorContains(t, err, "invalid JSON object")
+}
+
+func TestIsJSON(t *testing.T) {
+	assert.True(t, IsJSON(` {"a": 1}`))
+	assert.True(t, IsJSON(`[1]`))
+	assert.False(t, IsJSON(`a=1`))
+	assert.False(t, IsJSON(``))
+}
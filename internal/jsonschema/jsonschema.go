@@ -0,0 +1,812 @@
+// Package jsonschema validates configuration settings against a JSON
+// Schema (draft 2020-12).
+//
+// It implements the assertion keywords of the core and validation
+// vocabularies: type, enum, const, the numeric, string, array and object
+// constraints, the applicators allOf, anyOf, oneOf, not, if/then/else,
+// properties, patternProperties, additionalProperties, propertyNames,
+// prefixItems, items, contains and dependentRequired, and local $ref. The
+// draft-07 forms of items (a list) and additionalItems are also accepted.
+// format is an annotation and is not checked. References to other
+// documents are rejected, so validation never touches the network.
+//
+// Validation follows the way the config package decodes settings:
+//   - property names match case-insensitively, as configuration keys do;
+//   - strings holding a number or boolean are accepted where the schema
+//     expects one, and lists and maps in the encodings of internal/envvalue
+//     where it expects an array or object, as values from environment
+//     variables are strings converted when decoded.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/inovacc/config/internal/envvalue"
+)
+
+// Error describes a value that does not match the schema.
+type Error struct {
+	// InstanceLocation is the JSON pointer of the value, e.g. "/service/port".
+	InstanceLocation string
+	// Keyword is the schema keyword that failed, e.g. "minimum".
+	Keyword string
+	// Message describes the failure.
+	Message string
+}
+
+func (e Error) Error() string {
+	location := e.InstanceLocation
+	if location == "" {
+		location = "/"
+	}
+
+	return location + ": " + e.Message
+}
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	root *schema
+}
+
+type namedSchema struct {
+	name   string
+	schema *schema
+}
+
+type patternSchema struct {
+	re     *regexp.Regexp
+	schema *schema
+}
+
+type schema struct {
+	// boolean is set for the schemas true and false.
+	boolean *bool
+
+	refTo *schema
+
+	types    []string
+	enum     []any
+	constant any
+	hasConst bool
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	prefixItems []*schema
+	items       *schema
+	contains    *schema
+	minContains *int
+	maxContains *int
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	properties           []namedSchema
+	patternProperties    []patternSchema
+	additionalProperties *schema
+	propertyNames        *schema
+	required             []string
+	dependentRequired    map[string][]string
+	minProperties        *int
+	maxProperties        *int
+
+	allOf []*schema
+	anyOf []*schema
+	oneOf []*schema
+	not   *schema
+	ifS   *schema
+	thenS *schema
+	elseS *schema
+}
+
+// Compile parses and compiles the JSON Schema data.
+func Compile(data []byte) (*Schema, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+
+	c := &compiler{doc: doc, cache: make(map[string]*schema)}
+	if root, ok := doc.(map[string]any); ok {
+		c.id, _ = root["$id"].(string)
+	}
+
+	root, err := c.compileAt("")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{root: root}, nil
+}
+
+type compiler struct {
+	doc   any
+	id    string
+	cache map[string]*schema
+}
+
+// compileAt compiles the schema at the JSON pointer ptr of the document.
+// Schemas are cached by location, which also ends recursive references.
+func (c *compiler) compileAt(ptr string) (*schema, error) {
+	if s, ok := c.cache[ptr]; ok {
+		return s, nil
+	}
+
+	v, err := resolvePointer(c.doc, ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &schema{}
+	c.cache[ptr] = s
+
+	if err := c.compile(s, v, ptr); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (c *compiler) compile(s *schema, v any, ptr string) error {
+	if b, ok := v.(bool); ok {
+		s.boolean = &b
+		return nil
+	}
+
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("schema at %q: must be an object or boolean", "#"+ptr)
+	}
+
+	var err error
+	sub := func(keyword string) (*schema, error) {
+		if _, ok := m[keyword]; !ok {
+			return nil, nil
+		}
+		return c.compileAt(ptr + "/" + escape(keyword))
+	}
+	subList := func(keyword string) ([]*schema, error) {
+		list, ok := m[keyword].([]any)
+		if !ok {
+			return nil, nil
+		}
+		schemas := make([]*schema, len(list))
+		for i := range list {
+			if schemas[i], err = c.compileAt(fmt.Sprintf("%s/%s/%d", ptr, keyword, i)); err != nil {
+				return nil, err
+			}
+		}
+		return schemas, nil
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		target, err := c.refPointer(ref)
+		if err != nil {
+			return err
+		}
+		if s.refTo, err = c.compileAt(target); err != nil {
+			return fmt.Errorf("resolving $ref %q: %w", ref, err)
+		}
+	}
+
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				s.types = append(s.types, name)
+			}
+		}
+	}
+
+	if enum, ok := m["enum"].([]any); ok {
+		s.enum = enum
+	}
+	s.constant, s.hasConst = m["const"]
+
+	s.minimum = number(m, "minimum")
+	s.maximum = number(m, "maximum")
+	s.exclusiveMinimum = number(m, "exclusiveMinimum")
+	s.exclusiveMaximum = number(m, "exclusiveMaximum")
+	s.multipleOf = number(m, "multipleOf")
+
+	s.minLength = integer(m, "minLength")
+	s.maxLength = integer(m, "maxLength")
+	if pattern, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("schema at %q: invalid pattern: %w", "#"+ptr, err)
+		}
+	}
+
+	// Draft 2020-12 uses prefixItems and items, earlier drafts a list of
+	// items and additionalItems.
+	if _, ok := m["items"].([]any); ok {
+		if s.prefixItems, err = subList("items"); err != nil {
+			return err
+		}
+		if s.items, err = sub("additionalItems"); err != nil {
+			return err
+		}
+	} else {
+		if s.prefixItems, err = subList("prefixItems"); err != nil {
+			return err
+		}
+		if s.items, err = sub("items"); err != nil {
+			return err
+		}
+	}
+	if s.contains, err = sub("contains"); err != nil {
+		return err
+	}
+	s.minContains = integer(m, "minContains")
+	s.maxContains = integer(m, "maxContains")
+	s.minItems = integer(m, "minItems")
+	s.maxItems = integer(m, "maxItems")
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+
+	if properties, ok := m["properties"].(map[string]any); ok {
+		for _, name := range sortedKeys(properties) {
+			ps, err := c.compileAt(ptr + "/properties/" + escape(name))
+			if err != nil {
+				return err
+			}
+			s.properties = append(s.properties, namedSchema{name: name, schema: ps})
+		}
+	}
+	if patterns, ok := m["patternProperties"].(map[string]any); ok {
+		for _, pattern := range sortedKeys(patterns) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("schema at %q: invalid pattern property: %w", "#"+ptr, err)
+			}
+			ps, err := c.compileAt(ptr + "/patternProperties/" + escape(pattern))
+			if err != nil {
+				return err
+			}
+			s.patternProperties = append(s.patternProperties, patternSchema{re: re, schema: ps})
+		}
+	}
+	if s.additionalProperties, err = sub("additionalProperties"); err != nil {
+		return err
+	}
+	if s.propertyNames, err = sub("propertyNames"); err != nil {
+		return err
+	}
+	if required, ok := m["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	if dependent, ok := m["dependentRequired"].(map[string]any); ok {
+		s.dependentRequired = make(map[string][]string)
+		for name, list := range dependent {
+			list, _ := list.([]any)
+			for _, item := range list {
+				if item, ok := item.(string); ok {
+					s.dependentRequired[name] = append(s.dependentRequired[name], item)
+				}
+			}
+		}
+	}
+	s.minProperties = integer(m, "minProperties")
+	s.maxProperties = integer(m, "maxProperties")
+
+	if s.allOf, err = subList("allOf"); err != nil {
+		return err
+	}
+	if s.anyOf, err = subList("anyOf"); err != nil {
+		return err
+	}
+	if s.oneOf, err = subList("oneOf"); err != nil {
+		return err
+	}
+	if s.not, err = sub("not"); err != nil {
+		return err
+	}
+	if s.ifS, err = sub("if"); err != nil {
+		return err
+	}
+	if s.thenS, err = sub("then"); err != nil {
+		return err
+	}
+	if s.elseS, err = sub("else"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// refPointer returns the JSON pointer in the document of the local
+// reference ref.
+func (c *compiler) refPointer(ref string) (string, error) {
+	fragment, ok := strings.CutPrefix(ref, "#")
+	if !ok && c.id != "" {
+		fragment, ok = strings.CutPrefix(ref, strings.TrimSuffix(c.id, "#")+"#")
+	}
+	if !ok || (fragment != "" && !strings.HasPrefix(fragment, "/")) {
+		return "", fmt.Errorf("unsupported $ref %q: only references within the schema are supported", ref)
+	}
+
+	return fragment, nil
+}
+
+// resolvePointer returns the value at the JSON pointer ptr of doc.
+func resolvePointer(doc any, ptr string) (any, error) {
+	if ptr == "" {
+		return doc, nil
+	}
+
+	current := doc
+	for _, token := range strings.Split(ptr[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+
+		switch v := current.(type) {
+		case map[string]any:
+			next, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("%q not found in schema", "#"+ptr)
+			}
+			current = next
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("%q not found in schema", "#"+ptr)
+			}
+			current = v[i]
+		default:
+			return nil, fmt.Errorf("%q not found in schema", "#"+ptr)
+		}
+	}
+
+	return current, nil
+}
+
+// Validate validates v, a value decoded from YAML or JSON, and returns
+// the errors found. It returns nil if v matches the schema.
+func (s *Schema) Validate(v any) []Error {
+	var errs []Error
+	s.root.validate(normalize(v), "", &errs)
+
+	return errs
+}
+
+func (s *schema) validate(v any, location string, errs *[]Error) {
+	fail := func(keyword, format string, args ...any) {
+		*errs = append(*errs, Error{InstanceLocation: location, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.boolean != nil {
+		if !*s.boolean {
+			fail("false", "no value is allowed")
+		}
+		return
+	}
+
+	if s.refTo != nil {
+		s.refTo.validate(v, location, errs)
+	}
+
+	if len(s.types) > 0 {
+		converted, ok := matchType(v, s.types)
+		if !ok {
+			fail("type", "must be %s, got %s", strings.Join(s.types, " or "), typeName(v))
+			return
+		}
+		v = converted
+	}
+
+	if s.hasConst && !equal(v, normalize(s.constant)) {
+		fail("const", "must be %s", formatValue(s.constant))
+	}
+
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return equal(v, normalize(e)) }) {
+		fail("enum", "must be one of %s", formatValue(s.enum))
+	}
+
+	switch v := v.(type) {
+	case float64:
+		s.validateNumber(v, fail)
+	case string:
+		s.validateString(v, fail)
+	case []any:
+		s.validateArray(v, location, errs, fail)
+	case map[string]any:
+		s.validateObject(v, location, errs, fail)
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, location, errs)
+	}
+
+	if len(s.anyOf) > 0 && !slices.ContainsFunc(s.anyOf, func(sub *schema) bool { return sub.matches(v) }) {
+		fail("anyOf", "must match at least one schema in anyOf")
+	}
+
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.matches(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("oneOf", "must match exactly one schema in oneOf, matched %d", matched)
+		}
+	}
+
+	if s.not != nil && s.not.matches(v) {
+		fail("not", "must not match the schema in not")
+	}
+
+	if s.ifS != nil {
+		if s.ifS.matches(v) {
+			if s.thenS != nil {
+				s.thenS.validate(v, location, errs)
+			}
+		} else if s.elseS != nil {
+			s.elseS.validate(v, location, errs)
+		}
+	}
+}
+
+// matches reports whether v matches s.
+func (s *schema) matches(v any) bool {
+	var errs []Error
+	s.validate(v, "", &errs)
+
+	return len(errs) == 0
+}
+
+func (s *schema) validateNumber(v float64, fail func(string, string, ...any)) {
+	if s.minimum != nil && v < *s.minimum {
+		fail("minimum", "must be >= %v", *s.minimum)
+	}
+	if s.maximum != nil && v > *s.maximum {
+		fail("maximum", "must be <= %v", *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+		fail("exclusiveMinimum", "must be > %v", *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+		fail("exclusiveMaximum", "must be < %v", *s.exclusiveMaximum)
+	}
+	if s.multipleOf != nil && *s.multipleOf > 0 {
+		if q := v / *s.multipleOf; q != math.Trunc(q) {
+			fail("multipleOf", "must be a multiple of %v", *s.multipleOf)
+		}
+	}
+}
+
+func (s *schema) validateString(v string, fail func(string, string, ...any)) {
+	length := utf8.RuneCountInString(v)
+	if s.minLength != nil && length < *s.minLength {
+		fail("minLength", "length must be >= %d, got %d", *s.minLength, length)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		fail("maxLength", "length must be <= %d, got %d", *s.maxLength, length)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		fail("pattern", "must match pattern %q", s.pattern)
+	}
+}
+
+func (s *schema) validateArray(v []any, location string, errs *[]Error, fail func(string, string, ...any)) {
+	if s.minItems != nil && len(v) < *s.minItems {
+		fail("minItems", "must have at least %d items, got %d", *s.minItems, len(v))
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		fail("maxItems", "must have at most %d items, got %d", *s.maxItems, len(v))
+	}
+
+	if s.uniqueItems {
+		for i := range v {
+			for j := range i {
+				if equal(v[i], v[j]) {
+					fail("uniqueItems", "items %d and %d must be unique", j, i)
+				}
+			}
+		}
+	}
+
+	for i, item := range v {
+		itemLocation := location + "/" + strconv.Itoa(i)
+		switch {
+		case i < len(s.prefixItems):
+			s.prefixItems[i].validate(item, itemLocation, errs)
+		case s.items != nil:
+			s.items.validate(item, itemLocation, errs)
+		}
+	}
+
+	if s.contains != nil {
+		matched := 0
+		for _, item := range v {
+			if s.contains.matches(item) {
+				matched++
+			}
+		}
+
+		minContains := 1
+		if s.minContains != nil {
+			minContains = *s.minContains
+		}
+		if matched < minContains {
+			fail("contains", "must contain at least %d matching items, got %d", minContains, matched)
+		}
+		if s.maxContains != nil && matched > *s.maxContains {
+			fail("maxContains", "must contain at most %d matching items, got %d", *s.maxContains, matched)
+		}
+	}
+}
+
+func (s *schema) validateObject(v map[string]any, location string, errs *[]Error, fail func(string, string, ...any)) {
+	if s.minProperties != nil && len(v) < *s.minProperties {
+		fail("minProperties", "must have at least %d properties, got %d", *s.minProperties, len(v))
+	}
+	if s.maxProperties != nil && len(v) > *s.maxProperties {
+		fail("maxProperties", "must have at most %d properties, got %d", *s.maxProperties, len(v))
+	}
+
+	for _, name := range s.required {
+		if _, ok := findKey(v, name); !ok {
+			fail("required", "missing required property %q", name)
+		}
+	}
+
+	for name, required := range s.dependentRequired {
+		if _, ok := findKey(v, name); !ok {
+			continue
+		}
+		for _, dep := range required {
+			if _, ok := findKey(v, dep); !ok {
+				fail("dependentRequired", "property %q is required when %q is present", dep, name)
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(v) {
+		value := v[key]
+		name := key
+		matched := false
+
+		for _, p := range s.properties {
+			if strings.EqualFold(p.name, key) {
+				// Report the name as spelled by the schema.
+				name, matched = p.name, true
+				p.schema.validate(value, location+"/"+escape(name), errs)
+				break
+			}
+		}
+
+		for _, p := range s.patternProperties {
+			if p.re.MatchString(key) {
+				matched = true
+				p.schema.validate(value, location+"/"+escape(name), errs)
+			}
+		}
+
+		if !matched && s.additionalProperties != nil {
+			if s.additionalProperties.boolean != nil && !*s.additionalProperties.boolean {
+				fail("additionalProperties", "property %q is not allowed", key)
+			} else {
+				s.additionalProperties.validate(value, location+"/"+escape(name), errs)
+			}
+		}
+
+		if s.propertyNames != nil && !s.propertyNames.matches(key) {
+			fail("propertyNames", "property name %q is not allowed", key)
+		}
+	}
+}
+
+// matchType reports whether v is of one of types, converting strings to
+// numbers, booleans, lists and maps where only those are expected. It returns
+// the value to validate further.
+func matchType(v any, types []string) (any, bool) {
+	for _, t := range types {
+		if isType(v, t) {
+			return v, true
+		}
+	}
+
+	s, ok := v.(string)
+	if !ok || slices.Contains(types, "string") {
+		return v, false
+	}
+
+	for _, t := range types {
+		switch t {
+		case "integer", "number":
+			if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil && isType(f, t) {
+				return f, true
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+				return b, true
+			}
+		case "array":
+			if items, err := envvalue.List(s); err == nil {
+				return items, true
+			}
+		case "object":
+			if m, err := envvalue.Map(s); err == nil {
+				return m, true
+			}
+		}
+	}
+
+	return v, false
+}
+
+func isType(v any, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	}
+
+	return false
+}
+
+func typeName(v any) string {
+	for _, t := range []string{"null", "boolean", "integer", "number", "string", "array", "object"} {
+		if isType(v, t) {
+			return t
+		}
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+// normalize converts v to the types produced by encoding/json: numbers
+// become float64, slices []any and maps map[string]any.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case nil, bool, string, float64:
+		return v
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = normalize(item)
+		}
+		return out
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32:
+		return float64(rv.Float())
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Slice, reflect.Array:
+		out := make([]any, rv.Len())
+		for i := range rv.Len() {
+			out[i] = normalize(rv.Index(i).Interface())
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = normalize(iter.Value().Interface())
+		}
+		return out
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalize(rv.Elem().Interface())
+	}
+
+	// Other values, such as YAML timestamps, are validated as text.
+	return fmt.Sprint(v)
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func formatValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
+
+// findKey returns the key of m matching name case-insensitively.
+func findKey(m map[string]any, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+func number(m map[string]any, keyword string) *float64 {
+	f, ok := m[keyword].(float64)
+	if !ok {
+		return nil
+	}
+
+	return &f
+}
+
+func integer(m map[string]any, keyword string) *int {
+	f, ok := m[keyword].(float64)
+	if !ok {
+		return nil
+	}
+
+	n := int(f)
+	return &n
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+// escape escapes a JSON pointer token.
+func escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,230 @@
+package jsonschema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const serviceSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://example.com/service.schema.json",
+  "type": "object",
+  "required": ["appID", "service"],
+  "properties": {
+    "appID": {"type": "string", "minLength": 8},
+    "logger": {
+      "type": "object",
+      "properties": {
+        "logLevel": {"enum": ["DEBUG", "INFO", "WARN", "ERROR"]}
+      }
+    },
+    "service": {
+      "type": "object",
+      "required": ["port"],
+      "additionalProperties": false,
+      "properties": {
+        "port": {"type": "integer", "minimum": 1, "maximum": 65535},
+        "ratio": {"type": "number", "exclusiveMaximum": 1, "multipleOf": 0.25},
+        "debug": {"type": "boolean"},
+        "hosts": {"type": "array", "minItems": 1, "uniqueItems": true, "items": {"$ref": "#/$defs/host"}},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}, "maxProperties": 2},
+        "tls": {
+          "type": "object",
+          "dependentRequired": {"cert": ["key"]},
+          "properties": {"cert": {"type": "string"}, "key": {"type": "string"}}
+        },
+        "mode": {"$ref": "https://example.com/service.schema.json#/$defs/mode"},
+        "tree": {"$ref": "#/$defs/node"}
+      }
+    }
+  },
+  "$defs": {
+    "host": {"type": "string", "pattern": "^[a-z0-9.-]+$"},
+    "mode": {"oneOf": [{"const": "dev"}, {"const": "prod"}]},
+    "node": {
+      "type": "object",
+      "properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}},
+      "additionalProperties": {"type": "string"}
+    }
+  }
+}`
+
+func compileService(t *testing.T) *Schema {
+	t.Helper()
+
+	s, err := Compile([]byte(serviceSchema))
+	require.NoError(t, err)
+
+	return s
+}
+
+func parseYAML(t *testing.T, content string) map[string]any {
+	t.Helper()
+
+	var v map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(content), &v))
+
+	return v
+}
+
+func TestValidateValid(t *testing.T) {
+	s := compileService(t)
+
+	errs := s.Validate(parseYAML(t, `
+appid: my-app-id
+logger:
+  logLevel: INFO
+service:
+  port: 8080
+  ratio: 0.5
+  debug: "true"
+  hosts: [a.local, b.local]
+  labels: {team: core}
+  tls: {cert: c.pem, key: k.pem}
+  mode: prod
+  tree:
+    name: root
+    children:
+      - name: leaf
+`))
+	assert.Empty(t, errs)
+}
+
+func TestValidateErrors(t *testing.T) {
+	s := compileService(t)
+
+	errs := s.Validate(parseYAML(t, `
+appID: short
+logger:
+  logLevel: TRACE
+service:
+  port: 0
+  ratio: 0.3
+  hosts: [a.local, a.local, B_HOST]
+  labels: {a: x, b: y, c: 1}
+  tls: {cert: c.pem}
+  mode: staging
+  unknown: true
+  tree:
+    children:
+      - name: 1
+`))
+
+	assert.Equal(t, []Error{
+		{InstanceLocation: "/appID", Keyword: "minLength", Message: "length must be >= 8, got 5"},
+		{InstanceLocation: "/logger/logLevel", Keyword: "enum", Message: `must be one of ["DEBUG","INFO","WARN","ERROR"]`},
+		{InstanceLocation: "/service/hosts", Keyword: "uniqueItems", Message: "items 0 and 1 must be unique"},
+		{InstanceLocation: "/service/hosts/2", Keyword: "pattern", Message: `must match pattern "^[a-z0-9.-]+$"`},
+		{InstanceLocation: "/service/labels", Keyword: "maxProperties", Message: "must have at most 2 properties, got 3"},
+		{InstanceLocation: "/service/labels/c", Keyword: "type", Message: "must be string, got integer"},
+		{InstanceLocation: "/service/mode", Keyword: "oneOf", Message: "must match exactly one schema in oneOf, matched 0"},
+		{InstanceLocation: "/service/port", Keyword: "minimum", Message: "must be >= 1"},
+		{InstanceLocation: "/service/ratio", Keyword: "multipleOf", Message: "must be a multiple of 0.25"},
+		{InstanceLocation: "/service/tls", Keyword: "dependentRequired", Message: `property "key" is required when "cert" is present`},
+		{InstanceLocation: "/service/tree/children/0/name", Keyword: "type", Message: "must be string, got integer"},
+		{InstanceLocation: "/service", Keyword: "additionalProperties", Message: `property "unknown" is not allowed`},
+	}, errs)
+
+	errs = s.Validate(parseYAML(t, `service: {}`))
+	assert.Equal(t, []Error{
+		{InstanceLocation: "", Keyword: "required", Message: `missing required property "appID"`},
+		{InstanceLocation: "/service", Keyword: "required", Message: `missing required property "port"`},
+	}, errs)
+	assert.Equal(t, `/: missing required property "appID"`, errs[0].Error())
+}
+
+func TestValidateConvertsStrings(t *testing.T) {
+	s := compileService(t)
+
+	// Values from environment variables are strings.
+	settings := map[string]any{
+		"appid": "my-app-id",
+		"service": map[string]any{
+			"port":   "8080",
+			"debug":  "false",
+			"hosts":  "a.local b.local",
+			"labels": "team=core, tier=gold",
+		},
+	}
+	assert.Empty(t, s.Validate(settings))
+
+	settings["service"].(map[string]any)["port"] = "70000"
+	settings["service"].(map[string]any)["debug"] = "maybe"
+	settings["service"].(map[string]any)["hosts"] = `["a.local", "a.local"]`
+	settings["service"].(map[string]any)["labels"] = "team"
+	assert.Equal(t, []Error{
+		{InstanceLocation: "/service/debug", Keyword: "type", Message: "must be boolean, got string"},
+		{InstanceLocation: "/service/hosts", Keyword: "uniqueItems", Message: "items 0 and 1 must be unique"},
+		{InstanceLocation: "/service/labels", Keyword: "type", Message: "must be object, got string"},
+		{InstanceLocation: "/service/port", Keyword: "maximum", Message: "must be <= 65535"},
+	}, s.Validate(settings))
+}
+
+func TestValidateApplicators(t *testing.T) {
+	s, err := Compile([]byte(`{
+  "type": "object",
+  "properties": {
+    "port": {"type": ["integer", "null"], "not": {"const": 22}},
+    "items": {"prefixItems": [{"type": "string"}], "items": false, "contains": {"type": "string"}},
+    "legacy": {"items": [{"type": "integer"}], "additionalItems": {"type": "string"}}
+  },
+  "patternProperties": {"^x-": {"type": "string"}},
+  "propertyNames": {"maxLength": 6},
+  "if": {"required": ["tls"]},
+  "then": {"properties": {"port": {"const": 443}}},
+  "else": {"properties": {"port": {"anyOf": [{"const": 80}, {"type": "null"}]}}}
+}`))
+	require.NoError(t, err)
+
+	assert.Empty(t, s.Validate(map[string]any{"port": nil, "x-team": "core"}))
+	assert.Empty(t, s.Validate(map[string]any{"port": 443, "tls": true}))
+	assert.Empty(t, s.Validate(map[string]any{"legacy": []any{1, "a", "b"}}))
+
+	assert.Equal(t, []Error{
+		{InstanceLocation: "/port", Keyword: "not", Message: "must not match the schema in not"},
+		{InstanceLocation: "/port", Keyword: "anyOf", Message: "must match at least one schema in anyOf"},
+	}, s.Validate(map[string]any{"port": 22}))
+
+	assert.Equal(t, []Error{
+		{InstanceLocation: "/items/1", Keyword: "false", Message: "no value is allowed"},
+		{InstanceLocation: "/legacy/1", Keyword: "type", Message: "must be string, got integer"},
+		{InstanceLocation: "/x-teams", Keyword: "type", Message: "must be string, got integer"},
+		{InstanceLocation: "", Keyword: "propertyNames", Message: `property name "x-teams" is not allowed`},
+		{InstanceLocation: "/port", Keyword: "const", Message: "must be 443"},
+	}, s.Validate(map[string]any{
+		"items":   []any{"a", "b"},
+		"legacy":  []any{1, 2},
+		"port":    80,
+		"tls":     true,
+		"x-teams": 1,
+	}))
+
+	assert.Equal(t, []Error{
+		{InstanceLocation: "/items", Keyword: "contains", Message: "must contain at least 1 matching items, got 0"},
+	}, s.Validate(map[string]any{"items": []any{}}))
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := map[string]struct {
+		schema string
+		err    string
+	}{
+		"invalid JSON":    {`{`, "parsing schema"},
+		"not a schema":    {`{"properties": {"a": 1}}`, `schema at "#/properties/a": must be an object or boolean`},
+		"remote ref":      {`{"$ref": "https://example.com/other.json"}`, "only references within the schema are supported"},
+		"anchor ref":      {`{"$ref": "#node"}`, "only references within the schema are supported"},
+		"missing ref":     {`{"$ref": "#/$defs/missing"}`, `"#/$defs/missing" not found in schema`},
+		"invalid pattern": {`{"pattern": "("}`, "invalid pattern"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Compile([]byte(tt.schema))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -188,13 +188,17 @@ type Viper struct {
 	experimentalBindStruct bool
 }
 
+// DefaultKeyDelimiter is the delimiter between key parts unless changed
+// with KeyDelimiter.
+const DefaultKeyDelimiter = "."
+
 // New returns an initialized Viper instance.
 func New() *Viper {
 
 	codecRegistry := NewCodecRegistry()
 
 	return &Viper{
-		keyDelim:               ".",
+		keyDelim:               DefaultKeyDelimiter,
 		configName:             "config",
 		configPermissions:      os.FileMode(0o644),
 		fs:                     afero.NewOsFs(),
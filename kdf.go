@@ -0,0 +1,348 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifies the key derivation function used to turn the key passed
+// to SetEncryptionKey into an AES-256 key.
+type KDF string
+
+const (
+	// KDFSHA256 hashes the key with a single SHA-256. It is the legacy
+	// behaviour and produces the original ENC[base64data] format.
+	KDFSHA256 KDF = "sha256"
+	// KDFHKDF derives the key with HKDF-SHA256 and a random salt. Use it
+	// for high-entropy keys such as randomly generated 32-byte secrets.
+	KDFHKDF KDF = "hkdf"
+	// KDFArgon2id derives the key with Argon2id and a random salt. Use it
+	// for human-chosen passphrases.
+	KDFArgon2id KDF = "argon2id"
+	// KDFScrypt derives the key with scrypt and a random salt. Use it for
+	// human-chosen passphrases when Argon2id is not an option.
+	KDFScrypt KDF = "scrypt"
+)
+
+const (
+	kdfSaltSize   = 16
+	kdfKeySize    = 32
+	kdfHKDFInfo   = "inovacc/config ENC v1"
+	kdfSeparator  = "$"
+	kdfFieldCount = 4
+)
+
+// Default cost parameters, following the recommendations of RFC 9106
+// (Argon2id) and the scrypt paper for interactive use.
+const (
+	defaultArgon2Time    = 1
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+	defaultScryptN       = 1 << 15
+	defaultScryptR       = 8
+	defaultScryptP       = 1
+)
+
+// Upper bounds on cost parameters. Envelopes are read from config files,
+// so their parameters are checked before a key is derived: an edited file
+// must not make the process allocate gigabytes or spin for minutes.
+const (
+	maxArgon2Time    = 64
+	maxArgon2Memory  = 1 << 20 // 1 GiB
+	maxArgon2Threads = 64
+	maxScryptN       = 1 << 20
+	maxScryptR       = 32
+	maxScryptP       = 16
+)
+
+// maxDerivedKeys bounds the number of keys a kdfCache holds.
+const maxDerivedKeys = 64
+
+// KeyDerivation selects a KDF and its cost parameters. Zero-valued
+// parameters are replaced with sensible defaults.
+type KeyDerivation struct {
+	Algorithm KDF
+
+	// Argon2id parameters.
+	Time    uint32 // number of passes over memory
+	Memory  uint32 // memory in KiB
+	Threads uint8  // degree of parallelism
+
+	// scrypt parameters.
+	N int // CPU/memory cost, must be a power of two
+	R int // block size
+	P int // parallelization
+}
+
+// SetKeyDerivation selects the KDF used for values encrypted after the
+// call. Values already in a config file remain readable regardless of the
+// selected KDF because every envelope records how its key was derived.
+//
+// Must be called before EncryptValue. The default is KDFSHA256.
+//
+// Example:
+//
+//	err := config.SetKeyDerivation(config.KeyDerivation{Algorithm: config.KDFArgon2id})
+func SetKeyDerivation(kd KeyDerivation) error {
+	kd, err := kd.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, kdfSaltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	globalConfig.keyDerivation = kd
+	globalConfig.kdfSalt = salt
+
+	return nil
+}
+
+// withDefaults validates kd and fills in zero-valued parameters.
+func (kd KeyDerivation) withDefaults() (KeyDerivation, error) {
+	switch kd.Algorithm {
+	case "", KDFSHA256:
+		return KeyDerivation{Algorithm: KDFSHA256}, nil
+	case KDFHKDF:
+		return KeyDerivation{Algorithm: KDFHKDF}, nil
+	case KDFArgon2id:
+		if kd.Time == 0 {
+			kd.Time = defaultArgon2Time
+		}
+		if kd.Memory == 0 {
+			kd.Memory = defaultArgon2Memory
+		}
+		if kd.Threads == 0 {
+			kd.Threads = defaultArgon2Threads
+		}
+		kd = KeyDerivation{Algorithm: KDFArgon2id, Time: kd.Time, Memory: kd.Memory, Threads: kd.Threads}
+		return kd, kd.validate()
+	case KDFScrypt:
+		if kd.N == 0 {
+			kd.N = defaultScryptN
+		}
+		if kd.R == 0 {
+			kd.R = defaultScryptR
+		}
+		if kd.P == 0 {
+			kd.P = defaultScryptP
+		}
+		kd = KeyDerivation{Algorithm: KDFScrypt, N: kd.N, R: kd.R, P: kd.P}
+		return kd, kd.validate()
+	default:
+		return KeyDerivation{}, fmt.Errorf("unknown key derivation function: %q (valid values: sha256, hkdf, argon2id, scrypt)", kd.Algorithm)
+	}
+}
+
+// validate checks that the cost parameters of kd are within bounds.
+func (kd KeyDerivation) validate() error {
+	switch kd.Algorithm {
+	case KDFArgon2id:
+		if kd.Time == 0 || kd.Time > maxArgon2Time {
+			return fmt.Errorf("invalid argon2id time: must be between 1 and %d, got %d", maxArgon2Time, kd.Time)
+		}
+		if kd.Memory == 0 || kd.Memory > maxArgon2Memory {
+			return fmt.Errorf("invalid argon2id memory: must be between 1 and %d KiB, got %d", maxArgon2Memory, kd.Memory)
+		}
+		if kd.Threads == 0 || kd.Threads > maxArgon2Threads {
+			return fmt.Errorf("invalid argon2id threads: must be between 1 and %d, got %d", maxArgon2Threads, kd.Threads)
+		}
+	case KDFScrypt:
+		if kd.N <= 1 || kd.N > maxScryptN || kd.N&(kd.N-1) != 0 {
+			return fmt.Errorf("invalid scrypt N: must be a power of two greater than 1 and at most %d, got %d", maxScryptN, kd.N)
+		}
+		if kd.R <= 0 || kd.R > maxScryptR {
+			return fmt.Errorf("invalid scrypt r: must be between 1 and %d, got %d", maxScryptR, kd.R)
+		}
+		if kd.P <= 0 || kd.P > maxScryptP {
+			return fmt.Errorf("invalid scrypt p: must be between 1 and %d, got %d", maxScryptP, kd.P)
+		}
+	}
+
+	return nil
+}
+
+// params encodes the cost parameters as they appear in the envelope.
+func (kd KeyDerivation) params() string {
+	switch kd.Algorithm {
+	case KDFHKDF:
+		return "h=sha256"
+	case KDFArgon2id:
+		return fmt.Sprintf("m=%d,t=%d,p=%d", kd.Memory, kd.Time, kd.Threads)
+	case KDFScrypt:
+		return fmt.Sprintf("n=%d,r=%d,p=%d", kd.N, kd.R, kd.P)
+	default:
+		return ""
+	}
+}
+
+// derive turns secret into an AES-256 key using salt.
+func (kd KeyDerivation) derive(secret, salt []byte) ([]byte, error) {
+	switch kd.Algorithm {
+	case KDFSHA256:
+		h := sha256.Sum256(secret)
+		return h[:], nil
+	case KDFHKDF:
+		key := make([]byte, kdfKeySize)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(kdfHKDFInfo)), key); err != nil {
+			return nil, fmt.Errorf("deriving hkdf key: %w", err)
+		}
+		return key, nil
+	case KDFArgon2id:
+		return argon2.IDKey(secret, salt, kd.Time, kd.Memory, kd.Threads, kdfKeySize), nil
+	case KDFScrypt:
+		key, err := scrypt.Key(secret, salt, kd.N, kd.R, kd.P, kdfKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("deriving scrypt key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unknown key derivation function: %q", kd.Algorithm)
+	}
+}
+
+// header returns the self-describing prefix stored inside ENC[...] for
+// values encrypted with kd and salt. Legacy SHA-256 values have no header.
+func (kd KeyDerivation) header(salt []byte) string {
+	if kd.Algorithm == KDFSHA256 {
+		return ""
+	}
+
+	return string(kd.Algorithm) + kdfSeparator + kd.params() + kdfSeparator +
+		base64.RawStdEncoding.EncodeToString(salt) + kdfSeparator
+}
+
+// kdfCache holds keys derived from a single secret, so that expensive
+// KDFs run once per (algorithm, parameters, salt). Entries are keyed by
+// the envelope header, never by the secret: a new cache is created
+// whenever the secret changes. A nil cache derives every key.
+type kdfCache struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func newKDFCache() *kdfCache {
+	return &kdfCache{keys: make(map[string][]byte)}
+}
+
+// derive derives a key from secret for kd and salt, reusing a previous
+// result when available. The cache is emptied when it is full.
+func (kc *kdfCache) derive(kd KeyDerivation, secret, salt []byte) ([]byte, error) {
+	if kc == nil || kd.Algorithm == KDFSHA256 {
+		return kd.derive(secret, salt)
+	}
+
+	header := kd.header(salt)
+
+	kc.mu.Lock()
+	key, ok := kc.keys[header]
+	kc.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	key, err := kd.derive(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	kc.mu.Lock()
+	if len(kc.keys) >= maxDerivedKeys {
+		clear(kc.keys)
+	}
+	kc.keys[header] = key
+	kc.mu.Unlock()
+
+	return key, nil
+}
+
+// parseEnvelope splits the content of an ENC[...] value into the key
+// derivation it records, its salt and the base64 ciphertext. Values without
+// a header are legacy SHA-256 envelopes.
+func parseEnvelope(encoded string) (KeyDerivation, []byte, string, error) {
+	if !strings.Contains(encoded, kdfSeparator) {
+		return KeyDerivation{Algorithm: KDFSHA256}, nil, encoded, nil
+	}
+
+	parts := strings.Split(encoded, kdfSeparator)
+	if len(parts) != kdfFieldCount {
+		return KeyDerivation{}, nil, "", fmt.Errorf("malformed envelope: expected %d fields, got %d", kdfFieldCount, len(parts))
+	}
+
+	kd := KeyDerivation{Algorithm: KDF(parts[0])}
+
+	params, err := parseKDFParams(parts[1])
+	if err != nil {
+		return KeyDerivation{}, nil, "", err
+	}
+
+	switch kd.Algorithm {
+	case KDFHKDF:
+		if params["h"] != "sha256" {
+			return KeyDerivation{}, nil, "", fmt.Errorf("unsupported hkdf hash: %q", params["h"])
+		}
+	case KDFArgon2id:
+		m, errM := strconv.ParseUint(params["m"], 10, 32)
+		t, errT := strconv.ParseUint(params["t"], 10, 32)
+		p, errP := strconv.ParseUint(params["p"], 10, 8)
+		if errM != nil || errT != nil || errP != nil {
+			return KeyDerivation{}, nil, "", fmt.Errorf("invalid argon2id parameters: %q", parts[1])
+		}
+		kd.Memory, kd.Time, kd.Threads = uint32(m), uint32(t), uint8(p)
+	case KDFScrypt:
+		n, errN := strconv.Atoi(params["n"])
+		r, errR := strconv.Atoi(params["r"])
+		p, errP := strconv.Atoi(params["p"])
+		if errN != nil || errR != nil || errP != nil {
+			return KeyDerivation{}, nil, "", fmt.Errorf("invalid scrypt parameters: %q", parts[1])
+		}
+		kd.N, kd.R, kd.P = n, r, p
+	default:
+		return KeyDerivation{}, nil, "", fmt.Errorf("unknown key derivation function: %q", kd.Algorithm)
+	}
+
+	if err = kd.validate(); err != nil {
+		return KeyDerivation{}, nil, "", err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return KeyDerivation{}, nil, "", fmt.Errorf("decoding salt: %w", err)
+	}
+
+	return kd, salt, parts[3], nil
+}
+
+// parseKDFParams parses a comma separated list of key=value pairs.
+func parseKDFParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	if s == "" {
+		return params, nil
+	}
+
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed kdf parameter: %q", kv)
+		}
+		params[k] = v
+	}
+
+	return params, nil
+}
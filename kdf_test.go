@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// legacyEncrypted was produced by EncryptValue("legacy-secret") with
+// SetEncryptionKey([]byte("legacy-key")) before KDF selection existed.
+const legacyEncrypted = "ENC[aoydxg+r5QMRaMi5WkxsmooziVEtyRgA7zHX6hgKMHKtNQFofolSDGI=]"
+
+func TestKeyDerivationRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		kd     KeyDerivation
+		prefix string
+	}{
+		{"sha256", KeyDerivation{Algorithm: KDFSHA256}, "ENC["},
+		{"hkdf", KeyDerivation{Algorithm: KDFHKDF}, "ENC[hkdf$h=sha256$"},
+		{"argon2id", KeyDerivation{Algorithm: KDFArgon2id, Memory: 1024}, "ENC[argon2id$m=1024,t=1,p=4$"},
+		{"scrypt", KeyDerivation{Algorithm: KDFScrypt, N: 1024}, "ENC[scrypt$n=1024,r=8,p=1$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGlobalConfig(t)
+
+			SetEncryptionKey([]byte("correct horse battery staple"))
+			require.NoError(t, SetKeyDerivation(tt.kd))
+
+			encrypted, err := EncryptValue("kdf-secret")
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(encrypted, tt.prefix), encrypted)
+			assert.True(t, IsEncryptedValue(encrypted))
+
+			decrypted, err := DecryptValue(encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, "kdf-secret", decrypted)
+		})
+	}
+}
+
+func TestKeyDerivationDefaults(t *testing.T) {
+	kd, err := KeyDerivation{Algorithm: KDFArgon2id}.withDefaults()
+	require.NoError(t, err)
+	assert.Equal(t, "m=65536,t=1,p=4", kd.params())
+
+	kd, err = KeyDerivation{Algorithm: KDFScrypt}.withDefaults()
+	require.NoError(t, err)
+	assert.Equal(t, "n=32768,r=8,p=1", kd.params())
+
+	kd, err = KeyDerivation{}.withDefaults()
+	require.NoError(t, err)
+	assert.Equal(t, KDFSHA256, kd.Algorithm)
+}
+
+func TestSetKeyDerivationInvalid(t *testing.T) {
+	resetGlobalConfig(t)
+
+	err := SetKeyDerivation(KeyDerivation{Algorithm: "md5"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown key derivation function")
+	}
+
+	err = SetKeyDerivation(KeyDerivation{Algorithm: KDFScrypt, N: 1000})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "power of two")
+	}
+
+	err = SetKeyDerivation(KeyDerivation{Algorithm: KDFArgon2id, Memory: 1 << 30})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid argon2id memory")
+	}
+}
+
+func TestKDFCache(t *testing.T) {
+	kd := KeyDerivation{Algorithm: KDFHKDF}
+	kc := newKDFCache()
+
+	first, err := kc.derive(kd, []byte("key"), []byte("salt-1"))
+	require.NoError(t, err)
+	again, err := kc.derive(kd, []byte("key"), []byte("salt-1"))
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	// Entries are keyed by the envelope header, which holds no key material.
+	for header := range kc.keys {
+		assert.Equal(t, kd.header([]byte("salt-1")), header)
+	}
+
+	for i := range maxDerivedKeys * 2 {
+		_, err = kc.derive(kd, []byte("key"), []byte(fmt.Sprintf("salt-%d", i)))
+		require.NoError(t, err)
+	}
+	assert.LessOrEqual(t, len(kc.keys), maxDerivedKeys)
+
+	// A nil cache derives every key.
+	key, err := (*kdfCache)(nil).derive(kd, []byte("key"), []byte("salt-1"))
+	require.NoError(t, err)
+	assert.Equal(t, first, key)
+}
+
+func TestLegacyValueReadableWithNewKDF(t *testing.T) {
+	resetGlobalConfig(t)
+
+	SetEncryptionKey([]byte("legacy-key"))
+
+	plain, err := DecryptValue(legacyEncrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-secret", plain)
+
+	// Switching the KDF only affects newly encrypted values.
+	require.NoError(t, SetKeyDerivation(KeyDerivation{Algorithm: KDFScrypt, N: 1024}))
+
+	plain, err = DecryptValue(legacyEncrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-secret", plain)
+}
+
+func TestMixedKDFConfigFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("legacy-key"))
+	require.NoError(t, SetKeyDerivation(KeyDerivation{Algorithm: KDFArgon2id, Memory: 1024}))
+
+	encPassword, err := EncryptValue("argon-password")
+	require.NoError(t, err)
+
+	configContent := `
+appID: validappid12345
+appSecret: ` + legacyEncrypted + `
+logger:
+  logLevel: DEBUG
+service:
+  username: plainuser
+  password: ` + encPassword + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&customService{}, configPath)
+	require.NoError(t, err)
+
+	cfg := GetBaseConfig()
+	assert.Equal(t, "legacy-secret", cfg.AppSecret)
+
+	svc, err := GetServiceConfig[*customService]()
+	require.NoError(t, err)
+	assert.Equal(t, "argon-password", svc.Password)
+}
+
+func TestDecryptKDFWrongKey(t *testing.T) {
+	resetGlobalConfig(t)
+
+	SetEncryptionKey([]byte("key-one"))
+	require.NoError(t, SetKeyDerivation(KeyDerivation{Algorithm: KDFHKDF}))
+
+	encrypted, err := EncryptValue("secret")
+	require.NoError(t, err)
+
+	SetEncryptionKey([]byte("key-two"))
+	_, err = DecryptValue(encrypted)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "decrypting value")
+	}
+}
+
+func TestParseEnvelopeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{"too few fields", "ENC[argon2id$m=1,t=1,p=1$data]", "malformed envelope"},
+		{"unknown kdf", "ENC[pbkdf2$i=1000$c2FsdA$ZGF0YQ==]", "unknown key derivation function"},
+		{"bad argon2 params", "ENC[argon2id$m=x,t=1,p=1$c2FsdA$ZGF0YQ==]", "invalid argon2id parameters"},
+		{"bad scrypt params", "ENC[scrypt$n=x,r=8,p=1$c2FsdA$ZGF0YQ==]", "invalid scrypt parameters"},
+		{"scrypt n too small", "ENC[scrypt$n=1,r=8,p=1$c2FsdA$ZGF0YQ==]", "invalid scrypt N"},
+		{"scrypt n not a power of two", "ENC[scrypt$n=1000,r=8,p=1$c2FsdA$ZGF0YQ==]", "invalid scrypt N"},
+		{"scrypt n too large", "ENC[scrypt$n=2097152,r=8,p=1$c2FsdA$ZGF0YQ==]", "invalid scrypt N"},
+		{"scrypt r too large", "ENC[scrypt$n=1024,r=1024,p=1$c2FsdA$ZGF0YQ==]", "invalid scrypt r"},
+		{"scrypt p too large", "ENC[scrypt$n=1024,r=8,p=1000000$c2FsdA$ZGF0YQ==]", "invalid scrypt p"},
+		{"argon2 zero time", "ENC[argon2id$m=1024,t=0,p=1$c2FsdA$ZGF0YQ==]", "invalid argon2id time"},
+		{"argon2 time too large", "ENC[argon2id$m=1024,t=100000,p=1$c2FsdA$ZGF0YQ==]", "invalid argon2id time"},
+		{"argon2 memory too large", "ENC[argon2id$m=4294967295,t=1,p=1$c2FsdA$ZGF0YQ==]", "invalid argon2id memory"},
+		{"argon2 threads too large", "ENC[argon2id$m=1024,t=1,p=255$c2FsdA$ZGF0YQ==]", "invalid argon2id threads"},
+		{"bad hkdf hash", "ENC[hkdf$h=md5$c2FsdA$ZGF0YQ==]", "unsupported hkdf hash"},
+		{"bad salt", "ENC[hkdf$h=sha256$!!$ZGF0YQ==]", "decoding salt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decryptIfEncrypted([]byte("key"), tt.value)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,21 +2,74 @@ package config
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
 	"sort"
+	"strings"
 
+	"github.com/inovacc/config/internal/document"
+	"github.com/spf13/afero"
 	"gopkg.in/yaml.v3"
 )
 
+// ErrVersionTooNew is returned by InitServiceConfig when the config file's
+// version is newer than the target version, typically because a deployment
+// was rolled back, and the file cannot or may not be downgraded.
+var ErrVersionTooNew = errors.New("config file version is newer than the target version")
+
 // MigrationFunc transforms the configuration from one version to the next.
 // It receives a mutable map of the raw config data and should modify it
-// in place (e.g., rename keys, change structure).
+// in place (e.g., rename keys, change structure). Keys are lowercased, as
+// Viper stores them, whether or not migrations are persisted: look up
+// data["logger"]["loglevel"], not "logLevel". When the result is persisted,
+// existing keys keep their case in the file.
 type MigrationFunc func(data map[string]any) error
 
 type migration struct {
 	from int
 	to   int
 	fn   MigrationFunc
+	down MigrationFunc
+}
+
+// migrationStep is a migration applied upwards (from → to) or, for a
+// rollback, downwards (to → from).
+type migrationStep struct {
+	migration
+	reverse bool
+}
+
+// source and target return the versions the step migrates between.
+func (s migrationStep) source() int {
+	if s.reverse {
+		return s.to
+	}
+	return s.from
+}
+
+func (s migrationStep) target() int {
+	if s.reverse {
+		return s.from
+	}
+	return s.to
+}
+
+func (s migrationStep) apply(data map[string]any) error {
+	fn := s.fn
+	if s.reverse {
+		fn = s.down
+	}
+
+	return fn(data)
+}
+
+func (s migrationStep) String() string {
+	return fmt.Sprintf("v%d→v%d", s.source(), s.target())
 }
 
 // AddMigration registers a migration function that transforms config data
@@ -29,9 +82,9 @@ type migration struct {
 //	config.AddMigration(1, 2, func(data map[string]any) error {
 //	    // Rename "logLevel" to "log_level" in logger section
 //	    if logger, ok := data["logger"].(map[string]any); ok {
-//	        if level, exists := logger["logLevel"]; exists {
+//	        if level, exists := logger["loglevel"]; exists {
 //	            logger["log_level"] = level
-//	            delete(logger, "logLevel")
+//	            delete(logger, "loglevel")
 //	        }
 //	    }
 //	    data["version"] = 2
@@ -41,16 +94,162 @@ func AddMigration(from, to int, fn MigrationFunc) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	globalConfig.migrations = append(globalConfig.migrations, migration{
+	globalConfig.addMigration(migration{
 		from: from,
 		to:   to,
 		fn:   fn,
 	})
 }
 
+// AddReversibleMigration registers a migration like AddMigration, together
+// with a down function that transforms config data from version `to` back
+// to version `from`. Down functions are used when the config file is newer
+// than the target version and downgrades are allowed with AllowDowngrade.
+//
+// Example:
+//
+//	config.AddReversibleMigration(1, 2,
+//	    func(data map[string]any) error {
+//	        data["timeout"] = fmt.Sprintf("%ds", data["timeoutseconds"])
+//	        delete(data, "timeoutseconds")
+//	        return nil
+//	    },
+//	    func(data map[string]any) error {
+//	        d, err := time.ParseDuration(fmt.Sprint(data["timeout"]))
+//	        if err != nil {
+//	            return err
+//	        }
+//	        data["timeoutseconds"] = int(d.Seconds())
+//	        delete(data, "timeout")
+//	        return nil
+//	    })
+func AddReversibleMigration(from, to int, up, down MigrationFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	globalConfig.addMigration(migration{
+		from: from,
+		to:   to,
+		fn:   up,
+		down: down,
+	})
+}
+
+// addMigration registers m, or records why it is invalid: a version range
+// that does not go up, a missing function, or a range that duplicates or
+// overlaps one registered before. Recorded errors are returned by
+// ValidateMigrations and InitServiceConfig.
+func (c *Config) addMigration(m migration) {
+	if err := validateMigration(c.migrations, m); err != nil {
+		c.migrationErrs = append(c.migrationErrs, err)
+		return
+	}
+
+	c.migrations = append(c.migrations, m)
+}
+
+func validateMigration(registered []migration, m migration) error {
+	if m.from >= m.to {
+		return fmt.Errorf("migration v%d→v%d: target version must be greater than source version", m.from, m.to)
+	}
+
+	if m.fn == nil {
+		return fmt.Errorf("migration v%d→v%d: nil migration function", m.from, m.to)
+	}
+
+	for _, r := range registered {
+		switch {
+		case r.from == m.from && r.to == m.to:
+			return fmt.Errorf("migration v%d→v%d registered twice", m.from, m.to)
+		case m.from < r.to && r.from < m.to:
+			return fmt.Errorf("migration v%d→v%d overlaps migration v%d→v%d", m.from, m.to, r.from, r.to)
+		}
+	}
+
+	return nil
+}
+
+// ValidateMigrations checks the registered migrations: it reports
+// registrations that were rejected (duplicates, overlapping ranges,
+// ranges that do not go up) and gaps in the chain, such as 1→2 and 3→4
+// with no 2→3, and whether the chain reaches the target version.
+// InitServiceConfig performs the same checks before running migrations.
+//
+// Example:
+//
+//	func TestMigrations(t *testing.T) {
+//	    registerMigrations()
+//	    if err := config.ValidateMigrations(); err != nil {
+//	        t.Fatal(err)
+//	    }
+//	}
+func ValidateMigrations() error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return globalConfig.validateMigrations()
+}
+
+func (c *Config) validateMigrations() error {
+	errs := slices.Clone(c.migrationErrs)
+
+	sorted := c.sortedMigrations()
+	for i := 1; i < len(sorted); i++ {
+		if prev, next := sorted[i-1], sorted[i]; prev.to != next.from {
+			errs = append(errs, fmt.Errorf("gap in migrations: no migration from version %d to version %d", prev.to, next.from))
+		}
+	}
+
+	if n := len(sorted); n > 0 && c.targetVersion > sorted[n-1].to {
+		errs = append(errs, fmt.Errorf("gap in migrations: no migration from version %d to target version %d", sorted[n-1].to, c.targetVersion))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid migrations: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// sortedMigrations returns the registered migrations ordered by
+// from-version.
+func (c *Config) sortedMigrations() []migration {
+	sorted := slices.Clone(c.migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].from < sorted[j].from
+	})
+
+	return sorted
+}
+
+// AllowDowngrade controls what happens when the config file's version is
+// newer than the target version. By default InitServiceConfig fails with
+// ErrVersionTooNew. With downgrades allowed, the down functions registered
+// with AddReversibleMigration are applied from the file's version down to
+// the target; if any step has no down function, ErrVersionTooNew is still
+// returned.
+//
+// Example:
+//
+//	config.SetTargetVersion(2)
+//	config.AllowDowngrade(true)
+func AllowDowngrade(allow bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	globalConfig.allowDowngrade = allow
+}
+
 // SetTargetVersion sets the expected config version. During InitServiceConfig,
 // if the config file's version is lower than the target, registered migrations
-// are applied in order. If no target is set, migrations are skipped.
+// are applied in order. If it is higher, InitServiceConfig fails unless
+// downgrades are allowed with AllowDowngrade. If no target is set,
+// migrations are skipped.
+//
+// A file without a version, or with version 0, is migrated from the lowest
+// version a migration is registered from; with no migrations registered
+// it is taken to be at the target. Default config files are created at
+// the target version.
 //
 // Example:
 //
@@ -62,6 +261,37 @@ func SetTargetVersion(version int) {
 	globalConfig.targetVersion = version
 }
 
+// WithPersistMigrations writes the config file back to disk after
+// migrations ran, so that they do not run again on every start. Files are
+// written only once the migrated configuration passed validation, the
+// schema given with WithSchema and the validators added by AddValidator;
+// a rejected configuration leaves them untouched. The
+// original file is kept as a backup named after its version, e.g.
+// "config.yaml.v1.bak" (or "config.yaml.v1.1.bak" and so on if that
+// backup already exists), and the file keeps its YAML or JSON format. The file content
+// is written as found, after migration: encrypted values stay encrypted
+// and environment overrides are not written. Migrations then receive the
+// file's data rather than the loaded settings, still with lowercased keys.
+//
+// Example:
+//
+//	config.SetTargetVersion(3)
+//	err := config.InitServiceConfig(svc, "config.yaml", config.WithPersistMigrations())
+func WithPersistMigrations() Option {
+	return func(c *Config) {
+		c.persistMigrations = true
+	}
+}
+
+// AppliedMigrations returns the migrations run by the last
+// InitServiceConfig, in order, e.g. ["v1→v2", "v2→v3"].
+func AppliedMigrations() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return slices.Clone(globalConfig.appliedMigrations)
+}
+
 // GetConfigVersion returns the current version from the loaded configuration.
 func GetConfigVersion() int {
 	mu.RLock()
@@ -71,69 +301,563 @@ func GetConfigVersion() int {
 }
 
 // runMigrations applies registered migrations to bring the config from its
-// current version up to the target version. Returns true if any migrations
-// were applied (meaning the Viper instance needs to be re-read).
-func (c *Config) runMigrations() (bool, error) {
-	if c.targetVersion == 0 || c.Version >= c.targetVersion {
-		return false, nil
+// current version up, or down, to the target version, and records them
+// for AppliedMigrations. Returns true if any migrations were applied.
+func (c *Config) runMigrations(afs afero.Fs) (bool, error) {
+	c.appliedMigrations = nil
+	c.migratedFiles = nil
+
+	steps, err := c.migrateBase(afs, c.persistMigrations)
+	if err != nil {
+		return false, err
 	}
 
-	if len(c.migrations) == 0 {
-		return false, nil
+	for _, step := range steps {
+		c.appliedMigrations = append(c.appliedMigrations, step.String())
 	}
 
-	// Sort migrations by from-version
-	sorted := make([]migration, len(c.migrations))
-	copy(sorted, c.migrations)
-	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].from < sorted[j].from
-	})
+	return len(steps) > 0, nil
+}
+
+// migrateBase applies registered migrations to the loaded base file and
+// returns the steps it applied. Migrations operate on the loaded settings,
+// with lowercased keys, as they always have; when the result is persisted
+// they operate on the data of the config file itself instead, lowercased
+// the same way and without environment overrides, so that only the file's
+// own content is written back.
+func (c *Config) migrateBase(afs afero.Fs, persist bool) ([]migrationStep, error) {
+	if err := c.validateMigrations(); err != nil {
+		return nil, err
+	}
+
+	c.Version = c.fileVersion(c.Version)
+	c.baseVersion = c.Version
+
+	if c.targetVersion == 0 || c.Version == c.targetVersion {
+		return nil, nil
+	}
+
+	steps, err := c.planMigrationSteps(c.Version, c.targetVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	var (
+		original []byte
+		raw      map[string]any
+	)
 
-	// Get raw config data from Viper
 	data := c.viper.AllSettings()
+	if persist {
+		if original, err = afero.ReadFile(afs, c.ConfigFile); err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+
+		if raw, err = parseConfigData(original); err != nil {
+			return nil, err
+		}
+		data = lowerKeys(raw)
+	}
+
+	if err = applyMigrationSteps(data, steps); err != nil {
+		return nil, err
+	}
+
+	if persist {
+		c.migratedFiles = append(c.migratedFiles, migratedFile{
+			path:     c.ConfigFile,
+			original: original,
+			version:  c.Version,
+			data:     fileKeyCase(data, raw),
+		})
+	}
+
+	// Re-read migrated data into Viper at the config level (not override)
+	// so that profile merges can still take precedence.
+	var buf bytes.Buffer
+	if err = yaml.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, fmt.Errorf("encoding migrated data: %w", err)
+	}
+
+	c.viper.SetConfigType("yaml")
+	if err = c.viper.ReadConfig(&buf); err != nil {
+		return nil, fmt.Errorf("re-reading migrated config: %w", err)
+	}
+
+	if err = c.unmarshal(); err != nil {
+		return nil, fmt.Errorf("unmarshalling after migration: %w", err)
+	}
+
+	return steps, nil
+}
+
+// migrateProfile applies the registered migrations to the content of a
+// profile overlay file, independently of the base file, so that an overlay
+// written against an older schema does not re-introduce old keys. The
+// overlay's own `version` key selects the migrations; an overlay without
+// one is assumed to be at the version the base file had on disk. It
+// returns the content to merge and its config type.
+func (c *Config) migrateProfile(profileFile string, content []byte, ext string) ([]byte, string, error) {
+	if c.targetVersion == 0 {
+		return content, ext, nil
+	}
+
+	raw, err := parseConfigData(content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	version, err := dataVersion(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, ok := raw["version"]; ok {
+		version = c.fileVersion(version)
+	} else {
+		version = c.baseVersion
+	}
+
+	if version == c.targetVersion {
+		return content, ext, nil
+	}
+
+	// Migrations see the same key case as for the base file.
+	data := lowerKeys(raw)
+
+	steps, err := c.planMigrationSteps(version, c.targetVersion)
+	if err != nil {
+		return nil, "", err
+	}
 
-	currentVersion := c.Version
-	applied := false
+	if err = applyMigrationSteps(data, steps); err != nil {
+		return nil, "", err
+	}
+
+	slog.Info("Migrated profile config", "file", profileFile, "from", version, "to", c.targetVersion)
+
+	if c.persistMigrations {
+		c.migratedFiles = append(c.migratedFiles, migratedFile{
+			path:     profileFile,
+			original: content,
+			version:  version,
+			data:     fileKeyCase(data, raw),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err = yaml.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, "", fmt.Errorf("encoding migrated data: %w", err)
+	}
+
+	return buf.Bytes(), "yaml", nil
+}
+
+// parseConfigData parses YAML or JSON config file content into a map.
+func parseConfigData(content []byte) (map[string]any, error) {
+	data := make(map[string]any)
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("parsing config data: %w", err)
+	}
+
+	return data, nil
+}
+
+// lowerKeys returns a copy of raw config data with the keys of all nested
+// maps lowercased, as Viper stores them.
+func lowerKeys(data map[string]any) map[string]any {
+	out := make(map[string]any, len(data))
+	for key, val := range data {
+		if m, ok := val.(map[string]any); ok {
+			val = lowerKeys(m)
+		}
+		out[strings.ToLower(key)] = val
+	}
+
+	return out
+}
+
+// fileKeyCase returns a copy of data, migrated from the lowercased keys of
+// raw, with the keys found in raw spelled as they are in raw, so that
+// persisting data does not rename them in the file.
+func fileKeyCase(data, raw map[string]any) map[string]any {
+	out := make(map[string]any, len(data))
+	for key, val := range data {
+		if k, ok := dataKey(raw, key); ok {
+			key = k
+		}
+		if m, ok := val.(map[string]any); ok {
+			sub, _ := raw[key].(map[string]any)
+			val = fileKeyCase(m, sub)
+		}
+		out[key] = val
+	}
+
+	return out
+}
+
+// migratedFile is a config file migrated with WithPersistMigrations,
+// waiting to be written once the migrated configuration was validated.
+type migratedFile struct {
+	path     string
+	original []byte
+	version  int
+	data     map[string]any
+}
+
+// writeMigratedFiles persists the files migrated by the last load. It must
+// be called once the migrated configuration passed validation, so that a
+// file is never left migrated when the configuration it holds is
+// rejected. The original content of every file is backed up, and the
+// files are then replaced together, keeping the comments and key order of
+// the keys that did not change.
+func (c *Config) writeMigratedFiles(afs afero.Fs) error {
+	files := c.migratedFiles
+	c.migratedFiles = nil
+
+	updates := make([]document.File, 0, len(files))
+	backups := make([]string, 0, len(files))
+
+	for _, f := range files {
+		content, err := patchContent(f.path, f.original, f.data)
+		if err != nil {
+			return fmt.Errorf("writing migrated config: %w", err)
+		}
+
+		backup, err := writeBackup(afs, f.path, f.version, f.original)
+		if err != nil {
+			return err
+		}
+
+		updates = append(updates, document.File{Path: f.path, Content: content})
+		backups = append(backups, backup)
+	}
 
-	for _, m := range sorted {
-		if m.from != currentVersion {
+	if err := document.WriteFiles(updates...); err != nil {
+		return fmt.Errorf("writing migrated config: %w", err)
+	}
+
+	for i, f := range files {
+		slog.Info("Persisted migrated configuration", "file", f.path, "backup", backups[i], "version", f.data["version"])
+	}
+
+	return nil
+}
+
+// writeBackup writes content to a new backup of cfgFile at version,
+// named "config.yaml.v1.bak" or, if that exists, "config.yaml.v1.1.bak"
+// with the first free counter, and returns its name. Backups are created
+// exclusively, so an existing backup is never overwritten.
+func writeBackup(afs afero.Fs, cfgFile string, version int, content []byte) (string, error) {
+	for i := 0; ; i++ {
+		backup := fmt.Sprintf("%s.v%d.bak", cfgFile, version)
+		if i > 0 {
+			backup = fmt.Sprintf("%s.v%d.%d.bak", cfgFile, version, i)
+		}
+
+		f, err := afs.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
 			continue
 		}
-		if m.to > c.targetVersion {
-			break
+		if err != nil {
+			return "", fmt.Errorf("backing up config file: %w", err)
 		}
 
-		if err := m.fn(data); err != nil {
-			return false, fmt.Errorf("migration v%d→v%d: %w", m.from, m.to, err)
+		_, err = f.Write(content)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("backing up config file: %w", err)
 		}
 
-		currentVersion = m.to
-		applied = true
+		return backup, nil
+	}
+}
+
+// planMigrationSteps returns the migrations leading from version from to
+// version to, or an error if the registered migrations cannot get there.
+func (c *Config) planMigrationSteps(from, to int) ([]migrationStep, error) {
+	if from > to {
+		return c.planDowngrade(from, to)
+	}
 
-		if currentVersion >= c.targetVersion {
-			break
+	var steps []migrationStep
+
+	for current := from; current < to; {
+		idx := slices.IndexFunc(c.migrations, func(m migration) bool {
+			return m.from == current
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("cannot migrate from version %d to target version %d: no migration from version %d", from, to, current)
+		}
+
+		m := c.migrations[idx]
+		if m.to > to {
+			return nil, fmt.Errorf("cannot migrate from version %d to target version %d: migration v%d→v%d goes past the target", from, to, m.from, m.to)
 		}
+
+		steps = append(steps, migrationStep{migration: m})
+		current = m.to
 	}
 
-	if !applied {
-		return false, nil
+	return steps, nil
+}
+
+// planDowngrade returns the down migrations leading from version from to
+// the lower version to.
+func (c *Config) planDowngrade(from, to int) ([]migrationStep, error) {
+	if !c.allowDowngrade {
+		return nil, fmt.Errorf("%w: file is at version %d, this build expects version %d; "+
+			"upgrade the binary, or register down migrations and call AllowDowngrade(true)", ErrVersionTooNew, from, to)
 	}
 
-	// Re-read migrated data into Viper at the config level (not override)
-	// so that profile merges can still take precedence.
-	var buf bytes.Buffer
-	if err := yaml.NewEncoder(&buf).Encode(data); err != nil {
-		return false, fmt.Errorf("encoding migrated data: %w", err)
+	var steps []migrationStep
+
+	for current := from; current > to; {
+		idx := slices.IndexFunc(c.migrations, func(m migration) bool {
+			return m.to == current && m.from >= to && m.down != nil
+		})
+		if idx < 0 {
+			return nil, fmt.Errorf("%w: no down migration from version %d towards version %d", ErrVersionTooNew, current, to)
+		}
+
+		m := c.migrations[idx]
+		steps = append(steps, migrationStep{migration: m, reverse: true})
+		current = m.from
 	}
 
-	c.viper.SetConfigType("yaml")
-	if err := c.viper.ReadConfig(&buf); err != nil {
-		return false, fmt.Errorf("re-reading migrated config: %w", err)
+	return steps, nil
+}
+
+// applyMigrationSteps runs steps on data in order and records the version
+// reached after each step in data["version"].
+func applyMigrationSteps(data map[string]any, steps []migrationStep) error {
+	for _, step := range steps {
+		if err := step.apply(data); err != nil {
+			return fmt.Errorf("migration %s: %w", step, err)
+		}
+
+		data["version"] = step.target()
 	}
 
-	if err := c.viper.Unmarshal(c); err != nil {
-		return false, fmt.Errorf("unmarshalling after migration: %w", err)
+	return nil
+}
+
+// ChangeOp describes how a key was changed by a migration.
+type ChangeOp string
+
+// Change operations reported by PlanMigrations.
+const (
+	ChangeAdded    ChangeOp = "added"
+	ChangeRemoved  ChangeOp = "removed"
+	ChangeModified ChangeOp = "modified"
+)
+
+// MigrationChange is one key changed by a migration. Lists are compared
+// as a whole.
+type MigrationChange struct {
+	Op  ChangeOp
+	Key string
+	Old any
+	New any
+}
+
+// String formats the change as a diff line, e.g. "~ version: 1 → 2".
+func (c MigrationChange) String() string {
+	switch c.Op {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s: %v", c.Key, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s: %v", c.Key, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %v → %v", c.Key, c.Old, c.New)
+	}
+}
+
+// PlannedMigration is one step of a migration plan and the changes it
+// would make to the config file.
+type PlannedMigration struct {
+	From    int
+	To      int
+	Changes []MigrationChange
+}
+
+// PlanMigrations performs a dry run of the migrations InitServiceConfig
+// would apply to the config file at configPath: it returns the ordered
+// steps from the file's version to the target version, each with the keys
+// it adds, removes or modifies. Nothing is written and the loaded
+// configuration is not changed. Migration functions are run on a copy of
+// the file data, with keys lowercased, so they must not have side
+// effects.
+//
+// Example:
+//
+//	plan, err := config.PlanMigrations("config.yaml")
+//	for _, step := range plan {
+//	    fmt.Printf("v%d→v%d\n", step.From, step.To)
+//	    for _, change := range step.Changes {
+//	        fmt.Println("  ", change)
+//	    }
+//	}
+func PlanMigrations(configPath string) ([]PlannedMigration, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c := globalConfig
+
+	if err := c.validateMigrations(); err != nil {
+		return nil, err
+	}
+
+	content, err := afero.ReadFile(afero.NewOsFs(), configPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	data, err := parseConfigData(content)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := dataVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	version = c.fileVersion(version)
+	if c.targetVersion == 0 || version == c.targetVersion {
+		return nil, nil
+	}
+
+	data = lowerKeys(data)
+
+	steps, err := c.planMigrationSteps(version, c.targetVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]PlannedMigration, 0, len(steps))
+	for _, step := range steps {
+		before := make(map[string]any)
+		flattenData("", data, before)
+
+		if err = applyMigrationSteps(data, []migrationStep{step}); err != nil {
+			return nil, err
+		}
+
+		after := make(map[string]any)
+		flattenData("", data, after)
+
+		plan = append(plan, PlannedMigration{
+			From:    step.source(),
+			To:      step.target(),
+			Changes: diffData(before, after),
+		})
+	}
+
+	return plan, nil
+}
+
+// dataVersion returns the version key of raw config data, 0 if absent.
+func dataVersion(data map[string]any) (int, error) {
+	switch v := data["version"].(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	default:
+		return 0, fmt.Errorf("invalid config version %v", v)
 	}
+}
+
+// fileVersion returns the version a config file with the given version
+// key is migrated from. A missing or zero version means the file predates
+// versioning: it is taken to be at the lowest version a migration is
+// registered from or, with no migrations registered, at the target
+// version.
+func (c *Config) fileVersion(version int) int {
+	if version != 0 || c.targetVersion == 0 {
+		return version
+	}
+
+	sorted := c.sortedMigrations()
+	if len(sorted) == 0 {
+		return c.targetVersion
+	}
+
+	return sorted[0].from
+}
+
+// flattenData records every leaf of v in out under its dotted key path.
+// Maps are descended into; lists and scalars are leaves. Leaf values are
+// copied, so that later in-place changes to v do not affect out.
+func flattenData(path string, v any, out map[string]any) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		out[path] = deepCopyData(v)
+		return
+	}
+
+	if len(m) == 0 && path != "" {
+		out[path] = map[string]any{}
+		return
+	}
+
+	for key, val := range m {
+		flattenData(joinKeyPath(path, key), val, out)
+	}
+}
+
+// deepCopyData copies the maps and lists of raw config data.
+func deepCopyData(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		cp := make(map[string]any, len(v))
+		for key, val := range v {
+			cp[key] = deepCopyData(val)
+		}
+		return cp
+	case []any:
+		cp := make([]any, len(v))
+		for i, val := range v {
+			cp[i] = deepCopyData(val)
+		}
+		return cp
+	default:
+		return v
+	}
+}
+
+// diffData compares two flattened data sets and returns the changes
+// ordered by key.
+func diffData(before, after map[string]any) []MigrationChange {
+	var changes []MigrationChange
+
+	for key, old := range before {
+		val, ok := after[key]
+		switch {
+		case !ok:
+			changes = append(changes, MigrationChange{Op: ChangeRemoved, Key: key, Old: old})
+		case !reflect.DeepEqual(old, val):
+			changes = append(changes, MigrationChange{Op: ChangeModified, Key: key, Old: old, New: val})
+		}
+	}
+
+	for key, val := range after {
+		if _, ok := before[key]; !ok {
+			changes = append(changes, MigrationChange{Op: ChangeAdded, Key: key, New: val})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
 
-	return true, nil
+	return changes
 }
@@ -0,0 +1,261 @@
+// Package migrate provides declarative building blocks for configuration
+// migrations registered with config.AddMigration.
+//
+// Each helper returns a config.MigrationFunc operating on the raw config
+// data. Keys are addressed by dotted paths such as "logger.logLevel",
+// matched case-insensitively like configuration keys. Helpers whose source
+// key is missing do nothing, so a migration can be applied to files that
+// never had the key. Chain composes several helpers into one migration:
+//
+//	config.AddMigration(1, 2, migrate.Chain(
+//	    migrate.Rename("logger.logLevel", "logger.level"),
+//	    migrate.Move("service.dbHost", "service.database.host"),
+//	    migrate.Delete("service.legacyMode"),
+//	    migrate.SetDefault("service.timeout", "30s"),
+//	    migrate.SplitString("service.allowedHosts", ","),
+//	))
+package migrate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/inovacc/config"
+	"github.com/inovacc/config/internal/viper"
+)
+
+// TransformFunc returns the new value for a key given its current value.
+type TransformFunc func(value any) (any, error)
+
+// Chain returns a migration that runs steps in order and stops at the
+// first error.
+func Chain(steps ...config.MigrationFunc) config.MigrationFunc {
+	return func(data map[string]any) error {
+		for _, step := range steps {
+			if err := step(data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Rename renames the key at path to newPath, which must have the same
+// parent, e.g. Rename("logger.logLevel", "logger.level"). Use Move to
+// change the parent as well.
+func Rename(path, newPath string) config.MigrationFunc {
+	return func(data map[string]any) error {
+		parent, _ := splitParent(path)
+		newParent, _ := splitParent(newPath)
+		if !strings.EqualFold(parent, newParent) {
+			return fmt.Errorf("rename %s: %s is not a sibling key, use Move", path, newPath)
+		}
+
+		return move(data, path, newPath, "rename")
+	}
+}
+
+// Move moves the value at path to newPath, creating intermediate maps as
+// needed. Moving onto an existing key is an error.
+func Move(path, newPath string) config.MigrationFunc {
+	return func(data map[string]any) error {
+		return move(data, path, newPath, "move")
+	}
+}
+
+// Delete removes the key at path.
+func Delete(path string) config.MigrationFunc {
+	return func(data map[string]any) error {
+		parent, key, err := lookupParent(data, path, false)
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", path, err)
+		}
+
+		if parent != nil {
+			if k, ok := findKey(parent, key); ok {
+				delete(parent, k)
+			}
+		}
+
+		return nil
+	}
+}
+
+// SetDefault sets the key at path to value if it is not present,
+// creating intermediate maps as needed.
+func SetDefault(path string, value any) config.MigrationFunc {
+	return func(data map[string]any) error {
+		parent, key, err := lookupParent(data, path, true)
+		if err != nil {
+			return fmt.Errorf("set default %s: %w", path, err)
+		}
+
+		if _, ok := findKey(parent, key); !ok {
+			parent[key] = value
+		}
+
+		return nil
+	}
+}
+
+// Transform replaces the value at path with the result of fn.
+//
+// Example:
+//
+//	migrate.Transform("service.timeout", func(v any) (any, error) {
+//	    return fmt.Sprintf("%vs", v), nil // 30 → "30s"
+//	})
+func Transform(path string, fn TransformFunc) config.MigrationFunc {
+	return func(data map[string]any) error {
+		parent, key, err := lookupParent(data, path, false)
+		if err != nil {
+			return fmt.Errorf("transform %s: %w", path, err)
+		}
+		if parent == nil {
+			return nil
+		}
+
+		k, ok := findKey(parent, key)
+		if !ok {
+			return nil
+		}
+
+		value, err := fn(parent[k])
+		if err != nil {
+			return fmt.Errorf("transform %s: %w", path, err)
+		}
+
+		parent[k] = value
+
+		return nil
+	}
+}
+
+// SplitString turns a string value at path into a list by splitting it
+// around sep and trimming spaces, e.g. "a, b" into ["a", "b"]. Lists are
+// left unchanged and an empty string becomes an empty list.
+func SplitString(path, sep string) config.MigrationFunc {
+	return Transform(path, func(v any) (any, error) {
+		switch v := v.(type) {
+		case []any:
+			return v, nil
+		case string:
+			items := []any{}
+			if strings.TrimSpace(v) == "" {
+				return items, nil
+			}
+			for _, item := range strings.Split(v, sep) {
+				items = append(items, strings.TrimSpace(item))
+			}
+			return items, nil
+		default:
+			return nil, fmt.Errorf("expected a string, got %T", v)
+		}
+	})
+}
+
+// WrapInList turns a single value at path into a list holding it. Lists
+// are left unchanged.
+func WrapInList(path string) config.MigrationFunc {
+	return Transform(path, func(v any) (any, error) {
+		if list, ok := v.([]any); ok {
+			return list, nil
+		}
+
+		return []any{v}, nil
+	})
+}
+
+func move(data map[string]any, path, newPath, op string) error {
+	parent, key, err := lookupParent(data, path, false)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", op, path, err)
+	}
+	if parent == nil {
+		return nil
+	}
+
+	k, ok := findKey(parent, key)
+	if !ok {
+		return nil
+	}
+
+	value := parent[k]
+
+	newParentMap, newKey, err := lookupParent(data, newPath, true)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", op, path, err)
+	}
+
+	if existing, found := findKey(newParentMap, newKey); found && !(sameMap(parent, newParentMap) && existing == k) {
+		return fmt.Errorf("%s %s: %s already exists", op, path, newPath)
+	}
+
+	delete(parent, k)
+	newParentMap[newKey] = value
+
+	return nil
+}
+
+// lookupParent returns the map holding the last key of path, and that
+// key. If the parent does not exist, it is created when create is true
+// and nil is returned otherwise.
+func lookupParent(data map[string]any, path string, create bool) (map[string]any, string, error) {
+	parts := strings.Split(path, viper.DefaultKeyDelimiter)
+	for _, part := range parts {
+		if part == "" {
+			return nil, "", fmt.Errorf("invalid key path %q", path)
+		}
+	}
+
+	current := data
+	for i, part := range parts[:len(parts)-1] {
+		k, ok := findKey(current, part)
+		if !ok {
+			if !create {
+				return nil, "", nil
+			}
+			k = part
+			current[k] = make(map[string]any)
+		}
+
+		next, ok := current[k].(map[string]any)
+		if !ok {
+			return nil, "", fmt.Errorf("%s is not a map", strings.Join(parts[:i+1], viper.DefaultKeyDelimiter))
+		}
+		current = next
+	}
+
+	return current, parts[len(parts)-1], nil
+}
+
+// findKey returns the key of m matching key case-insensitively,
+// preferring an exact match.
+func findKey(m map[string]any, key string) (string, bool) {
+	if _, ok := m[key]; ok {
+		return key, true
+	}
+
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+func splitParent(path string) (parent, key string) {
+	if i := strings.LastIndex(path, viper.DefaultKeyDelimiter); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+
+	return "", path
+}
+
+// sameMap reports whether a and b are the same map.
+func sameMap(a, b map[string]any) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
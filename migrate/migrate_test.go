@@ -0,0 +1,220 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/inovacc/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testData() map[string]any {
+	return map[string]any{
+		"version": 1,
+		"logger": map[string]any{
+			"logLevel": "DEBUG",
+		},
+		"service": map[string]any{
+			"dbHost":       "localhost",
+			"legacyMode":   true,
+			"allowedHosts": "a.example.com, b.example.com",
+			"tags":         "primary",
+		},
+	}
+}
+
+func TestRename(t *testing.T) {
+	data := testData()
+
+	require.NoError(t, Rename("logger.logLevel", "logger.level")(data))
+	assert.Equal(t, map[string]any{"level": "DEBUG"}, data["logger"])
+
+	// Paths match keys case-insensitively, the new key keeps its case.
+	require.NoError(t, Rename("LOGGER.LEVEL", "logger.Level")(data))
+	assert.Equal(t, map[string]any{"Level": "DEBUG"}, data["logger"])
+
+	// A missing source key is not an error.
+	require.NoError(t, Rename("logger.missing", "logger.other")(data))
+	assert.Equal(t, map[string]any{"Level": "DEBUG"}, data["logger"])
+
+	err := Rename("logger.level", "service.level")(data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use Move")
+
+	err = Rename("service.dbHost", "service.tags")(data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service.tags already exists")
+}
+
+func TestMove(t *testing.T) {
+	data := testData()
+
+	require.NoError(t, Move("service.dbHost", "service.database.host")(data))
+	service := data["service"].(map[string]any)
+	assert.NotContains(t, service, "dbHost")
+	assert.Equal(t, map[string]any{"host": "localhost"}, service["database"])
+
+	require.NoError(t, Move("logger", "service.logger")(data))
+	assert.NotContains(t, data, "logger")
+	assert.Equal(t, map[string]any{"logLevel": "DEBUG"}, service["logger"])
+
+	err := Move("service.tags", "service.legacyMode.tags")(data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service.legacyMode is not a map")
+	assert.Equal(t, "primary", service["tags"], "a failed move must leave the source in place")
+}
+
+func TestDelete(t *testing.T) {
+	data := testData()
+
+	require.NoError(t, Delete("service.legacyMode")(data))
+	assert.NotContains(t, data["service"], "legacyMode")
+
+	require.NoError(t, Delete("service.legacyMode")(data))
+	require.NoError(t, Delete("missing.key")(data))
+
+	err := Delete("service..tags")(data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid key path")
+}
+
+func TestSetDefault(t *testing.T) {
+	data := testData()
+
+	require.NoError(t, SetDefault("service.timeout", "30s")(data))
+	require.NoError(t, SetDefault("service.dbHost", "db.example.com")(data))
+	require.NoError(t, SetDefault("metrics.port", 9090)(data))
+
+	service := data["service"].(map[string]any)
+	assert.Equal(t, "30s", service["timeout"])
+	assert.Equal(t, "localhost", service["dbHost"], "existing values must be kept")
+	assert.Equal(t, map[string]any{"port": 9090}, data["metrics"])
+}
+
+func TestTransform(t *testing.T) {
+	data := testData()
+
+	lower := func(v any) (any, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("not a string")
+		}
+		return strings.ToLower(s), nil
+	}
+
+	require.NoError(t, Transform("logger.logLevel", lower)(data))
+	assert.Equal(t, "debug", data["logger"].(map[string]any)["logLevel"])
+
+	require.NoError(t, Transform("logger.missing", lower)(data))
+	assert.NotContains(t, data["logger"], "missing")
+
+	err := Transform("service.legacyMode", lower)(data)
+	require.Error(t, err)
+	assert.EqualError(t, err, "transform service.legacyMode: not a string")
+}
+
+func TestSplitString(t *testing.T) {
+	data := testData()
+
+	require.NoError(t, SplitString("service.allowedHosts", ",")(data))
+	service := data["service"].(map[string]any)
+	assert.Equal(t, []any{"a.example.com", "b.example.com"}, service["allowedHosts"])
+
+	// Running again leaves the list unchanged.
+	require.NoError(t, SplitString("service.allowedHosts", ",")(data))
+	assert.Equal(t, []any{"a.example.com", "b.example.com"}, service["allowedHosts"])
+
+	service["empty"] = " "
+	require.NoError(t, SplitString("service.empty", ",")(data))
+	assert.Equal(t, []any{}, service["empty"])
+
+	require.Error(t, SplitString("service.legacyMode", ",")(data))
+}
+
+func TestWrapInList(t *testing.T) {
+	data := testData()
+
+	require.NoError(t, WrapInList("service.tags")(data))
+	require.NoError(t, WrapInList("service.tags")(data))
+
+	assert.Equal(t, []any{"primary"}, data["service"].(map[string]any)["tags"])
+}
+
+func TestChain(t *testing.T) {
+	data := testData()
+
+	err := Chain(
+		Rename("logger.logLevel", "logger.level"),
+		Move("service.dbHost", "service.database.host"),
+		Delete("service.legacyMode"),
+		SetDefault("service.timeout", "30s"),
+		SplitString("service.allowedHosts", ","),
+		WrapInList("service.tags"),
+	)(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"version": 1,
+		"logger": map[string]any{
+			"level": "DEBUG",
+		},
+		"service": map[string]any{
+			"database":     map[string]any{"host": "localhost"},
+			"timeout":      "30s",
+			"allowedHosts": []any{"a.example.com", "b.example.com"},
+			"tags":         []any{"primary"},
+		},
+	}, data)
+
+	err = Chain(
+		Delete("service.timeout"),
+		Rename("logger.level", "service.level"),
+		Delete("service.tags"),
+	)(data)
+	require.Error(t, err)
+	assert.NotContains(t, data["service"], "timeout", "steps before the failure are applied")
+	assert.Contains(t, data["service"], "tags", "steps after the failure are not run")
+}
+
+type hostsService struct {
+	Database struct {
+		Host string `yaml:"host"`
+	} `yaml:"database"`
+	AllowedHosts []string `yaml:"allowedHosts"`
+	Timeout      string   `yaml:"timeout"`
+}
+
+func TestChainWithInitServiceConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  dbHost: db.internal
+  allowedHosts: a.example.com,b.example.com
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	config.SetTargetVersion(2)
+	config.AddMigration(1, 2, Chain(
+		Move("service.dbHost", "service.database.host"),
+		SplitString("service.allowedHosts", ","),
+		SetDefault("service.timeout", "30s"),
+	))
+
+	require.NoError(t, config.InitServiceConfig(&hostsService{}, configPath))
+
+	svc, err := config.GetServiceConfig[*hostsService]()
+	require.NoError(t, err)
+	assert.Equal(t, 2, config.GetConfigVersion())
+	assert.Equal(t, "db.internal", svc.Database.Host)
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, svc.AllowedHosts)
+	assert.Equal(t, "30s", svc.Timeout)
+}
@@ -1,10 +1,17 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -231,6 +238,70 @@ service:
 	assert.Equal(t, "migrated", cfg.Environment)
 }
 
+func TestMigrationFirstRunWithTarget(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := filepath.Join(tempDir, "config.yaml")
+
+	migrated := false
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(map[string]any) error {
+		migrated = true
+		return nil
+	})
+
+	require.NoError(t, InitServiceConfig(&customService{}, configPath))
+	assert.False(t, migrated, "the generated file is already at the target version")
+	assert.Equal(t, 2, GetConfigVersion())
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "version: 2")
+}
+
+func TestMigrationUnversionedFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+environment: dev
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	// Without a version key the file is migrated from the lowest
+	// registered version.
+	SetTargetVersion(3)
+	AddMigration(1, 2, func(data map[string]any) error {
+		data["environment"] = "v2"
+		return nil
+	})
+	AddMigration(2, 3, func(map[string]any) error { return nil })
+
+	require.NoError(t, InitServiceConfig(&customService{}, configPath))
+	assert.Equal(t, []string{"v1→v2", "v2→v3"}, AppliedMigrations())
+	assert.Equal(t, 3, GetConfigVersion())
+	assert.Equal(t, "v2", GetBaseConfig().Environment)
+}
+
+func TestMigrationUnversionedFileWithoutMigrations(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	SetTargetVersion(2)
+
+	require.NoError(t, InitServiceConfig(&customService{}, configPath))
+	assert.Empty(t, AppliedMigrations())
+	assert.Equal(t, 2, GetConfigVersion())
+}
+
 func TestMigrationJSON(t *testing.T) {
 	resetGlobalConfig(t)
 	tempDir := setupTestDir(t)
@@ -337,3 +408,832 @@ logger:
 	cfg := GetBaseConfig()
 	assert.Equal(t, "ERROR", cfg.Logger.LogLevel) // profile override still applies
 }
+
+type endpointService struct {
+	Endpoint string `yaml:"endpoint"`
+}
+
+func TestMigrationAppliesToProfile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	baseContent := `
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+environment: prod
+service:
+  host: db.local
+  port: 5432
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", baseContent)
+
+	// The overlay was written against v1 and would re-introduce host and
+	// port after the base file is migrated.
+	prodContent := `
+version: 1
+service:
+  host: db.prod
+  port: 6543
+`
+	createTestConfig(t, tempDir, "config.prod.yaml", prodContent)
+
+	addAddressMigrations()
+	SetTargetVersion(3)
+
+	err := InitServiceConfig(&endpointService{}, configPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, GetConfigVersion())
+
+	svc, err := GetServiceConfig[*endpointService]()
+	require.NoError(t, err)
+	assert.Equal(t, "db.prod:6543", svc.Endpoint)
+	assert.False(t, globalConfig.viper.IsSet("service.host"), "old keys must not come back from the overlay")
+	assert.False(t, globalConfig.viper.IsSet("service.address"))
+}
+
+func TestMigrationProfileOwnVersion(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	baseContent := `
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+environment: prod
+service:
+  host: db.local
+  port: 5432
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", baseContent)
+
+	// An overlay already at v2 only runs the v2→v3 migration.
+	prodContent := `
+version: 2
+service:
+  address: db.prod:6543
+`
+	createTestConfig(t, tempDir, "config.prod.yaml", prodContent)
+
+	addAddressMigrations()
+	SetTargetVersion(3)
+
+	err := InitServiceConfig(&endpointService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*endpointService]()
+	require.NoError(t, err)
+	assert.Equal(t, "db.prod:6543", svc.Endpoint)
+	assert.Equal(t, 3, GetConfigVersion())
+}
+
+func TestMigrationProfileErrorNamesFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	baseContent := `
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+environment: prod
+service:
+  host: db.local
+  port: 5432
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", baseContent)
+	profilePath := createTestConfig(t, tempDir, "config.prod.yaml", "version: 4\n")
+
+	addAddressMigrations()
+	SetTargetVersion(3)
+
+	err := InitServiceConfig(&endpointService{}, configPath)
+	require.ErrorIs(t, err, ErrVersionTooNew)
+	assert.Contains(t, err.Error(), "migrating profile config "+profilePath)
+}
+
+func TestMigrationProfileStepErrorNamesFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	baseContent := `
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+environment: staging
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", baseContent)
+	profilePath := createTestConfig(t, tempDir, "config.staging.yaml", "logger:\n  logLevel: INFO\n")
+
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(data map[string]any) error {
+		if _, ok := data["appid"]; !ok {
+			return fmt.Errorf("appID is required")
+		}
+		return nil
+	})
+
+	err := InitServiceConfig(&customService{}, configPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "migrating profile config "+profilePath+": migration v1→v2: appID is required")
+}
+
+func TestMigrationPersistProfile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	baseContent := `
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+environment: prod
+service:
+  host: db.local
+  port: 5432
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", baseContent)
+
+	prodContent := `service:
+  host: db.prod
+  port: 6543
+`
+	profilePath := createTestConfig(t, tempDir, "config.prod.yaml", prodContent)
+
+	addAddressMigrations()
+	SetTargetVersion(2)
+
+	err := InitServiceConfig(&anotherService{}, configPath, WithPersistMigrations())
+	require.NoError(t, err)
+
+	// The overlay had no version and was taken to be at the base file's v1.
+	backup, err := os.ReadFile(profilePath + ".v1.bak")
+	require.NoError(t, err)
+	assert.Equal(t, prodContent, string(backup))
+
+	migrated, err := os.ReadFile(profilePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(migrated), "version: 2")
+	assert.Contains(t, string(migrated), "address: db.prod:6543")
+	assert.NotContains(t, string(migrated), "host:")
+}
+
+// addAddressMigrations registers reversible migrations for anotherService:
+// v1 has host and port, v2 joins them into address, v3 renames address to
+// endpoint.
+func addAddressMigrations() {
+	AddReversibleMigration(1, 2,
+		func(data map[string]any) error {
+			svc := data["service"].(map[string]any)
+			svc["address"] = fmt.Sprintf("%v:%v", svc["host"], svc["port"])
+			delete(svc, "host")
+			delete(svc, "port")
+			return nil
+		},
+		func(data map[string]any) error {
+			svc := data["service"].(map[string]any)
+			host, port, ok := strings.Cut(fmt.Sprint(svc["address"]), ":")
+			if !ok {
+				return fmt.Errorf("invalid address %v", svc["address"])
+			}
+			n, err := strconv.Atoi(port)
+			if err != nil {
+				return err
+			}
+			svc["host"], svc["port"] = host, n
+			delete(svc, "address")
+			return nil
+		})
+
+	AddReversibleMigration(2, 3,
+		func(data map[string]any) error {
+			svc := data["service"].(map[string]any)
+			svc["endpoint"] = svc["address"]
+			delete(svc, "address")
+			return nil
+		},
+		func(data map[string]any) error {
+			svc := data["service"].(map[string]any)
+			svc["address"] = svc["endpoint"]
+			delete(svc, "endpoint")
+			return nil
+		})
+}
+
+func TestMigrationRoundTrip(t *testing.T) {
+	resetGlobalConfig(t)
+
+	addAddressMigrations()
+	AllowDowngrade(true)
+
+	original := map[string]any{
+		"version": 1,
+		"service": map[string]any{"host": "db.local", "port": 5432},
+	}
+	data := map[string]any{
+		"version": 1,
+		"service": map[string]any{"host": "db.local", "port": 5432},
+	}
+
+	up, err := globalConfig.planMigrationSteps(1, 3)
+	require.NoError(t, err)
+	require.NoError(t, applyMigrationSteps(data, up))
+	assert.Equal(t, map[string]any{
+		"version": 3,
+		"service": map[string]any{"endpoint": "db.local:5432"},
+	}, data)
+
+	down, err := globalConfig.planMigrationSteps(3, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "v3→v2", down[0].String())
+	assert.Equal(t, "v2→v1", down[1].String())
+
+	require.NoError(t, applyMigrationSteps(data, down))
+	assert.Equal(t, original, data)
+}
+
+func TestMigrationDowngradeConfigFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+version: 3
+appID: validappid12345
+appSecret: validappsecret12345
+service:
+  endpoint: db.local:5432
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	addAddressMigrations()
+	SetTargetVersion(1)
+	AllowDowngrade(true)
+
+	err := InitServiceConfig(&anotherService{}, configPath)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, GetConfigVersion())
+
+	svc, err := GetServiceConfig[*anotherService]()
+	require.NoError(t, err)
+	assert.Equal(t, "db.local", svc.Host)
+	assert.Equal(t, 5432, svc.Port)
+}
+
+func TestMigrationVersionTooNew(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+version: 4
+appID: validappid12345
+appSecret: validappsecret12345
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	addAddressMigrations()
+	SetTargetVersion(3)
+
+	// Downgrades are not allowed by default.
+	err := InitServiceConfig(&anotherService{}, configPath)
+	require.ErrorIs(t, err, ErrVersionTooNew)
+	assert.Contains(t, err.Error(), "file is at version 4, this build expects version 3")
+
+	// Allowed, but there is no down migration from v4.
+	AllowDowngrade(true)
+	err = InitServiceConfig(&anotherService{}, configPath)
+	require.ErrorIs(t, err, ErrVersionTooNew)
+	assert.Contains(t, err.Error(), "no down migration from version 4")
+}
+
+func TestMigrationDowngradeWithoutDownFunc(t *testing.T) {
+	resetGlobalConfig(t)
+
+	AddMigration(1, 2, func(map[string]any) error { return nil })
+	AllowDowngrade(true)
+
+	_, err := globalConfig.planMigrationSteps(2, 1)
+	require.ErrorIs(t, err, ErrVersionTooNew)
+}
+
+func TestMigrationDowngradeError(t *testing.T) {
+	resetGlobalConfig(t)
+
+	addAddressMigrations()
+	AllowDowngrade(true)
+
+	steps, err := globalConfig.planMigrationSteps(2, 1)
+	require.NoError(t, err)
+
+	err = applyMigrationSteps(map[string]any{"service": map[string]any{"address": "no-port"}}, steps)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "migration v2→v1: invalid address no-port")
+	}
+}
+
+func TestMigrationPersist(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("persist-key"))
+	encPass, err := EncryptValue("db-pass")
+	require.NoError(t, err)
+
+	configContent := `version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  username: old-user
+  password: ` + encPass + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	SetEnvPrefix("PERSIST")
+	t.Setenv("PERSIST_SERVICE_USERNAME", "env-user")
+
+	runs := 0
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(data map[string]any) error {
+		runs++
+		logger := data["logger"].(map[string]any)
+		logger["format"] = "json"
+		return nil
+	})
+
+	err = InitServiceConfig(&customService{}, configPath, WithPersistMigrations())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, GetConfigVersion())
+	assert.Equal(t, []string{"v1→v2"}, AppliedMigrations())
+
+	backup, err := os.ReadFile(configPath + ".v1.bak")
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(backup))
+
+	migrated, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(migrated), "version: 2")
+	assert.Contains(t, string(migrated), "appID: validappid12345", "key case is preserved")
+	assert.Contains(t, string(migrated), "format: json")
+	assert.Contains(t, string(migrated), "username: old-user", "environment overrides are not persisted")
+	assert.Contains(t, string(migrated), "password: "+encPass, "encrypted values stay encrypted")
+
+	svc, err := GetServiceConfig[*customService]()
+	require.NoError(t, err)
+	assert.Equal(t, "db-pass", svc.Password)
+
+	// The next start finds the file at the target version.
+	err = InitServiceConfig(&customService{}, configPath, WithPersistMigrations())
+	require.NoError(t, err)
+	assert.Empty(t, AppliedMigrations())
+	assert.Equal(t, 1, runs)
+}
+
+func TestMigrationPersistKeepsComments(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `# Service configuration
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+
+logger:
+  logLevel: DEBUG # verbose until launch
+
+# Database connection
+service:
+  port: 5432 # default postgres port
+  host: db.local
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	addAddressMigrations()
+	SetTargetVersion(2)
+
+	require.NoError(t, os.Chmod(configPath, 0640))
+
+	err := InitServiceConfig(&anotherService{}, configPath, WithPersistMigrations())
+	require.NoError(t, err)
+
+	info, err := os.Stat(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm(), "permissions are kept")
+
+	migrated, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, `# Service configuration
+version: 2
+appID: validappid12345
+appSecret: validappsecret12345
+
+logger:
+  logLevel: DEBUG # verbose until launch
+
+# Database connection
+service:
+  address: db.local:5432
+`, string(migrated))
+}
+
+func TestMigrationPersistJSONAndExistingBackup(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `{
+  "version": 1,
+  "appID": "validappid12345",
+  "appSecret": "validappsecret12345"
+}`
+	configPath := createTestConfig(t, tempDir, "config.json", configContent)
+	createTestConfig(t, tempDir, "config.json.v1.bak", "older backup")
+
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(data map[string]any) error {
+		data["environment"] = "staging"
+		return nil
+	})
+
+	err := InitServiceConfig(&customService{}, configPath, WithPersistMigrations())
+	require.NoError(t, err)
+
+	migrated, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+
+	var data map[string]any
+	require.NoError(t, json.Unmarshal(migrated, &data), "file stays JSON")
+	assert.Equal(t, float64(2), data["version"])
+	assert.Equal(t, "staging", data["environment"])
+
+	older, err := os.ReadFile(configPath + ".v1.bak")
+	require.NoError(t, err)
+	assert.Equal(t, "older backup", string(older), "existing backups are not overwritten")
+
+	backup, err := os.ReadFile(configPath + ".v1.1.bak")
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(backup))
+}
+
+func TestWriteBackupNeverOverwrites(t *testing.T) {
+	tempDir := setupTestDir(t)
+	configPath := filepath.Join(tempDir, "config.yaml")
+	afs := afero.NewOsFs()
+
+	// Backups made within the same second get distinct names.
+	for i, want := range []string{".v1.bak", ".v1.1.bak", ".v1.2.bak"} {
+		backup, err := writeBackup(afs, configPath, 1, []byte(strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Equal(t, configPath+want, backup)
+	}
+
+	for i, name := range []string{".v1.bak", ".v1.1.bak", ".v1.2.bak"} {
+		content, err := os.ReadFile(configPath + name)
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(content))
+
+		info, err := os.Stat(configPath + name)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestMigrationPersistedOnlyWhenValid(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+	profileContent := "service:\n  username: staging-user\n"
+	profilePath := createTestConfig(t, tempDir, "config.staging.yaml", profileContent)
+
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(data map[string]any) error {
+		data["environment"] = "staging"
+		return nil
+	})
+	AddValidator(func(Config) error {
+		return errors.New("rejected")
+	})
+
+	err := InitServiceConfig(&customService{}, configPath, WithPersistMigrations())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rejected")
+
+	// Neither the base file nor the profile is left migrated.
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(content))
+	assert.NoFileExists(t, configPath+".v1.bak")
+
+	content, err = os.ReadFile(profilePath)
+	require.NoError(t, err)
+	assert.Equal(t, profileContent, string(content))
+	assert.NoFileExists(t, profilePath+".v1.bak")
+}
+
+func TestMigrationNotPersistedByDefault(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(map[string]any) error { return nil })
+
+	err := InitServiceConfig(&customService{}, configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 2, GetConfigVersion())
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(content))
+	assert.NoFileExists(t, configPath+".v1.bak")
+}
+
+func TestPersistedMigrationSeesLowercaseKeys(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: info
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	// Migrations see the same keys whether or not they are persisted.
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(data map[string]any) error {
+		logger, ok := data["logger"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("logger section missing")
+		}
+		level, ok := logger["loglevel"].(string)
+		if !ok {
+			return fmt.Errorf("loglevel missing")
+		}
+		logger["loglevel"] = strings.ToUpper(level)
+		return nil
+	})
+
+	err := InitServiceConfig(&customService{}, configPath, WithPersistMigrations())
+	require.NoError(t, err)
+	assert.Equal(t, "INFO", GetBaseConfig().Logger.LogLevel)
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, `version: 2
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: INFO
+`, string(content), "keys keep their case in the file")
+}
+
+func TestMigrationSeesLoadedSettings(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: info
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	t.Setenv("APP_ENVIRONMENT", "staging")
+	SetEnvPrefix("APP")
+
+	// Without persistence, migrations receive the loaded settings with
+	// lowercased keys and environment overrides, as they always have.
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(data map[string]any) error {
+		logger, ok := data["logger"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("logger section missing")
+		}
+		level, ok := logger["loglevel"].(string)
+		if !ok {
+			return fmt.Errorf("loglevel missing")
+		}
+		logger["loglevel"] = strings.ToUpper(level)
+		data["appid"] = "migratedappid12345"
+		if data["environment"] != "staging" {
+			return fmt.Errorf("environment override missing")
+		}
+		return nil
+	})
+
+	err := InitServiceConfig(&customService{}, configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "INFO", GetBaseConfig().Logger.LogLevel)
+	assert.Equal(t, "migratedappid12345", GetBaseConfig().AppID)
+}
+
+func TestMigrationRegistrationErrors(t *testing.T) {
+	resetGlobalConfig(t)
+
+	noop := func(map[string]any) error { return nil }
+
+	AddMigration(1, 2, noop)
+	AddMigration(2, 3, noop)
+	AddMigration(1, 2, noop) // duplicate
+	AddMigration(2, 4, noop) // overlaps 2→3
+	AddMigration(5, 5, noop) // does not go up
+	AddMigration(3, 4, nil)
+
+	err := ValidateMigrations()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "migration v1→v2 registered twice")
+		assert.Contains(t, err.Error(), "migration v2→v4 overlaps migration v2→v3")
+		assert.Contains(t, err.Error(), "migration v5→v5: target version must be greater than source version")
+		assert.Contains(t, err.Error(), "migration v3→v4: nil migration function")
+	}
+}
+
+func TestMigrationGap(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+version: 1
+appID: validappid12345
+appSecret: validappsecret12345
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	noop := func(map[string]any) error { return nil }
+
+	SetTargetVersion(4)
+	AddMigration(1, 2, noop)
+	AddMigration(3, 4, noop)
+
+	err := InitServiceConfig(&customService{}, configPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "gap in migrations: no migration from version 2 to version 3")
+	}
+	assert.Equal(t, 1, GetConfigVersion(), "no migration ran")
+
+	_, err = PlanMigrations(configPath)
+	assert.Error(t, err)
+}
+
+func TestMigrationTargetUnreachable(t *testing.T) {
+	resetGlobalConfig(t)
+
+	noop := func(map[string]any) error { return nil }
+
+	SetTargetVersion(5)
+	AddMigration(2, 3, noop)
+	AddMigration(3, 4, noop)
+
+	err := ValidateMigrations()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no migration from version 4 to target version 5")
+	}
+}
+
+func TestMigrationFromUnregisteredVersion(t *testing.T) {
+	resetGlobalConfig(t)
+
+	SetTargetVersion(3)
+	AddMigration(2, 3, func(map[string]any) error { return nil })
+	require.NoError(t, ValidateMigrations())
+
+	_, err := globalConfig.planMigrationSteps(1, 3)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cannot migrate from version 1 to target version 3: no migration from version 1")
+	}
+}
+
+func TestPlanMigrations(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `version: 1
+appID: validappid12345
+service:
+  host: db.local
+  port: 5432
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	addAddressMigrations()
+	SetTargetVersion(3)
+
+	plan, err := PlanMigrations(configPath)
+	require.NoError(t, err)
+	require.Len(t, plan, 2)
+
+	assert.Equal(t, 1, plan[0].From)
+	assert.Equal(t, 2, plan[0].To)
+	assert.Equal(t, []MigrationChange{
+		{Op: ChangeAdded, Key: "service.address", New: "db.local:5432"},
+		{Op: ChangeRemoved, Key: "service.host", Old: "db.local"},
+		{Op: ChangeRemoved, Key: "service.port", Old: 5432},
+		{Op: ChangeModified, Key: "version", Old: 1, New: 2},
+	}, plan[0].Changes)
+
+	assert.Equal(t, 2, plan[1].From)
+	assert.Equal(t, 3, plan[1].To)
+	var lines []string
+	for _, change := range plan[1].Changes {
+		lines = append(lines, change.String())
+	}
+	assert.Equal(t, []string{
+		"- service.address: db.local:5432",
+		"+ service.endpoint: db.local:5432",
+		"~ version: 2 → 3",
+	}, lines)
+
+	// A dry run changes neither the file nor the loaded configuration.
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(content))
+	assert.Equal(t, 0, GetConfigVersion())
+
+	// Nothing to do at the target version.
+	SetTargetVersion(1)
+	plan, err = PlanMigrations(configPath)
+	require.NoError(t, err)
+	assert.Empty(t, plan)
+}
+
+func TestMigrationOnReload(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `version: 2
+environment: staging
+appID: validappid12345
+appSecret: validappsecret12345
+service:
+  username: original
+`)
+	createTestConfig(t, tempDir, "config.staging.yaml", `version: 2
+service:
+  password: from-profile
+`)
+
+	SetTargetVersion(2)
+	AddMigration(1, 2, func(data map[string]any) error {
+		service, ok := data["service"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		if user, ok := service["user"]; ok {
+			service["username"] = user
+			delete(service, "user")
+		}
+		return nil
+	})
+
+	svc := &customService{}
+	require.NoError(t, InitServiceConfig(svc, configPath))
+
+	reloaded := make(chan struct{}, 1)
+	WatchConfig(func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+
+	// An old-version base file edited at runtime is migrated on reload.
+	editedContent := `version: 1
+environment: staging
+appID: validappid12345
+appSecret: validappsecret12345
+service:
+  user: edited
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(editedContent), 0644))
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for config reload")
+	}
+
+	mu.RLock()
+	username, password := svc.Username, svc.Password
+	mu.RUnlock()
+
+	assert.Equal(t, "edited", username)
+	assert.Equal(t, "from-profile", password)
+	assert.Equal(t, 2, GetConfigVersion())
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, editedContent, string(content), "reloads do not write the file back")
+}
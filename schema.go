@@ -0,0 +1,654 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inovacc/config/internal/document"
+	"github.com/inovacc/config/internal/jsonschema"
+)
+
+// schemaDialect is the JSON Schema draft produced by JSONSchema.
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// durationPattern matches the values accepted by time.ParseDuration.
+const durationPattern = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$`
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// validateFormats maps the validate tag rules describing a string format
+// to JSON Schema formats.
+var validateFormats = map[string]string{
+	"email":    "email",
+	"url":      "uri",
+	"uri":      "uri",
+	"hostname": "hostname",
+	"ipv4":     "ipv4",
+	"ipv6":     "ipv6",
+	"uuid":     "uuid",
+}
+
+// jsonSchema is a JSON Schema node. Fields are declared in the order they
+// are written.
+type jsonSchema struct {
+	Schema               string            `json:"$schema,omitempty"`
+	Title                string            `json:"title,omitempty"`
+	Description          string            `json:"description,omitempty"`
+	Type                 string            `json:"type,omitempty"`
+	Format               string            `json:"format,omitempty"`
+	Pattern              string            `json:"pattern,omitempty"`
+	Enum                 []any             `json:"enum,omitempty"`
+	Default              any               `json:"default,omitempty"`
+	Minimum              *float64          `json:"minimum,omitempty"`
+	ExclusiveMinimum     *float64          `json:"exclusiveMinimum,omitempty"`
+	Maximum              *float64          `json:"maximum,omitempty"`
+	ExclusiveMaximum     *float64          `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int              `json:"minLength,omitempty"`
+	MaxLength            *int              `json:"maxLength,omitempty"`
+	MinItems             *int              `json:"minItems,omitempty"`
+	MaxItems             *int              `json:"maxItems,omitempty"`
+	MinProperties        *int              `json:"minProperties,omitempty"`
+	MaxProperties        *int              `json:"maxProperties,omitempty"`
+	WriteOnly            bool              `json:"writeOnly,omitempty"`
+	Env                  []string          `json:"x-env,omitempty"`
+	Items                *jsonSchema       `json:"items,omitempty"`
+	Properties           *schemaProperties `json:"properties,omitempty"`
+	AdditionalProperties *jsonSchema       `json:"additionalProperties,omitempty"`
+	Required             []string          `json:"required,omitempty"`
+}
+
+// schemaProperties holds the properties of an object schema in struct
+// field order.
+type schemaProperties struct {
+	names   []string
+	schemas []*jsonSchema
+}
+
+func (p *schemaProperties) add(name string, s *jsonSchema) {
+	p.names = append(p.names, name)
+	p.schemas = append(p.schemas, s)
+}
+
+// MarshalJSON writes the properties as an object, keeping their order.
+func (p *schemaProperties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range p.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := json.Marshal(p.schemas[i])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// SchemaError is returned by InitServiceConfig when the configuration does
+// not match the schema given with WithSchema. It lists every violation.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+// SchemaViolation describes a configuration value that does not match the
+// schema.
+type SchemaViolation struct {
+	// Pointer is the JSON pointer of the value, e.g. "/service/port".
+	// Keys are spelled as in the schema.
+	Pointer string
+	// Keyword is the schema keyword that failed, e.g. "minimum".
+	Keyword string
+	// Message describes the failure.
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+
+	return "config does not match schema: " + strings.Join(msgs, "; ")
+}
+
+func (v SchemaViolation) String() string {
+	pointer := v.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+
+	return pointer + ": " + v.Message
+}
+
+// WithSchema validates the configuration against a JSON Schema, such as
+// one published by the team owning a service. The settings are validated
+// after the profile overlay and environment variables are merged and
+// before they are decoded, so InitServiceConfig and reloads fail with a
+// *SchemaError listing every violation.
+//
+// Values are validated as written: encrypted values and secret references
+// are not decrypted or resolved first. Keys match the schema
+// case-insensitively, and strings holding numbers, booleans or comma
+// separated lists are accepted where those are expected, as values from
+// environment variables are strings. Only references within the schema
+// are supported, so validation works offline. format is not checked.
+//
+// Example:
+//
+//	schema, err := os.ReadFile("service.schema.json")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	err = config.InitServiceConfig(svc, "config.yaml", config.WithSchema(schema))
+//	var schemaErr *config.SchemaError
+//	if errors.As(err, &schemaErr) {
+//	    for _, v := range schemaErr.Violations {
+//	        log.Printf("%s: %s", v.Pointer, v.Message)
+//	    }
+//	}
+func WithSchema(schema []byte) Option {
+	return func(c *Config) {
+		c.schema = schema
+	}
+}
+
+// validateSchema validates the current settings against the schema given
+// with WithSchema, if any.
+func (c *Config) validateSchema() error {
+	if c.schema == nil {
+		return nil
+	}
+
+	settings, err := c.settings()
+	if err != nil {
+		return err
+	}
+
+	return c.validateSettings(settings)
+}
+
+// validateChanges validates the current settings with changes applied,
+// as they will be read once the changes are persisted, against the schema
+// given with WithSchema, if any.
+func (c *Config) validateChanges(changes []MigrationChange) error {
+	if c.schema == nil {
+		return nil
+	}
+
+	settings, err := c.settings()
+	if err != nil {
+		return err
+	}
+
+	// The maps of settings may be shared with viper.
+	settings, _ = deepCopyData(settings).(map[string]any)
+	for _, change := range changes {
+		if change.Op == ChangeRemoved {
+			deleteData(settings, change.Key)
+		} else {
+			setData(settings, change.Key, change.New)
+		}
+	}
+
+	return c.validateSettings(settings)
+}
+
+// validateSettings validates settings against the schema given with
+// WithSchema.
+func (c *Config) validateSettings(settings map[string]any) error {
+	schema, err := jsonschema.Compile(c.schema)
+	if err != nil {
+		return fmt.Errorf("compiling schema: %w", err)
+	}
+
+	errs := schema.Validate(settings)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	schemaErr := &SchemaError{}
+	for _, e := range errs {
+		schemaErr.Violations = append(schemaErr.Violations, SchemaViolation{
+			Pointer: e.InstanceLocation,
+			Keyword: e.Keyword,
+			Message: e.Message,
+		})
+	}
+
+	return schemaErr
+}
+
+// validateRules are the rules of a validate tag understood by JSONSchema.
+type validateRules struct {
+	required     bool
+	min          *float64
+	max          *float64
+	exclusiveMin *float64
+	exclusiveMax *float64
+	length       *float64
+	oneOf        []string
+	format       string
+	elem         *validateRules
+}
+
+// JSONSchema returns a JSON Schema (draft 2020-12) describing config files
+// for the service configuration type T, such as *MyServiceConfig. It covers
+// the base Config keys and the keys of T under "service", so editors and CI
+// can validate config files before they reach InitServiceConfig.
+//
+// Keys are named by the mapstructure, yaml or json tag of each field. The
+// following field tags add to the schema:
+//   - description:"..." sets the description. Go doc comments are not
+//     available at runtime, so use this tag to document a key.
+//   - default:"..." sets the default, converted to the field type. Lists
+//     are comma separated. The tag documents the default: set the value
+//     itself in the struct passed to InitServiceConfig.
+//   - validate:"..." adds constraints for the rules required, min, max,
+//     gte, lte, gt, lt, len, oneof, email, url, uri, hostname, ipv4, ipv6
+//     and uuid, using the syntax of github.com/go-playground/validator.
+//     Rules after dive apply to the elements of a list or map. Other rules
+//     are ignored. InitServiceConfig does not enforce these rules.
+//   - sensitive:"true" marks the key as writeOnly.
+//   - env:"..." lists the environment variables of the key in the x-env
+//     annotation.
+//
+// Secret, time.Duration and encoding.TextUnmarshaler fields are strings.
+//
+// Example:
+//
+//	type MyServiceConfig struct {
+//	    Port int    `yaml:"port" default:"8080" validate:"min=1,max=65535" description:"Port to listen on"`
+//	    Mode string `yaml:"mode" validate:"oneof=dev prod"`
+//	}
+//
+//	schema, err := config.JSONSchema[*MyServiceConfig]()
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	_ = os.WriteFile("config.schema.json", schema, 0644)
+func JSONSchema[T any]() ([]byte, error) {
+	serviceType := reflect.TypeOf((*T)(nil)).Elem()
+
+	root, err := configSchema(serviceType)
+	if err != nil {
+		return nil, err
+	}
+
+	root.Schema = schemaDialect
+	root.Title = indirectType(serviceType).Name()
+
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// configSchema returns the schema of Config with serviceType as the type
+// of the service key.
+func configSchema(serviceType reflect.Type) (*jsonSchema, error) {
+	g := schemaGenerator{seen: make(map[reflect.Type]bool)}
+
+	root, err := g.typeSchema(reflect.TypeOf(Config{}), "")
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := g.typeSchema(serviceType, "service")
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range root.Properties.names {
+		if name == "service" {
+			service.Description = root.Properties.schemas[i].Description
+			root.Properties.schemas[i] = service
+		}
+	}
+
+	return root, nil
+}
+
+type schemaGenerator struct {
+	// seen holds the struct types being generated, to stop at recursive
+	// types.
+	seen map[reflect.Type]bool
+}
+
+// typeSchema returns the schema of the values of type t found at the key
+// path.
+func (g *schemaGenerator) typeSchema(t reflect.Type, path string) (*jsonSchema, error) {
+	t = indirectType(t)
+
+	switch {
+	case t == secretType:
+		return &jsonSchema{Type: "string", WriteOnly: true}, nil
+	case t == durationType:
+		return &jsonSchema{Type: "string", Pattern: durationPattern}, nil
+	case reflect.PointerTo(t).Implements(textUnmarshalerType):
+		return &jsonSchema{Type: "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &jsonSchema{Type: "integer"}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		zero := 0.0
+		return &jsonSchema{Type: "integer", Minimum: &zero}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}, nil
+
+	case reflect.String:
+		return &jsonSchema{Type: "string"}, nil
+
+	case reflect.Interface:
+		return &jsonSchema{}, nil
+
+	case reflect.Slice, reflect.Array:
+		items, err := g.typeSchema(t.Elem(), indexKeyPath(path, 0))
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "array", Items: items}, nil
+
+	case reflect.Map:
+		values, err := g.typeSchema(t.Elem(), joinKeyPath(path, "*"))
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{Type: "object", AdditionalProperties: values}, nil
+
+	case reflect.Struct:
+		if g.seen[t] {
+			// Recursive type: leave the nested value unconstrained.
+			return &jsonSchema{Type: "object"}, nil
+		}
+		g.seen[t] = true
+		defer delete(g.seen, t)
+
+		s := &jsonSchema{Type: "object", Properties: &schemaProperties{}}
+		if err := g.addFields(s, t, path); err != nil {
+			return nil, err
+		}
+		return s, nil
+
+	default:
+		return nil, fmt.Errorf("%s: unsupported type %s", path, t)
+	}
+}
+
+// addFields adds the properties for the fields of the struct type t to s.
+// Embedded structs squashed with `mapstructure:",squash"` add their fields
+// to s directly, as they are decoded.
+func (g *schemaGenerator) addFields(s *jsonSchema, t reflect.Type, path string) error {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := document.FieldKey(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		if _, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ","); field.Anonymous && opts == "squash" {
+			if err := g.addFields(s, indirectType(field.Type), path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := joinKeyPath(path, name)
+
+		fs, err := g.fieldSchema(field, key)
+		if err != nil {
+			return err
+		}
+
+		rules, err := parseValidateTag(field.Tag.Get("validate"))
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if rules.required {
+			s.Required = append(s.Required, name)
+		}
+
+		s.Properties.add(name, fs)
+	}
+
+	return nil
+}
+
+// fieldSchema returns the schema of a struct field, including the
+// constraints and annotations of its tags.
+func (g *schemaGenerator) fieldSchema(field reflect.StructField, key string) (*jsonSchema, error) {
+	s, err := g.typeSchema(field.Type, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Description = field.Tag.Get("description")
+
+	if field.Tag.Get("sensitive") == "true" {
+		s.WriteOnly = true
+	}
+
+	s.Env = envTagNames(field)
+
+	if def, ok := field.Tag.Lookup("default"); ok {
+		if s.Default, err = parseTagValue(field.Type, def); err != nil {
+			return nil, fmt.Errorf("%s: invalid default tag: %w", key, err)
+		}
+	}
+
+	rules, err := parseValidateTag(field.Tag.Get("validate"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	if err := applyRules(s, field.Type, rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return s, nil
+}
+
+// applyRules adds the constraints of rules to s, the schema of type t.
+func applyRules(s *jsonSchema, t reflect.Type, rules validateRules) error {
+	t = indirectType(t)
+
+	if rules.format != "" {
+		s.Format = rules.format
+	}
+
+	for _, v := range rules.oneOf {
+		value, err := parseTagValue(t, v)
+		if err != nil {
+			return fmt.Errorf("invalid oneof value: %w", err)
+		}
+		s.Enum = append(s.Enum, value)
+	}
+
+	switch s.Type {
+	case "integer", "number":
+		s.Minimum = maxOf(s.Minimum, rules.min)
+		s.Maximum = rules.max
+		s.ExclusiveMinimum = rules.exclusiveMin
+		s.ExclusiveMaximum = rules.exclusiveMax
+		if rules.length != nil {
+			s.Minimum, s.Maximum = rules.length, rules.length
+		}
+
+	case "string":
+		s.MinLength, s.MaxLength = lengthBounds(rules)
+
+	case "array":
+		s.MinItems, s.MaxItems = lengthBounds(rules)
+		if rules.elem != nil {
+			return applyRules(s.Items, t.Elem(), *rules.elem)
+		}
+
+	case "object":
+		s.MinProperties, s.MaxProperties = lengthBounds(rules)
+		if rules.elem != nil && s.AdditionalProperties != nil {
+			return applyRules(s.AdditionalProperties, t.Elem(), *rules.elem)
+		}
+	}
+
+	return nil
+}
+
+// lengthBounds returns the length constraints of rules for strings, lists
+// and maps.
+func lengthBounds(rules validateRules) (minLen, maxLen *int) {
+	toInt := func(f *float64) *int {
+		if f == nil {
+			return nil
+		}
+		n := int(*f)
+		return &n
+	}
+
+	if rules.length != nil {
+		return toInt(rules.length), toInt(rules.length)
+	}
+
+	return toInt(rules.min), toInt(rules.max)
+}
+
+func maxOf(a, b *float64) *float64 {
+	if a == nil || (b != nil && *b > *a) {
+		return b
+	}
+
+	return a
+}
+
+// parseValidateTag parses the rules of a validate tag understood by
+// JSONSchema, ignoring the others.
+func parseValidateTag(tag string) (validateRules, error) {
+	var rules validateRules
+	if tag == "" {
+		return rules, nil
+	}
+
+	parts := strings.Split(tag, ",")
+	for i, part := range parts {
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		var bound **float64
+		switch name {
+		case "required":
+			rules.required = true
+		case "min", "gte":
+			bound = &rules.min
+		case "max", "lte":
+			bound = &rules.max
+		case "gt":
+			bound = &rules.exclusiveMin
+		case "lt":
+			bound = &rules.exclusiveMax
+		case "len":
+			bound = &rules.length
+		case "oneof":
+			rules.oneOf = strings.Fields(param)
+		case "dive":
+			elem, err := parseValidateTag(strings.Join(parts[i+1:], ","))
+			if err != nil {
+				return rules, err
+			}
+			rules.elem = &elem
+			return rules, nil
+		default:
+			if format, ok := validateFormats[name]; ok {
+				rules.format = format
+			}
+		}
+
+		if bound != nil {
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return rules, fmt.Errorf("invalid validate rule %q: %w", part, err)
+			}
+			*bound = &n
+		}
+	}
+
+	return rules, nil
+}
+
+// parseTagValue converts the tag value s to a value of type t, as it
+// would be written in a config file. Lists are comma separated.
+func parseTagValue(t reflect.Type, s string) (any, error) {
+	t = indirectType(t)
+
+	switch {
+	case t == secretType:
+		return s, nil
+	case t == durationType:
+		if _, err := time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case reflect.PointerTo(t).Implements(textUnmarshalerType):
+		return s, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.ParseUint(s, 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, t.Bits())
+	case reflect.String, reflect.Interface:
+		return s, nil
+	case reflect.Slice, reflect.Array:
+		items := []any{}
+		if strings.TrimSpace(s) == "" {
+			return items, nil
+		}
+		for _, item := range strings.Split(s, ",") {
+			v, err := parseTagValue(t.Elem(), strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("not supported for %s", t)
+	}
+}
+
+// indirectType returns the type pointed to by t, following all pointers.
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
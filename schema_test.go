@@ -0,0 +1,361 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type SchemaBase struct {
+	Region string `yaml:"region" default:"eu-west-1"`
+}
+
+type schemaUpstream struct {
+	URL    string `yaml:"url" validate:"required,url"`
+	Weight uint   `yaml:"weight" validate:"lte=100"`
+}
+
+type schemaNode struct {
+	Name     string        `yaml:"name"`
+	Children []*schemaNode `yaml:"children"`
+}
+
+type schemaService struct {
+	SchemaBase `mapstructure:",squash"`
+
+	Port      int               `yaml:"port" default:"8080" validate:"required,min=1,max=65535" description:"Port to listen on"`
+	Mode      string            `yaml:"mode" validate:"oneof=dev prod"`
+	Ratio     float64           `yaml:"ratio" validate:"gt=0,lt=1"`
+	Enabled   bool              `yaml:"enabled" default:"true"`
+	Timeout   time.Duration     `yaml:"timeout" default:"30s"`
+	Password  Secret            `yaml:"password"`
+	APIKey    string            `yaml:"apiKey" sensitive:"true" validate:"len=32"`
+	Hosts     []string          `yaml:"hosts" default:"a.local, b.local" validate:"min=1,dive,hostname"`
+	Ports     []int             `yaml:"ports" validate:"dive,oneof=80 443"`
+	Labels    map[string]string `yaml:"labels" validate:"max=10"`
+	Upstreams []schemaUpstream  `yaml:"upstreams"`
+	Bind      net.IP            `yaml:"bind"`
+	Tree      schemaNode        `yaml:"tree"`
+	Extra     any               `yaml:"extra"`
+	internal  string
+	Ignored   string `yaml:"-"`
+}
+
+// schemaProperty returns the schema of the dotted property path.
+func schemaProperty(t *testing.T, schema map[string]any, path ...string) map[string]any {
+	t.Helper()
+
+	current := schema
+	for _, name := range path {
+		properties, ok := current["properties"].(map[string]any)
+		require.True(t, ok, "no properties at %s", name)
+		current, ok = properties[name].(map[string]any)
+		require.True(t, ok, "no property %s", name)
+	}
+
+	return current
+}
+
+func TestJSONSchema(t *testing.T) {
+	content, err := JSONSchema[*schemaService]()
+	require.NoError(t, err)
+
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(content, &schema))
+
+	assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", schema["$schema"])
+	assert.Equal(t, "schemaService", schema["title"])
+	assert.Equal(t, "object", schema["type"])
+
+	// Base configuration keys
+	assert.Equal(t, map[string]any{
+		"description": "Application secret, generated if empty",
+		"type":        "string",
+		"minLength":   float64(12),
+		"writeOnly":   true,
+	}, schemaProperty(t, schema, "appSecret"))
+	assert.NotContains(t, schemaProperty(t, schema, "logger", "logLevel"), "enum", "levels are case-insensitive")
+	assert.Equal(t, "dev", schemaProperty(t, schema, "environment")["default"])
+	assert.NotContains(t, schema["properties"], "AppVersion")
+	assert.NotContains(t, schema["properties"], "ConfigFile")
+
+	service := schemaProperty(t, schema, "service")
+	assert.Equal(t, "Service-specific configuration", service["description"])
+	assert.Equal(t, []any{"port"}, service["required"])
+
+	assert.Equal(t, map[string]any{
+		"description": "Port to listen on",
+		"type":        "integer",
+		"default":     float64(8080),
+		"minimum":     float64(1),
+		"maximum":     float64(65535),
+	}, schemaProperty(t, service, "port"))
+	assert.Equal(t, []any{"dev", "prod"}, schemaProperty(t, service, "mode")["enum"])
+	assert.Equal(t, map[string]any{
+		"type":             "number",
+		"exclusiveMinimum": float64(0),
+		"exclusiveMaximum": float64(1),
+	}, schemaProperty(t, service, "ratio"))
+	assert.Equal(t, true, schemaProperty(t, service, "enabled")["default"])
+	assert.Equal(t, map[string]any{
+		"type":    "string",
+		"pattern": durationPattern,
+		"default": "30s",
+	}, schemaProperty(t, service, "timeout"))
+	assert.Equal(t, map[string]any{"type": "string", "writeOnly": true}, schemaProperty(t, service, "password"))
+	assert.Equal(t, map[string]any{
+		"type":      "string",
+		"minLength": float64(32),
+		"maxLength": float64(32),
+		"writeOnly": true,
+	}, schemaProperty(t, service, "apiKey"))
+	assert.Equal(t, map[string]any{
+		"type":     "array",
+		"default":  []any{"a.local", "b.local"},
+		"minItems": float64(1),
+		"items":    map[string]any{"type": "string", "format": "hostname"},
+	}, schemaProperty(t, service, "hosts"))
+	assert.Equal(t, map[string]any{
+		"type":  "array",
+		"items": map[string]any{"type": "integer", "enum": []any{float64(80), float64(443)}},
+	}, schemaProperty(t, service, "ports"))
+	assert.Equal(t, map[string]any{
+		"type":                 "object",
+		"maxProperties":        float64(10),
+		"additionalProperties": map[string]any{"type": "string"},
+	}, schemaProperty(t, service, "labels"))
+	assert.Equal(t, map[string]any{"type": "string"}, schemaProperty(t, service, "bind"), "TextUnmarshaler types are strings")
+	assert.Equal(t, map[string]any{}, schemaProperty(t, service, "extra"))
+
+	upstream := schemaProperty(t, service, "upstreams")["items"].(map[string]any)
+	assert.Equal(t, []any{"url"}, upstream["required"])
+	assert.Equal(t, "uri", schemaProperty(t, upstream, "url")["format"])
+	assert.Equal(t, map[string]any{
+		"type":    "integer",
+		"minimum": float64(0),
+		"maximum": float64(100),
+	}, schemaProperty(t, upstream, "weight"))
+
+	// Recursive types stop at the first repetition.
+	children := schemaProperty(t, service, "tree", "children")
+	assert.Equal(t, map[string]any{"type": "object"}, children["items"])
+
+	// Squashed fields are keys of the service itself.
+	assert.Equal(t, "eu-west-1", schemaProperty(t, service, "region")["default"])
+
+	properties := service["properties"].(map[string]any)
+	assert.NotContains(t, properties, "internal")
+	assert.NotContains(t, properties, "Ignored")
+	assert.NotContains(t, properties, "SchemaBase")
+}
+
+func TestJSONSchemaKeepsFieldOrder(t *testing.T) {
+	content, err := JSONSchema[*schemaUpstream]()
+	require.NoError(t, err)
+
+	assert.Regexp(t, `(?s)"version".*"environment".*"appID".*"appSecret".*"logger".*"service"`, string(content))
+	assert.Regexp(t, `(?s)"url".*"weight"`, string(content))
+}
+
+func TestJSONSchemaErrors(t *testing.T) {
+	type badDefault struct {
+		Port int `yaml:"port" default:"http"`
+	}
+	_, err := JSONSchema[*badDefault]()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service.port: invalid default tag")
+
+	type badRule struct {
+		Hosts []string `yaml:"hosts" validate:"min=one"`
+	}
+	_, err = JSONSchema[badRule]()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service.hosts: invalid validate rule")
+
+	type badType struct {
+		Events chan string `yaml:"events"`
+	}
+	_, err = JSONSchema[*badType]()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service.events: unsupported type chan string")
+}
+
+const portSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["service"],
+  "properties": {
+    "service": {
+      "type": "object",
+      "required": ["host", "port"],
+      "properties": {
+        "host": {"type": "string", "format": "hostname"},
+        "port": {"$ref": "#/$defs/port"}
+      }
+    }
+  },
+  "$defs": {
+    "port": {"type": "integer", "minimum": 1024, "maximum": 65535}
+  }
+}`
+
+type portService struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+func TestWithSchema(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  host: db.local
+  port: 5432
+`)
+
+	svc := &portService{}
+	require.NoError(t, InitServiceConfig(svc, configPath, WithSchema([]byte(portSchema))))
+	assert.Equal(t, 5432, svc.Port)
+}
+
+func TestWithSchemaViolations(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  port: 80
+`)
+
+	err := InitServiceConfig(&portService{}, configPath, WithSchema([]byte(portSchema)))
+	require.Error(t, err)
+
+	var schemaErr *SchemaError
+	require.True(t, errors.As(err, &schemaErr))
+	assert.Equal(t, []SchemaViolation{
+		{Pointer: "/service", Keyword: "required", Message: `missing required property "host"`},
+		{Pointer: "/service/port", Keyword: "minimum", Message: "must be >= 1024"},
+	}, schemaErr.Violations)
+	assert.EqualError(t, err, `config does not match schema: /service: missing required property "host"; /service/port: must be >= 1024`)
+}
+
+func TestWithSchemaTypeMismatch(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  host: db.local
+  port: [5432]
+`)
+
+	// The schema reports the value instead of the decoder.
+	err := InitServiceConfig(&portService{}, configPath, WithSchema([]byte(portSchema)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/service/port: must be integer, got array")
+}
+
+func TestWithSchemaProfileAndEnv(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	// The base file alone is incomplete: the profile provides the host.
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+environment: prod
+service:
+  port: 80
+`)
+	createTestConfig(t, tempDir, "config.prod.yaml", "service:\n  host: db.prod\n")
+
+	// Environment values are strings, converted like when decoding.
+	t.Setenv("SCHEMA_SERVICE_PORT", "6543")
+	SetEnvPrefix("SCHEMA")
+
+	svc := &portService{}
+	require.NoError(t, InitServiceConfig(svc, configPath, WithSchema([]byte(portSchema))))
+	assert.Equal(t, "db.prod", svc.Host)
+	assert.Equal(t, 6543, svc.Port)
+}
+
+func TestWithSchemaEnvViolation(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  host: db.local
+  port: 5432
+`)
+
+	t.Setenv("SCHEMA_SERVICE_PORT", "not-a-port")
+	SetEnvPrefix("SCHEMA")
+
+	err := InitServiceConfig(&portService{}, configPath, WithSchema([]byte(portSchema)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/service/port: must be integer, got string")
+}
+
+func TestWithSchemaRejectsRemoteRef(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", "appID: validappid12345\nappSecret: validappsecret12345\n")
+
+	schema := `{"properties": {"service": {"$ref": "https://example.com/service.json"}}}`
+	err := InitServiceConfig(&portService{}, configPath, WithSchema([]byte(schema)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "compiling schema")
+	assert.Contains(t, err.Error(), "only references within the schema are supported")
+}
+
+func TestWithSchemaFromJSONSchema(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	schema, err := JSONSchema[*schemaUpstream]()
+	require.NoError(t, err)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: INFO
+service:
+  url: https://example.com
+  weight: 101
+`)
+
+	err = InitServiceConfig(&schemaUpstream{}, configPath, WithSchema(schema))
+	require.Error(t, err)
+	assert.EqualError(t, err, "config does not match schema: /service/weight: must be <= 100")
+}
+
+func TestWithSchemaFromJSONSchemaLowercaseLogLevel(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	schema, err := JSONSchema[*schemaUpstream]()
+	require.NoError(t, err)
+
+	configPath := createTestConfig(t, tempDir, "config.yaml", `appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: info
+service:
+  url: https://example.com
+  weight: 10
+`)
+
+	require.NoError(t, InitServiceConfig(&schemaUpstream{}, configPath, WithSchema(schema)))
+	assert.Equal(t, "info", GetBaseConfig().Logger.LogLevel)
+}
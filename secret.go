@@ -0,0 +1,208 @@
+package config
+
+import (
+	"log/slog"
+	"reflect"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// Secret holds a sensitive configuration value. Use it instead of string
+// for service config fields such as passwords and tokens:
+//
+//	type MyServiceConfig struct {
+//	    DBPassword config.Secret `yaml:"dbPassword"`
+//	}
+//
+// A Secret decodes from plaintext, ENC[...] values and secret references
+// like any string field, but prints as "********" with fmt, encoding/json,
+// yaml and slog. The value is only available through Reveal.
+//
+// Copies of a Secret share the same storage. When the configuration is
+// reloaded, secrets replaced by new values are wiped: their bytes are
+// zeroed and Reveal returns "" from then on, so do not keep copies of a
+// Secret beyond the request or connection that uses it.
+type Secret struct {
+	v *secretValue
+}
+
+type secretValue struct {
+	b []byte
+}
+
+var secretType = reflect.TypeOf(Secret{})
+
+// NewSecret returns a Secret holding s.
+//
+// Example:
+//
+//	svc := &MyServiceConfig{DBPassword: config.NewSecret("dev-password")}
+func NewSecret(s string) Secret {
+	return Secret{v: &secretValue{b: []byte(s)}}
+}
+
+// Reveal returns the secret value.
+//
+// Example:
+//
+//	db, err := sql.Open("postgres", "password="+svc.DBPassword.Reveal())
+func (s Secret) Reveal() string {
+	if s.v == nil {
+		return ""
+	}
+
+	return string(s.v.b)
+}
+
+// IsZero reports whether the secret is empty or has been wiped.
+func (s Secret) IsZero() bool {
+	return s.v == nil || len(s.v.b) == 0
+}
+
+// Wipe zeroes the secret's bytes. All copies of s become empty.
+func (s Secret) Wipe() {
+	if s.v == nil {
+		return
+	}
+
+	clear(s.v.b)
+	s.v.b = nil
+}
+
+// String returns "********", or "" if the secret is empty.
+func (s Secret) String() string {
+	if s.IsZero() {
+		return ""
+	}
+
+	return maskedValue
+}
+
+// GoString masks the secret for the %#v verb.
+func (s Secret) GoString() string {
+	return "config.Secret(" + s.String() + ")"
+}
+
+// LogValue implements slog.LogValuer.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// MarshalText implements encoding.TextMarshaler, which encoding/json and
+// yaml use, and masks the secret so that it is never written back to a
+// file or sent over the wire.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = NewSecret(string(text))
+	return nil
+}
+
+// SecretDecodeHook returns a mapstructure decode hook that populates
+// Secret fields from strings. It is used by InitServiceConfig; pass it to
+// your own mapstructure or viper decoding to decode Secret fields there.
+//
+// Example:
+//
+//	err := v.Unmarshal(&cfg, viper.DecodeHook(config.SecretDecodeHook()))
+func SecretDecodeHook() mapstructure.DecodeHookFunc {
+	return func(_, to reflect.Type, data any) (any, error) {
+		if to != secretType {
+			return data, nil
+		}
+
+		switch v := data.(type) {
+		case string:
+			return NewSecret(v), nil
+		case []byte:
+			return NewSecret(string(v)), nil
+		}
+
+		return data, nil
+	}
+}
+
+// decodeHook is the hook used to unmarshal the configuration: viper's
+// defaults plus Secret support and the encodings of lists, maps and
+// structs in environment variables.
+func decodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		SecretDecodeHook(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		stringToSliceHook(),
+	)
+}
+
+// collectSecrets returns the storage of every non-empty Secret reachable
+// from v.
+func collectSecrets(v any) map[*secretValue]bool {
+	secrets := make(map[*secretValue]bool)
+
+	walkSecrets(reflect.ValueOf(v), make(map[uintptr]bool), func(s Secret) {
+		if !s.IsZero() {
+			secrets[s.v] = true
+		}
+	})
+
+	return secrets
+}
+
+// wipeReplacedSecrets wipes the secrets in old that are no longer
+// reachable from v.
+func wipeReplacedSecrets(old map[*secretValue]bool, v any) {
+	current := collectSecrets(v)
+
+	for sv := range old {
+		if !current[sv] {
+			Secret{v: sv}.Wipe()
+		}
+	}
+}
+
+// walkSecrets calls fn for every Secret reachable from rv.
+func walkSecrets(rv reflect.Value, seen map[uintptr]bool, fn func(Secret)) {
+	if !rv.IsValid() {
+		return
+	}
+
+	if rv.Type() == secretType {
+		fn(rv.Interface().(Secret))
+		return
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() || seen[rv.Pointer()] {
+			return
+		}
+		seen[rv.Pointer()] = true
+		walkSecrets(rv.Elem(), seen, fn)
+
+	case reflect.Interface:
+		if !rv.IsNil() {
+			walkSecrets(rv.Elem(), seen, fn)
+		}
+
+	case reflect.Struct:
+		rt := rv.Type()
+		for i := range rt.NumField() {
+			if rt.Field(i).IsExported() {
+				walkSecrets(rv.Field(i), seen, fn)
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := range rv.Len() {
+			walkSecrets(rv.Index(i), seen, fn)
+		}
+
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			walkSecrets(iter.Value(), seen, fn)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+type secretService struct {
+	Username string            `yaml:"username"`
+	Password Secret            `yaml:"password"`
+	Token    *Secret           `yaml:"token"`
+	Keys     []Secret          `yaml:"keys"`
+	Headers  map[string]Secret `yaml:"headers"`
+}
+
+func TestSecretMasksItself(t *testing.T) {
+	s := NewSecret("hunter2")
+
+	assert.Equal(t, "hunter2", s.Reveal())
+	assert.Equal(t, "********", s.String())
+	assert.Equal(t, "********", fmt.Sprint(s))
+	assert.NotContains(t, fmt.Sprintf("%+v %#v", s, s), "hunter2")
+	assert.NotContains(t, fmt.Sprintf("%+v", secretService{Password: s}), "hunter2")
+
+	data, err := json.Marshal(secretService{Password: s})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.Contains(t, string(data), `"Password":"********"`)
+
+	data, err = yaml.Marshal(secretService{Password: s})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.Contains(t, string(data), "password: '********'")
+
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("test", "password", s, "svc", secretService{Password: s})
+	assert.NotContains(t, buf.String(), "hunter2")
+
+	assert.Equal(t, "", Secret{}.String())
+	assert.True(t, Secret{}.IsZero())
+}
+
+func TestSecretWipe(t *testing.T) {
+	s := NewSecret("hunter2")
+	cp := s
+
+	raw := s.v.b
+	s.Wipe()
+
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0}, raw)
+	assert.Equal(t, "", cp.Reveal())
+	assert.True(t, cp.IsZero())
+
+	// Wiping a zero Secret is a no-op.
+	Secret{}.Wipe()
+}
+
+func TestSecretUnmarshal(t *testing.T) {
+	var svc secretService
+	require.NoError(t, json.Unmarshal([]byte(`{"Password":"json-pass"}`), &svc))
+	assert.Equal(t, "json-pass", svc.Password.Reveal())
+
+	require.NoError(t, yaml.Unmarshal([]byte("password: yaml-pass\n"), &svc))
+	assert.Equal(t, "yaml-pass", svc.Password.Reveal())
+}
+
+func TestSecretConfigFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("secret-type-key"))
+	t.Setenv("SECRET_TYPE_TOKEN", "env-token")
+
+	encPass, err := EncryptValueForKey("service.password", "decrypted-pass")
+	require.NoError(t, err)
+
+	encHeader, err := EncryptValue("header-value")
+	require.NoError(t, err)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  username: alice
+  password: ` + encPass + `
+  token: secret://env/SECRET_TYPE_TOKEN
+  keys:
+    - plain-key
+  headers:
+    authorization: ` + encHeader + `
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&secretService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*secretService]()
+	require.NoError(t, err)
+	assert.Equal(t, "alice", svc.Username)
+	assert.Equal(t, "decrypted-pass", svc.Password.Reveal())
+	require.NotNil(t, svc.Token)
+	assert.Equal(t, "env-token", svc.Token.Reveal())
+	assert.Equal(t, "plain-key", svc.Keys[0].Reveal())
+	assert.Equal(t, "header-value", svc.Headers["authorization"].Reveal())
+
+	secure := GetSecureCopy().Service.(*secretService)
+	assert.Equal(t, "********", secure.Password.Reveal())
+	assert.Equal(t, "********", secure.Token.Reveal())
+	assert.Equal(t, "decrypted-pass", svc.Password.Reveal(), "masking must not modify the original")
+}
+
+func TestSecretDefaultKeptWhenNotInFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+service:
+  username: alice
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err := InitServiceConfig(&secretService{Password: NewSecret("default-pass")}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*secretService]()
+	require.NoError(t, err)
+	assert.Equal(t, "default-pass", svc.Password.Reveal())
+}
+
+func TestSecretWipedOnReload(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+service:
+  password: first-pass
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err := InitServiceConfig(&secretService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*secretService]()
+	require.NoError(t, err)
+	old := svc.Password
+	assert.Equal(t, "first-pass", old.Reveal())
+
+	reloaded := make(chan struct{}, 1)
+	WatchConfig(func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
+
+	updatedContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+service:
+  password: second-pass
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(updatedContent), 0644))
+
+	select {
+	case <-reloaded:
+		svc, err = GetServiceConfig[*secretService]()
+		require.NoError(t, err)
+		assert.Equal(t, "second-pass", svc.Password.Reveal())
+		assert.True(t, old.IsZero(), "replaced secret must be wiped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for config reload")
+	}
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+const secretRefPrefix = "secret://"
+
+// maxSecretSize limits how much a built-in resolver reads for one value.
+const maxSecretSize = 1 << 20
+
+// SecretResolver resolves a secret reference found in a configuration
+// value to the secret itself.
+//
+// References take the form "secret://<scheme>/<ref>", for example
+// "secret://file//run/secrets/db" passes "/run/secrets/db" to the resolver
+// of the "file" scheme. Schemes registered with RegisterSecretResolver may
+// also use the shorter "<scheme>://<ref>", such as "vault://kv/db"; the
+// built-in resolvers do not, so that ordinary URLs like "file:///tmp" are
+// left alone.
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretResolverFunc adapts a function to the SecretResolver interface.
+type SecretResolverFunc func(ctx context.Context, ref string) (string, error)
+
+// Resolve calls f(ctx, ref).
+func (f SecretResolverFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// builtinResolvers are available without registration, through the
+// explicit secret://<scheme>/<ref> form only.
+var builtinResolvers = map[string]SecretResolver{
+	"env":  EnvResolver(),
+	"file": FileResolver(),
+}
+
+// RegisterSecretResolver registers r for values referencing scheme,
+// replacing any previous resolver, including the built-in "env" and "file"
+// resolvers. Registering a nil resolver removes the scheme. Values of the
+// short form "<scheme>://<ref>" are only references to registered schemes;
+// register the built-in resolvers to use it for them:
+//
+//	config.RegisterSecretResolver("env", config.EnvResolver())
+//
+// References are resolved during InitServiceConfig and on reload, before
+// ENC[...] values are decrypted, and resolved values are always masked by
+// GetSecureCopy and LogConfig.
+//
+// Must be called before InitServiceConfig.
+//
+// Example:
+//
+//	config.RegisterSecretResolver("vault", config.SecretResolverFunc(
+//	    func(ctx context.Context, ref string) (string, error) {
+//	        return vaultClient.Read(ctx, ref)
+//	    }))
+func RegisterSecretResolver(scheme string, r SecretResolver) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if globalConfig.resolvers == nil {
+		globalConfig.resolvers = make(map[string]SecretResolver)
+	}
+
+	globalConfig.resolvers[scheme] = r
+}
+
+// ExecResolver returns a SecretResolver that runs the reference as a
+// command, without a shell, and returns its trimmed standard output. It is
+// not registered by default; register it explicitly to allow config files
+// to run commands:
+//
+//	config.RegisterSecretResolver("exec", config.ExecResolver())
+//
+// after which "exec://pass show db" runs `pass show db`.
+func ExecResolver() SecretResolver {
+	return SecretResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		args := strings.Fields(ref)
+		if len(args) == 0 {
+			return "", fmt.Errorf("empty command")
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = &stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("running %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+
+		return strings.TrimRight(string(out), "\r\n"), nil
+	})
+}
+
+// EnvResolver returns the built-in resolver of the "env" scheme, which
+// returns the value of the environment variable named by the reference.
+func EnvResolver() SecretResolver {
+	return SecretResolverFunc(func(_ context.Context, name string) (string, error) {
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+
+		return val, nil
+	})
+}
+
+// FileResolver returns the built-in resolver of the "file" scheme, which
+// returns the content of the file named by the reference, without a
+// trailing newline.
+func FileResolver() SecretResolver {
+	return SecretResolverFunc(func(_ context.Context, path string) (string, error) {
+		return readSecretFile(path)
+	})
+}
+
+// readSecretFile reads at most maxSecretSize bytes from path and trims
+// trailing newlines with trimNewline.
+func readSecretFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxSecretSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > maxSecretSize {
+		return "", fmt.Errorf("secret file %s exceeds %d bytes", path, maxSecretSize)
+	}
+
+	return trimNewline(data), nil
+}
+
+// trimNewline returns the content of a file holding a single value, such
+// as a secret, without the trailing newline editors and `echo` add. Other
+// whitespace is part of the value.
+func trimNewline(data []byte) string {
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// resolver returns the resolver registered for scheme, falling back to
+// the built-in ones.
+func (c *Config) resolver(scheme string) (SecretResolver, bool) {
+	if r, ok := c.resolvers[scheme]; ok {
+		return r, r != nil
+	}
+
+	r, ok := builtinResolvers[scheme]
+	return r, ok
+}
+
+// parseSecretReference splits s into scheme and reference. The explicit
+// "secret://" form is always a reference; the short form only is when a
+// resolver was registered for its scheme with RegisterSecretResolver.
+func (c *Config) parseSecretReference(s string) (scheme, ref string, ok bool) {
+	if rest, found := strings.CutPrefix(s, secretRefPrefix); found {
+		scheme, ref, _ = strings.Cut(rest, "/")
+		return scheme, ref, true
+	}
+
+	scheme, ref, found := strings.Cut(s, "://")
+	if !found || scheme == "" {
+		return "", "", false
+	}
+
+	if r := c.resolvers[scheme]; r == nil {
+		return "", "", false
+	}
+
+	return scheme, ref, true
+}
+
+// isSecretReference reports whether s would be resolved by resolveSecrets.
+func (c *Config) isSecretReference(s string) bool {
+	_, _, ok := c.parseSecretReference(s)
+	return ok
+}
+
+// resolveSecrets replaces secret references anywhere in the config tree
+// with the values returned by their resolvers, and records the key paths
+// of resolved values so that masking treats them as sensitive.
+func (c *Config) resolveSecrets() error {
+	c.resolvedKeys = make(map[string]bool)
+
+	ctx := context.Background()
+
+	return rewriteStrings(reflect.ValueOf(c).Elem(), "", make(map[pointerKey]bool), func(path, val string) (string, error) {
+		scheme, ref, ok := c.parseSecretReference(val)
+		if !ok {
+			return val, nil
+		}
+
+		r, registered := c.resolver(scheme)
+		if !registered {
+			return "", fmt.Errorf("no secret resolver registered for scheme %q", scheme)
+		}
+
+		resolved, err := r.Resolve(ctx, ref)
+		if err != nil {
+			return "", fmt.Errorf("resolving %s secret: %w", scheme, err)
+		}
+
+		c.resolvedKeys[path] = true
+
+		return resolved, nil
+	})
+}
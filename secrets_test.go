@@ -0,0 +1,288 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resolverService struct {
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	APIKey   string            `yaml:"apiKey"`
+	Headers  map[string]string `yaml:"headers"`
+	Homepage string            `yaml:"homepage"`
+}
+
+// fakeVault is an in-memory stand-in for an external secret manager.
+type fakeVault struct {
+	secrets map[string]string
+	calls   []string
+}
+
+func (v *fakeVault) Resolve(_ context.Context, ref string) (string, error) {
+	v.calls = append(v.calls, ref)
+
+	secret, ok := v.secrets[ref]
+	if !ok {
+		return "", fmt.Errorf("secret %q not found", ref)
+	}
+
+	return secret, nil
+}
+
+func TestBuiltinSecretResolvers(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	t.Setenv("TEST_DB_PASS", "env-db-password")
+	secretFile := createTestConfig(t, tempDir, "api_key", "file-api-key\n")
+
+	configContent := `
+appID: validappid12345
+appSecret: secret://env/TEST_DB_PASS
+logger:
+  logLevel: DEBUG
+service:
+  username: admin
+  password: env://TEST_DB_PASS
+  apiKey: secret://file/` + secretFile + `
+  headers:
+    authorization: secret://file/` + secretFile + `
+  homepage: https://example.com
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	// The short form needs the resolver to be registered.
+	RegisterSecretResolver("env", EnvResolver())
+
+	err := InitServiceConfig(&resolverService{}, configPath)
+	require.NoError(t, err)
+
+	cfg := GetBaseConfig()
+	assert.Equal(t, "env-db-password", cfg.AppSecret)
+
+	svc, err := GetServiceConfig[*resolverService]()
+	require.NoError(t, err)
+	assert.Equal(t, "admin", svc.Username)
+	assert.Equal(t, "env-db-password", svc.Password)
+	assert.Equal(t, "file-api-key", svc.APIKey)
+	assert.Equal(t, "file-api-key", svc.Headers["authorization"])
+	assert.Equal(t, "https://example.com", svc.Homepage)
+}
+
+func TestResolvedSecretsAreMasked(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	t.Setenv("TEST_RESOLVED_PASS", "resolved-pass")
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  username: admin
+  password: secret://env/TEST_RESOLVED_PASS
+  headers:
+    x-token: secret://env/TEST_RESOLVED_PASS
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err := InitServiceConfig(&resolverService{}, configPath)
+	require.NoError(t, err)
+
+	masked, ok := GetSecureCopy().Service.(*resolverService)
+	require.True(t, ok)
+	assert.Equal(t, "admin", masked.Username)
+	assert.Equal(t, maskedValue, masked.Password)
+	assert.Equal(t, maskedValue, masked.Headers["x-token"])
+
+	svc, err := GetServiceConfig[*resolverService]()
+	require.NoError(t, err)
+	assert.Equal(t, "resolved-pass", svc.Password)
+}
+
+func TestRegisterSecretResolver(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	vault := &fakeVault{secrets: map[string]string{"kv/db#password": "vault-pass"}}
+	RegisterSecretResolver("vault", vault)
+
+	configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+service:
+  password: vault://kv/db#password
+  apiKey: secret://vault/kv/db#password
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err := InitServiceConfig(&resolverService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*resolverService]()
+	require.NoError(t, err)
+	assert.Equal(t, "vault-pass", svc.Password)
+	assert.Equal(t, "vault-pass", svc.APIKey)
+	assert.Equal(t, []string{"kv/db#password", "kv/db#password"}, vault.calls)
+}
+
+func TestSecretResolverErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{"unknown scheme", "secret://vault/kv/db", `no secret resolver registered for scheme "vault"`},
+		{"missing env", "secret://env/TEST_SURELY_UNSET_VAR", "service.password: resolving env secret: environment variable TEST_SURELY_UNSET_VAR is not set"},
+		{"missing file", "secret://file//nonexistent/secret", "resolving file secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetGlobalConfig(t)
+			tempDir := setupTestDir(t)
+
+			configContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+service:
+  password: ` + tt.value + `
+`
+			configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+			err := InitServiceConfig(&resolverService{}, configPath)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "resolving secrets")
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnregisterBuiltinResolver(t *testing.T) {
+	resetGlobalConfig(t)
+
+	RegisterSecretResolver("file", nil)
+	RegisterSecretResolver("env", EnvResolver())
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	assert.False(t, globalConfig.isSecretReference("file:///data/app.db"))
+	assert.True(t, globalConfig.isSecretReference("env://HOME"))
+
+	_, registered := globalConfig.resolver("file")
+	assert.False(t, registered)
+}
+
+func TestBuiltinResolversIgnoreShortForm(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	// Ordinary URLs must not be read as secret references, whether they
+	// point at a directory or a regular file.
+	secretFile := createTestConfig(t, tempDir, "data.txt", "file-content\n")
+	configContent := `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  username: env://HOME
+  apiKey: file://` + secretFile + `
+  homepage: file:///tmp
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	require.NoError(t, InitServiceConfig(&resolverService{}, configPath))
+
+	svc, err := GetServiceConfig[*resolverService]()
+	require.NoError(t, err)
+	assert.Equal(t, "env://HOME", svc.Username)
+	assert.Equal(t, "file://"+secretFile, svc.APIKey)
+	assert.Equal(t, "file:///tmp", svc.Homepage)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	assert.Empty(t, globalConfig.resolvedKeys)
+}
+
+func TestExecResolver(t *testing.T) {
+	r := ExecResolver()
+
+	out, err := r.Resolve(context.Background(), "echo exec-secret")
+	require.NoError(t, err)
+	assert.Equal(t, "exec-secret", out)
+
+	_, err = r.Resolve(context.Background(), "")
+	assert.Error(t, err)
+
+	_, err = r.Resolve(context.Background(), "false")
+	assert.Error(t, err)
+}
+
+func TestReadSecretFileTooLarge(t *testing.T) {
+	path := filepath.Join(setupTestDir(t), "big")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", maxSecretSize+1)), 0600))
+
+	_, err := readSecretFile(path)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exceeds")
+	}
+}
+
+func TestSecretReferencesAreNotReportedAsUntagged(t *testing.T) {
+	resetGlobalConfig(t)
+
+	c := &Config{
+		AppSecret: "validappsecret12345",
+		Service:   &resolverService{Password: "secret://env/DB_PASS", APIKey: "secret://vault/api"},
+	}
+
+	keys, err := untaggedSecrets(c)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestProfileKeepsDecryptedAndResolvedValues(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("profile-key"))
+	t.Setenv("TEST_PROFILE_KEY", "resolved-api-key")
+
+	encPass, err := EncryptValue("decrypted-pass")
+	require.NoError(t, err)
+
+	baseContent := `
+appID: validappid12345
+appSecret: validappsecret12345
+environment: prod
+logger:
+  logLevel: DEBUG
+service:
+  username: dev-user
+  password: ` + encPass + `
+  apiKey: secret://env/TEST_PROFILE_KEY
+`
+	createTestConfig(t, tempDir, "config.yaml", baseContent)
+	createTestConfig(t, tempDir, "config.prod.yaml", "service:\n  username: prod-user\n")
+
+	err = InitServiceConfig(&resolverService{}, filepath.Join(tempDir, "config.yaml"))
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*resolverService]()
+	require.NoError(t, err)
+	assert.Equal(t, "prod-user", svc.Username)
+	assert.Equal(t, "decrypted-pass", svc.Password)
+	assert.Equal(t, "resolved-api-key", svc.APIKey)
+}
@@ -0,0 +1,740 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/inovacc/config/internal/document"
+	"github.com/spf13/afero"
+)
+
+// ChangeSource tells what caused a configuration change.
+type ChangeSource string
+
+// Sources of configuration changes.
+const (
+	// ChangeSourceFile is a reload by WatchConfig after the file changed.
+	ChangeSourceFile ChangeSource = "file"
+	// ChangeSourceUpdate is a call to Set or Update.
+	ChangeSourceUpdate ChangeSource = "update"
+)
+
+// ChangeEvent describes a configuration change. It lists the changed keys
+// but not their values, so that events never carry secrets.
+type ChangeEvent struct {
+	Source ChangeSource
+	// Keys are the dotted paths of the changed values, sorted.
+	Keys []string
+}
+
+type subscriber struct {
+	fn func(ChangeEvent)
+}
+
+// Subscribe registers fn to be called after every configuration change:
+// a reload by WatchConfig, or a call to Set or Update. fn runs once the
+// change is applied and without holding the configuration lock, so it may
+// call GetServiceConfig. The returned function cancels the subscription.
+//
+// Example:
+//
+//	unsubscribe := config.Subscribe(func(e config.ChangeEvent) {
+//	    if slices.Contains(e.Keys, "service.featureX") {
+//	        reloadFeatureFlags()
+//	    }
+//	})
+//	defer unsubscribe()
+func Subscribe(fn func(ChangeEvent)) (unsubscribe func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s := &subscriber{fn: fn}
+	globalConfig.subscribers = append(globalConfig.subscribers, s)
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		globalConfig.subscribers = slices.DeleteFunc(globalConfig.subscribers, func(other *subscriber) bool {
+			return other == s
+		})
+	}
+}
+
+// subscriberFuncs returns the current subscribers. The caller must hold mu.
+func (c *Config) subscriberFuncs() []func(ChangeEvent) {
+	fns := make([]func(ChangeEvent), 0, len(c.subscribers))
+	for _, s := range c.subscribers {
+		fns = append(fns, s.fn)
+	}
+
+	return fns
+}
+
+// notify calls subscribers with event if any key changed. It must be
+// called without holding mu.
+func notify(subscribers []func(ChangeEvent), event ChangeEvent) {
+	if len(event.Keys) == 0 {
+		return
+	}
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// UpdateOption configures Set and Update.
+type UpdateOption func(*updateOptions)
+
+type updateOptions struct {
+	persist bool
+}
+
+// WithPersist makes Set and Update write the changed keys back to the
+// configuration file atomically. Only those keys are rewritten, in the
+// profile file if it sets them and in the base file otherwise; comments
+// and key order are kept. Values that were encrypted in the file are
+// encrypted again, and keys resolved from secret references or read from
+// _FILE environment variables cannot be persisted. WatchConfig does not reload the configuration because of
+// this write.
+func WithPersist() UpdateOption {
+	return func(o *updateOptions) {
+		o.persist = true
+	}
+}
+
+// Update changes the configuration at runtime. fn receives a copy of the
+// current configuration to modify; the copy is validated like a loaded
+// file, with default values, the validators added by AddValidator and the
+// schema given with WithSchema, and only then replaces the configuration. If fn or validation fails, nothing
+// changes. Subscribers are notified of the changed keys.
+//
+// Changes live in memory unless WithPersist is given, so a later reload of
+// a modified file replaces them.
+//
+// Example:
+//
+//	err := config.Update(func(c *config.Config) error {
+//	    svc := c.Service.(*MyServiceConfig)
+//	    svc.FeatureX = true
+//	    svc.RateLimit = 200
+//	    return nil
+//	}, config.WithPersist())
+func Update(fn func(*Config) error, opts ...UpdateOption) error {
+	var o updateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	mu.Lock()
+	event, err := globalConfig.update(fn, o)
+	subscribers := globalConfig.subscriberFuncs()
+	mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	notify(subscribers, event)
+
+	return nil
+}
+
+// Set changes the value at a dotted key path, such as "service.port" or
+// "logger.logLevel", through Update. The value is converted to the type of
+// the field like values read from a file, so strings like "30s" work for
+// durations.
+//
+// Example:
+//
+//	err := config.Set("service.port", 9090, config.WithPersist())
+func Set(key string, value any, opts ...UpdateOption) error {
+	return Update(func(c *Config) error {
+		return setKeyPath(reflect.ValueOf(c).Elem(), key, value)
+	}, opts...)
+}
+
+// update applies fn to a copy of c, validates it and commits it.
+func (c *Config) update(fn func(*Config) error, o updateOptions) (ChangeEvent, error) {
+	candidate := copyConfig(c)
+
+	if err := fn(&candidate); err != nil {
+		return ChangeEvent{}, fmt.Errorf("updating config: %w", err)
+	}
+
+	if err := candidate.defaultValues(); err != nil {
+		return ChangeEvent{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if err := candidate.runValidators(); err != nil {
+		return ChangeEvent{}, fmt.Errorf("custom validation: %w", err)
+	}
+
+	changes := diffData(flattenConfig(c), flattenConfig(&candidate))
+	if len(changes) == 0 {
+		return ChangeEvent{}, nil
+	}
+
+	// A change the schema rejects would fail the next start or reload.
+	if err := c.validateChanges(changes); err != nil {
+		return ChangeEvent{}, err
+	}
+
+	var written []byte
+	if o.persist {
+		var err error
+		if written, err = c.persistChanges(changes); err != nil {
+			return ChangeEvent{}, fmt.Errorf("persisting config: %w", err)
+		}
+	}
+
+	c.commit(&candidate)
+
+	if written != nil {
+		c.ownWrite = sha256.Sum256(written)
+	}
+
+	event := ChangeEvent{Source: ChangeSourceUpdate}
+	for _, change := range changes {
+		event.Keys = append(event.Keys, change.Key)
+	}
+
+	slog.Info("Configuration updated", "keys", event.Keys, "persisted", o.persist)
+	logConfigLocked()
+
+	return event, nil
+}
+
+// copyConfig returns a deep copy of the exported fields of c. Secrets are
+// shared with c.
+func copyConfig(c *Config) Config {
+	return deepCopy(reflect.ValueOf(*c), make(map[uintptr]reflect.Value)).Interface().(Config)
+}
+
+// commit replaces c with candidate. The service config is copied into the
+// existing service struct, so that pointers returned by GetServiceConfig
+// see the change, and secrets no longer in use are wiped.
+func (c *Config) commit(candidate *Config) {
+	old := collectSecrets(c)
+
+	service := c.Service
+	*c = *candidate
+
+	dst, src := reflect.ValueOf(service), reflect.ValueOf(candidate.Service)
+	if dst.Kind() == reflect.Ptr && !dst.IsNil() && src.Type() == dst.Type() && !src.IsNil() {
+		dst.Elem().Set(src.Elem())
+		c.Service = service
+	}
+
+	wipeReplacedSecrets(old, c)
+}
+
+// deepCopy returns a deep copy of rv. Unexported struct fields are copied
+// shallowly and Secrets keep their storage.
+func deepCopy(rv reflect.Value, seen map[uintptr]reflect.Value) reflect.Value {
+	if rv.Type() == secretType {
+		return rv
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return rv
+		}
+		if cp, ok := seen[rv.Pointer()]; ok {
+			return cp
+		}
+
+		cp := reflect.New(rv.Type().Elem())
+		seen[rv.Pointer()] = cp
+		cp.Elem().Set(deepCopy(rv.Elem(), seen))
+		return cp
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return rv
+		}
+
+		cp := reflect.New(rv.Type()).Elem()
+		cp.Set(deepCopy(rv.Elem(), seen))
+		return cp
+
+	case reflect.Struct:
+		cp := reflect.New(rv.Type()).Elem()
+		cp.Set(rv)
+
+		for i := range rv.NumField() {
+			if rv.Type().Field(i).IsExported() {
+				cp.Field(i).Set(deepCopy(rv.Field(i), seen))
+			}
+		}
+		return cp
+
+	case reflect.Slice:
+		if rv.IsNil() {
+			return rv
+		}
+
+		cp := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		for i := range rv.Len() {
+			cp.Index(i).Set(deepCopy(rv.Index(i), seen))
+		}
+		return cp
+
+	case reflect.Array:
+		cp := reflect.New(rv.Type()).Elem()
+		for i := range rv.Len() {
+			cp.Index(i).Set(deepCopy(rv.Index(i), seen))
+		}
+		return cp
+
+	case reflect.Map:
+		if rv.IsNil() {
+			return rv
+		}
+
+		cp := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), deepCopy(iter.Value(), seen))
+		}
+		return cp
+
+	default:
+		return rv
+	}
+}
+
+// flattenConfig returns the values of c by dotted key path, in the form
+// they take in a config file.
+func flattenConfig(c *Config) map[string]any {
+	out := make(map[string]any)
+	flattenData("", plainData(reflect.ValueOf(c).Elem(), make(map[uintptr]bool)), out)
+
+	// Unset values are left out, so that filling a nil map reports only
+	// the added keys.
+	for key, value := range out {
+		if value == nil {
+			delete(out, key)
+		}
+	}
+
+	return out
+}
+
+// plainData converts rv into the generic form of config file data: maps
+// keyed like configuration keys, lists, and scalars. Secrets are revealed,
+// so the result must not leave the package.
+func plainData(rv reflect.Value, seen map[uintptr]bool) any {
+	if !rv.IsValid() {
+		return nil
+	}
+
+	switch rv.Type() {
+	case secretType:
+		return rv.Interface().(Secret).Reveal()
+	case reflect.TypeOf(time.Duration(0)):
+		return time.Duration(rv.Int()).String()
+	}
+
+	if m, ok := rv.Interface().(encoding.TextMarshaler); ok && rv.Kind() != reflect.Ptr && rv.Kind() != reflect.Interface {
+		if text, err := m.MarshalText(); err == nil {
+			return string(text)
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() || seen[rv.Pointer()] {
+			return nil
+		}
+		seen[rv.Pointer()] = true
+		defer delete(seen, rv.Pointer())
+		return plainData(rv.Elem(), seen)
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return plainData(rv.Elem(), seen)
+
+	case reflect.Struct:
+		out := make(map[string]any)
+		rt := rv.Type()
+		for i := range rt.NumField() {
+			field := rt.Field(i)
+			name := document.FieldKey(field)
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			out[name] = plainData(rv.Field(i), seen)
+		}
+		return out
+
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		out := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = plainData(iter.Value(), seen)
+		}
+		return out
+
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		out := make([]any, rv.Len())
+		for i := range rv.Len() {
+			out[i] = plainData(rv.Index(i), seen)
+		}
+		return out
+
+	default:
+		return rv.Interface()
+	}
+}
+
+// setKeyPath sets the value at the dotted key path under rv, converting
+// value to the target type like values decoded from a config file. Keys
+// are matched case-insensitively against field keys and map keys.
+func setKeyPath(rv reflect.Value, key string, value any) error {
+	parts := strings.Split(key, ".")
+
+	for i, part := range parts {
+		for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+			if rv.IsNil() {
+				return fmt.Errorf("setting %s: %s is nil", key, strings.Join(parts[:i], "."))
+			}
+			rv = rv.Elem()
+		}
+
+		last := i == len(parts)-1
+
+		switch rv.Kind() {
+		case reflect.Struct:
+			idx := -1
+			for j := range rv.NumField() {
+				field := rv.Type().Field(j)
+				if field.IsExported() && document.FieldKey(field) != "-" && strings.EqualFold(document.FieldKey(field), part) {
+					idx = j
+					break
+				}
+			}
+			if idx < 0 {
+				return fmt.Errorf("setting %s: unknown key %s", key, strings.Join(parts[:i+1], "."))
+			}
+			rv = rv.Field(idx)
+			if last {
+				return decodeInto(rv, key, value)
+			}
+
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				return fmt.Errorf("setting %s: %s has non-string keys", key, strings.Join(parts[:i], "."))
+			}
+
+			mapKey := reflect.ValueOf(part).Convert(rv.Type().Key())
+			iter := rv.MapRange()
+			for iter.Next() {
+				if strings.EqualFold(iter.Key().String(), part) {
+					mapKey = iter.Key()
+					break
+				}
+			}
+
+			if rv.IsNil() {
+				rv.Set(reflect.MakeMap(rv.Type()))
+			}
+
+			// Map elements are not addressable: update a copy and store it.
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if existing := rv.MapIndex(mapKey); existing.IsValid() {
+				elem.Set(existing)
+			}
+
+			var err error
+			if last {
+				err = decodeInto(elem, key, value)
+			} else {
+				err = setKeyPath(elem, strings.Join(parts[i+1:], "."), value)
+			}
+			if err != nil {
+				return err
+			}
+
+			rv.SetMapIndex(mapKey, elem)
+			return nil
+
+		default:
+			return fmt.Errorf("setting %s: %s is not a map or struct", key, strings.Join(parts[:i], "."))
+		}
+	}
+
+	return nil
+}
+
+// decodeInto sets rv to value, converted with the decode hooks used to
+// read config files.
+func decodeInto(rv reflect.Value, key string, value any) error {
+	target := reflect.New(rv.Type())
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       decodeHook(),
+		WeaklyTypedInput: true,
+		Result:           target.Interface(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = decoder.Decode(value); err != nil {
+		return fmt.Errorf("setting %s: %w", key, err)
+	}
+
+	rv.Set(target.Elem())
+
+	return nil
+}
+
+// configFileData is the raw content of a config file being patched.
+type configFileData struct {
+	path     string
+	original []byte
+	data     map[string]any
+	changed  bool
+}
+
+// persistChanges writes changes to the config files, in the profile file
+// when it sets the key and in the base file otherwise. It returns the new
+// content of the base file, or nil if it was not written.
+func (c *Config) persistChanges(changes []MigrationChange) ([]byte, error) {
+	if c.ConfigFile == "" {
+		return nil, fmt.Errorf("no config file loaded: call InitServiceConfig first")
+	}
+
+	afs := afero.NewOsFs()
+
+	base, err := readConfigFileData(afs, c.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*configFileData{base}
+
+	var profile *configFileData
+	if path := c.profileFile(); path != "" && exists(afs, path) {
+		if profile, err = readConfigFileData(afs, path); err != nil {
+			return nil, err
+		}
+		files = append(files, profile)
+	}
+
+	for _, change := range changes {
+		if c.resolvedKeys[change.Key] {
+			return nil, fmt.Errorf("cannot persist %s: its value comes from a secret reference", change.Key)
+		}
+		if c.envFileKeys[change.Key] {
+			return nil, fmt.Errorf("cannot persist %s: its value comes from a _FILE environment variable", change.Key)
+		}
+
+		target := base
+		if profile != nil {
+			if _, ok := lookupData(profile.data, change.Key); ok {
+				target = profile
+			}
+		}
+
+		if err = c.persistChange(target, change); err != nil {
+			return nil, err
+		}
+	}
+
+	// Both files are written, or neither: a failed profile write must not
+	// leave a base file that disagrees with the configuration in memory.
+	var (
+		written []byte
+		updates []document.File
+	)
+	for _, f := range files {
+		if !f.changed {
+			continue
+		}
+
+		content, err := patchContent(f.path, f.original, f.data)
+		if err != nil {
+			return nil, err
+		}
+
+		if f == base {
+			written = content
+		}
+
+		updates = append(updates, document.File{Path: f.path, Content: content})
+	}
+
+	if err = document.WriteFiles(updates...); err != nil {
+		return nil, fmt.Errorf("writing config files: %w", err)
+	}
+
+	for _, f := range updates {
+		slog.Info("Persisted configuration update", "file", f.Path)
+	}
+
+	return written, nil
+}
+
+// persistChange applies change to the data of f. A value that is
+// encrypted in the file is encrypted again, bound to its key path if the
+// old value was.
+func (c *Config) persistChange(f *configFileData, change MigrationChange) error {
+	f.changed = true
+
+	old, _ := lookupData(f.data, change.Key)
+	oldString, _ := old.(string)
+
+	if c.isSecretReference(oldString) {
+		return fmt.Errorf("cannot persist %s: its value is a secret reference in %s", change.Key, f.path)
+	}
+
+	if change.Op == ChangeRemoved {
+		deleteData(f.data, change.Key)
+		return nil
+	}
+
+	value := change.New
+	if IsEncryptedValue(oldString) {
+		var aad []byte
+		if IsKeyBoundValue(oldString) {
+			aad = keyPathAAD(change.Key)
+		}
+
+		content, err := c.encrypter().seal(fmt.Sprint(value), aad)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %w", change.Key, err)
+		}
+
+		if aad != nil {
+			content = keyBindingPrefix + content
+		}
+		value = encPrefix + content + encSuffix
+	}
+
+	setData(f.data, change.Key, value)
+
+	return nil
+}
+
+func readConfigFileData(afs afero.Fs, path string) (*configFileData, error) {
+	original, err := afero.ReadFile(afs, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	data, err := parseConfigData(original)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &configFileData{path: path, original: original, data: data}, nil
+}
+
+// lookupData returns the value at the dotted key path in data, matching
+// keys case-insensitively.
+func lookupData(data map[string]any, key string) (any, bool) {
+	var current any = data
+
+	for _, part := range strings.Split(key, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		k, ok := dataKey(m, part)
+		if !ok {
+			return nil, false
+		}
+		current = m[k]
+	}
+
+	return current, true
+}
+
+// setData sets the value at the dotted key path in data, creating maps as
+// needed and keeping the case of existing keys.
+func setData(data map[string]any, key string, value any) {
+	parts := strings.Split(key, ".")
+
+	m := data
+	for _, part := range parts[:len(parts)-1] {
+		k, ok := dataKey(m, part)
+		next, isMap := m[k].(map[string]any)
+		if !ok || !isMap {
+			k, next = part, make(map[string]any)
+			m[k] = next
+		}
+		m = next
+	}
+
+	last := parts[len(parts)-1]
+	if k, ok := dataKey(m, last); ok {
+		last = k
+	}
+	m[last] = value
+}
+
+// deleteData removes the value at the dotted key path in data.
+func deleteData(data map[string]any, key string) {
+	parent, name := data, key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		value, ok := lookupData(data, key[:i])
+		if parent, ok = value.(map[string]any); !ok {
+			return
+		}
+		name = key[i+1:]
+	}
+
+	if k, ok := dataKey(parent, name); ok {
+		delete(parent, k)
+	}
+}
+
+// dataKey returns the key of m matching key case-insensitively.
+func dataKey(m map[string]any, key string) (string, bool) {
+	if _, ok := m[key]; ok {
+		return key, true
+	}
+
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+// isOwnWrite reports whether the config file holds the content last
+// written by Set or Update, so that WatchConfig need not reload it.
+func (c *Config) isOwnWrite() bool {
+	if c.ownWrite == [sha256.Size]byte{} {
+		return false
+	}
+
+	content, err := os.ReadFile(c.ConfigFile)
+	if err != nil {
+		return false
+	}
+
+	return sha256.Sum256(content) == c.ownWrite
+}
@@ -0,0 +1,403 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tunableService struct {
+	Username string            `yaml:"username"`
+	Password Secret            `yaml:"password"`
+	Port     int               `yaml:"port"`
+	Timeout  time.Duration     `yaml:"timeout"`
+	Features map[string]bool   `yaml:"features"`
+	Limits   map[string]int    `yaml:"limits"`
+	Hosts    []string          `yaml:"hosts"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+const tunableConfig = `# Service configuration
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+
+# Connection settings
+service:
+  username: alice # the service account
+  password: plain-pass
+  port: 8080
+  timeout: 5s
+  features:
+    beta: false
+  hosts:
+    - a.local
+`
+
+// subscribeEvents records the events published during the test.
+func subscribeEvents(t *testing.T) chan ChangeEvent {
+	events := make(chan ChangeEvent, 10)
+	t.Cleanup(Subscribe(func(e ChangeEvent) { events <- e }))
+
+	return events
+}
+
+func TestSet(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", tunableConfig)
+
+	err := InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*tunableService]()
+	require.NoError(t, err)
+
+	events := subscribeEvents(t)
+
+	require.NoError(t, Set("service.username", "bob"))
+	assert.Equal(t, "bob", svc.Username, "pointers from GetServiceConfig see the change")
+	assert.Equal(t, ChangeEvent{Source: ChangeSourceUpdate, Keys: []string{"service.username"}}, <-events)
+
+	// Values are converted like values read from a file.
+	require.NoError(t, Set("service.Port", "9090"))
+	require.NoError(t, Set("service.timeout", "30s"))
+	require.NoError(t, Set("service.features.beta", true))
+	require.NoError(t, Set("service.limits.api", 5))
+	require.NoError(t, Set("service.hosts", "a.local,b.local"))
+	require.NoError(t, Set("logger.logLevel", "INFO"))
+
+	assert.Equal(t, 9090, svc.Port)
+	assert.Equal(t, 30*time.Second, svc.Timeout)
+	assert.Equal(t, map[string]bool{"beta": true}, svc.Features)
+	assert.Equal(t, map[string]int{"api": 5}, svc.Limits)
+	assert.Equal(t, []string{"a.local", "b.local"}, svc.Hosts)
+	assert.Equal(t, "INFO", GetBaseConfig().Logger.LogLevel)
+
+	// Setting the current value is not a change.
+	require.NoError(t, Set("service.port", 9090))
+
+	var keys [][]string
+	for len(events) > 0 {
+		keys = append(keys, (<-events).Keys)
+	}
+	assert.Equal(t, [][]string{
+		{"service.port"},
+		{"service.timeout"},
+		{"service.features.beta"},
+		{"service.limits.api"},
+		{"service.hosts"},
+		{"logger.logLevel"},
+	}, keys)
+
+	// Without WithPersist the file is left alone.
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, tunableConfig, string(content))
+}
+
+func TestSetWipesReplacedSecret(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", tunableConfig)
+
+	err := InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*tunableService]()
+	require.NoError(t, err)
+	old := svc.Password
+
+	require.NoError(t, Set("service.username", "bob"))
+	assert.Equal(t, "plain-pass", old.Reveal(), "secrets that did not change are kept")
+
+	require.NoError(t, Set("service.password", "new-pass"))
+	assert.Equal(t, "new-pass", svc.Password.Reveal())
+	assert.True(t, old.IsZero(), "replaced secret must be wiped")
+}
+
+func TestUpdateRejectedLeavesConfigUnchanged(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", tunableConfig)
+
+	AddValidator(func(c Config) error {
+		if c.Service.(*tunableService).Port < 1024 {
+			return errors.New("port must be at least 1024")
+		}
+		return nil
+	})
+
+	err := InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	events := subscribeEvents(t)
+
+	err = Set("service.port", 80)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "port must be at least 1024")
+
+	err = Set("logger.logLevel", "LOUD")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown log level")
+
+	err = Set("service.missing", 1)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown key service.missing")
+
+	err = Set("service.port", "not-a-number")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "setting service.port")
+
+	err = Update(func(c *Config) error {
+		c.Service.(*tunableService).Username = "changed"
+		return errors.New("aborted")
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "aborted")
+
+	svc, err := GetServiceConfig[*tunableService]()
+	require.NoError(t, err)
+	assert.Equal(t, 8080, svc.Port)
+	assert.Equal(t, "alice", svc.Username)
+	assert.Equal(t, "DEBUG", GetBaseConfig().Logger.LogLevel)
+	assert.Empty(t, events)
+}
+
+func TestUpdateRejectedBySchema(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	configContent := `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  host: db.local
+  port: 5432
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	svc := &portService{}
+	require.NoError(t, InitServiceConfig(svc, configPath, WithSchema([]byte(portSchema))))
+
+	events := subscribeEvents(t)
+
+	err := Set("service.port", 80, WithPersist())
+	var schemaErr *SchemaError
+	require.ErrorAs(t, err, &schemaErr)
+	assert.Contains(t, err.Error(), "/service/port: must be >= 1024")
+
+	assert.Equal(t, 5432, svc.Port)
+	assert.Empty(t, events)
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(content))
+
+	require.NoError(t, Set("service.port", 6432, WithPersist()))
+	assert.Equal(t, 6432, svc.Port)
+}
+
+func TestUpdatePersist(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEncryptionKey([]byte("update-persist-key"))
+	encPass, err := EncryptValueForKey("service.password", "old-pass")
+	require.NoError(t, err)
+
+	configContent := `# Service configuration
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+
+# Connection settings
+service:
+  username: alice # the service account
+  password: ` + encPass + ` # rotated monthly
+  port: 8080
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err = InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	err = Update(func(c *Config) error {
+		svc := c.Service.(*tunableService)
+		svc.Port = 9090
+		svc.Password = NewSecret("new-pass")
+		svc.Labels = map[string]string{"team": "core"}
+		return nil
+	}, WithPersist())
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+
+	doc, err := parseConfigData(content)
+	require.NoError(t, err)
+	newPass := doc["service"].(map[string]any)["password"].(string)
+	assert.True(t, IsKeyBoundValue(newPass), "encrypted values are encrypted again")
+
+	plain, err := DecryptValueForKey("service.password", newPass)
+	require.NoError(t, err)
+	assert.Equal(t, "new-pass", plain)
+
+	assert.Equal(t, `# Service configuration
+appID: validappid12345
+appSecret: validappsecret12345
+logger:
+  logLevel: DEBUG
+
+# Connection settings
+service:
+  username: alice # the service account
+  password: `+newPass+` # rotated monthly
+  port: 9090
+  labels:
+    team: core
+`, string(content))
+
+	// The persisted file loads to the same values.
+	err = InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	svc, err := GetServiceConfig[*tunableService]()
+	require.NoError(t, err)
+	assert.Equal(t, 9090, svc.Port)
+	assert.Equal(t, "new-pass", svc.Password.Reveal())
+	assert.Equal(t, map[string]string{"team": "core"}, svc.Labels)
+}
+
+func TestSetPersistProfile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	baseContent := `appID: validappid12345
+appSecret: validappsecret12345
+environment: prod
+service:
+  username: alice
+  port: 8080
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", baseContent)
+	profilePath := createTestConfig(t, tempDir, "config.prod.yaml", "service:\n  port: 443\n")
+
+	err := InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	require.NoError(t, Set("service.port", 8443, WithPersist()))
+	require.NoError(t, Set("service.username", "bob", WithPersist()))
+
+	profile, err := os.ReadFile(profilePath)
+	require.NoError(t, err)
+	assert.Equal(t, "service:\n  port: 8443\n", string(profile), "keys set by the profile are written there")
+
+	base, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(base), "username: bob")
+	assert.Contains(t, string(base), "port: 8080")
+}
+
+func TestSetPersistSecretReference(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	t.Setenv("UPDATE_TEST_PASSWORD", "from-env")
+
+	configContent := `appID: validappid12345
+appSecret: validappsecret12345
+service:
+  password: secret://env/UPDATE_TEST_PASSWORD
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	err := InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	err = Set("service.password", "other", WithPersist())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot persist service.password")
+
+	svc, err := GetServiceConfig[*tunableService]()
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", svc.Password.Reveal())
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(content))
+
+	// In memory only, the value can still be changed.
+	require.NoError(t, Set("service.password", "other"))
+	assert.Equal(t, "other", svc.Password.Reveal())
+}
+
+func TestSetPersistEnvFile(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+
+	SetEnvPrefix("APP")
+	t.Setenv("APP_APPSECRET_FILE", writeSecretFile(t, tempDir, "app_secret", "docker-secret-value\n"))
+
+	configContent := `appID: validappid12345
+appSecret: validappsecret12345
+`
+	configPath := createTestConfig(t, tempDir, "config.yaml", configContent)
+
+	require.NoError(t, InitServiceConfig(&tunableService{}, configPath))
+	require.Equal(t, "docker-secret-value", GetBaseConfig().AppSecret)
+
+	err := Set("appSecret", "replaced-secret-value", WithPersist())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot persist appSecret")
+	assert.Equal(t, "docker-secret-value", GetBaseConfig().AppSecret)
+
+	content, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, configContent, string(content))
+}
+
+func TestSetPersistDoesNotReload(t *testing.T) {
+	resetGlobalConfig(t)
+	tempDir := setupTestDir(t)
+	configPath := createTestConfig(t, tempDir, "config.yaml", tunableConfig)
+
+	err := InitServiceConfig(&tunableService{}, configPath)
+	require.NoError(t, err)
+
+	reloaded := make(chan struct{}, 10)
+	WatchConfig(func() { reloaded <- struct{}{} })
+
+	events := subscribeEvents(t)
+
+	require.NoError(t, Set("service.port", 9090, WithPersist()))
+	assert.Equal(t, ChangeEvent{Source: ChangeSourceUpdate, Keys: []string{"service.port"}}, <-events)
+
+	select {
+	case <-reloaded:
+		t.Fatal("own write must not trigger a reload")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Changes made by others are still picked up.
+	updated, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(configPath, append(updated, "  limits:\n    api: 3\n"...), 0644))
+
+	select {
+	case e := <-events:
+		assert.Equal(t, ChangeEvent{Source: ChangeSourceFile, Keys: []string{"service.limits.api"}}, e)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for config reload")
+	}
+
+	svc, err := GetServiceConfig[*tunableService]()
+	require.NoError(t, err)
+	assert.Equal(t, 9090, svc.Port)
+	assert.Equal(t, map[string]int{"api": 3}, svc.Limits)
+}